@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// With Config.AcceptRate set, the accept loop starts at most that many
+// connections a second, so a reconnect storm cannot take the CPU the
+// broadcasts need. Up to Config.AcceptQueue connections wait their turn;
+// beyond that they are told the server is busy and closed at once.
+
+// admitLoop starts the connections in queue no faster than rate a second,
+// until the server stops.
+func (s *Server) admitLoop(queue <-chan net.Conn, rate int) {
+	interval := time.Second / time.Duration(rate)
+	next := time.Now()
+	for {
+		select {
+		case <-s.quitch:
+			return
+		case conn := <-queue:
+			if now := time.Now(); next.After(now) {
+				time.Sleep(next.Sub(now))
+			} else {
+				next = now
+			}
+			next = next.Add(interval)
+			go s.handleConn(conn)
+		}
+	}
+}
+
+// refuseConn tells conn the server is too busy to take it and closes it,
+// without waiting on a client that does not read.
+func (s *Server) refuseConn(conn net.Conn) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte(s.errorText(newClientError(ErrCodeBusy, "Too many new connections, please try again in a moment.")) + "\n"))
+	s.emitEvent(Event{Type: EventAuthFailure, Conn: newConnID(), Addr: remoteAddr(conn), Reason: "accept rate exceeded", Code: ErrCodeBusy})
+	conn.Close()
+}
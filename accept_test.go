@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// Test that connections beyond the accept rate and queue are refused
+// straight away while the first is let in
+func TestAcceptRate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AcceptRate = 1
+	cfg.AcceptQueue = 0
+	server := newTestServer(t, cfg)
+	server.listenAddr = "127.0.0.1:0"
+	go server.Start()
+	defer server.Stop()
+
+	var addr net.Addr
+	for range 50 {
+		if addr = server.Addr(); addr != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var readers []*bufio.Reader
+	for range 5 {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		readers = append(readers, bufio.NewReader(conn))
+	}
+
+	if err := readUntil(readers[0], namePrompt); err != nil {
+		t.Errorf("Expected the first connection to be asked for a name: %v", err)
+	}
+	if line, _ := readers[4].ReadString('\n'); !containsSubstring(line, ErrCodeBusy+": Too many new connections") {
+		t.Errorf("Expected the last connection to be refused, got %q", line)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// unixPrefix marks a listen address as a Unix socket path, e.g.
+// "unix:/run/netcat.sock".
+const unixPrefix = "unix:"
+
+// ValidateListenAddr checks an address the server can listen on: a port
+// alone (":8989"), a host and port ("127.0.0.1:8989", "[::1]:8989",
+// "chat.local:8989") or a Unix socket ("unix:/run/netcat.sock"). Port 0
+// picks a free port.
+func ValidateListenAddr(addr string) error {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		if path == "" {
+			return errors.New("listen address " + addr + ": missing socket path")
+		}
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("listen address %q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("listen address %q: port must be a number from 0 to 65535", addr)
+	}
+	if host != "" && net.ParseIP(host) == nil && !validHostname(host) {
+		return fmt.Errorf("listen address %q: invalid host %q", addr, host)
+	}
+	return nil
+}
+
+// validHostname reports whether host is a syntactically valid DNS name.
+func validHostname(host string) bool {
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// listenNetwork splits a listen address into the network and address to
+// pass to net.Listen.
+func listenNetwork(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// unixConns numbers connections over Unix sockets, which have no remote
+// address of their own.
+var unixConns atomic.Uint64
+
+// remoteAddr returns an address identifying conn's peer. Clients are
+// tracked by address, so Unix socket peers are given unique ones.
+func remoteAddr(conn net.Conn) string {
+	if conn.RemoteAddr() == nil || conn.RemoteAddr().Network() == "unix" {
+		return unixPrefix + "#" + strconv.FormatUint(unixConns.Add(1), 10)
+	}
+	return conn.RemoteAddr().String()
+}
+
+// parsePortRange parses a range of ports such as "8990-8999", or a single
+// port.
+func parsePortRange(r string) (first, last int, err error) {
+	lo, hi, isRange := strings.Cut(r, "-")
+	if !isRange {
+		hi = lo
+	}
+	first, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	last, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil || first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("port range %q: want ports like 8990-8999", r)
+	}
+	return first, last, nil
+}
+
+// listen listens on addr. If its port is taken and Config.PortFallback is
+// set, each port in that range is tried in turn on the same host.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	ln, err := s.listenOn(listenNetwork(addr))
+	if err == nil || s.config.PortFallback == "" || strings.HasPrefix(addr, unixPrefix) || !errors.Is(err, syscall.EADDRINUSE) {
+		return ln, err
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	first, last, rangeErr := parsePortRange(s.config.PortFallback)
+	if rangeErr != nil {
+		return nil, rangeErr
+	}
+	for port := first; port <= last; port++ {
+		fallback := net.JoinHostPort(host, strconv.Itoa(port))
+		if ln, fallbackErr := s.listenOn("tcp", fallback); fallbackErr == nil {
+			fmt.Printf("%s is in use, listening on %s instead\n", addr, fallback)
+			return ln, nil
+		}
+	}
+	return nil, fmt.Errorf("%w, and so is every port in %s", err, s.config.PortFallback)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateListenAddr(t *testing.T) {
+	for _, addr := range []string{":8989", ":0", "0.0.0.0:8989", "127.0.0.1:2525", "[::1]:8989", "chat.local:8989", "unix:/run/netcat.sock"} {
+		if err := ValidateListenAddr(addr); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"8989", ":", ":http", ":65536", "::1:8989", "bad_host:8989", "unix:", "-x.local:1"} {
+		if err := ValidateListenAddr(addr); err == nil {
+			t.Errorf("Expected %q to be rejected", addr)
+		}
+	}
+}
+
+// Test that the server can listen on a Unix socket and tell its clients
+// apart
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.sock")
+	server := newTestServer(t, DefaultConfig())
+	server.listenAddr = unixPrefix + path
+	go server.Start()
+	defer server.Stop()
+
+	join := func(name string) net.Conn {
+		var conn net.Conn
+		var err error
+		for range 50 {
+			if conn, err = net.Dial("unix", path); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		if err := readUntil(reader, namePrompt); err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(name + "\n"))
+		if err := readUntil(reader, "["+name+"]:"); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	alice := join("Alice")
+	defer alice.Close()
+	bob := join("Bob")
+	bob.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	if who := server.whoList(false); !strings.Contains(who, "Alice") || strings.Contains(who, "Bob") {
+		t.Errorf("Expected only Alice to remain connected, got %q", who)
+	}
+}
+
+func TestListenArg(t *testing.T) {
+	tests := map[string]string{
+		"2525":                ":2525",
+		"127.0.0.1:2525":      "127.0.0.1:2525",
+		"[::1]:8989":          "[::1]:8989",
+		"unix:/tmp/chat.sock": "unix:/tmp/chat.sock",
+	}
+	for arg, want := range tests {
+		if got := listenArg(arg); got != want {
+			t.Errorf("listenArg(%q) = %q, want %q", arg, got, want)
+		}
+	}
+}
+
+// Test that a taken port falls back to the first free one in the range
+func TestListenPortFallback(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	server := newTestServer(t, DefaultConfig())
+	server.listenAddr = taken.Addr().String()
+	if _, err := server.listen(server.listenAddr); err == nil {
+		t.Fatalf("Expected listening on a taken port to fail without a fallback")
+	}
+
+	server.config.PortFallback = strconv.Itoa(port+1) + "-" + strconv.Itoa(port+50)
+	ln, err := server.listen(server.listenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	got := ln.Addr().(*net.TCPAddr)
+	if got.Port <= port || got.Port > port+50 || !got.IP.IsLoopback() {
+		t.Errorf("Expected a loopback port in the fallback range, got %s", got)
+	}
+}
+
+// Test that a server started on port 0 reports the port it got
+func TestAddrReportsEphemeralPort(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.listenAddr = "127.0.0.1:0"
+	if server.Addr() != nil {
+		t.Errorf("Expected no address before Start")
+	}
+	go server.Start()
+	defer server.Stop()
+
+	var addr net.Addr
+	for range 50 {
+		if addr = server.Addr(); addr != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if addr == nil || addr.(*net.TCPAddr).Port == 0 {
+		t.Fatalf("Expected a real port, got %v", addr)
+	}
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("Expected to reach the server at %s: %v", addr, err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// builtinAliases are short names for common commands. Config.Aliases adds
+// more.
+var builtinAliases = map[string]string{
+	"/h": "/help",
+	"/m": "/msg",
+	"/n": "/name",
+	"/w": "/who",
+}
+
+// resolveAlias returns the command name stands for, or name itself if it
+// is not an alias. Aliases from the config take precedence.
+func (s *Server) resolveAlias(name string) string {
+	if target, ok := s.config.Aliases[name]; ok {
+		return target
+	}
+	if target, ok := builtinAliases[name]; ok {
+		return target
+	}
+	return name
+}
+
+// checkAliases reports an error for an alias that does not start with a
+// slash, hides a command, or stands for a command that is not in
+// available.
+func checkAliases(aliases map[string]string, available map[string]command) error {
+	for alias, target := range aliases {
+		_, hides := available[alias]
+		_, known := available[target]
+		switch {
+		case len(alias) < 2 || alias[0] != '/':
+			return fmt.Errorf("alias %q must start with /", alias)
+		case hides:
+			return fmt.Errorf("alias %s would hide the command of the same name", alias)
+		case !known:
+			return fmt.Errorf("alias %s is for unknown command %s", alias, target)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// budget counts the goroutines doing one kind of work, so that under a
+// connection storm extra work is refused instead of piling up until the
+// process falls over.
+type budget struct {
+	inUse    atomic.Int64
+	peak     atomic.Int64
+	rejected atomic.Uint64
+}
+
+// acquire takes a slot if fewer than limit are in use, and otherwise
+// counts a rejection. A limit of zero or less means no limit. Every
+// successful acquire must be followed by release.
+func (b *budget) acquire(limit int) bool {
+	if !b.take(limit) {
+		b.rejected.Add(1)
+		return false
+	}
+	return true
+}
+
+// wait is acquire, trying again until it succeeds or d has passed.
+func (b *budget) wait(limit int, d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for !b.take(limit) {
+		if time.Now().After(deadline) {
+			b.rejected.Add(1)
+			return false
+		}
+		time.Sleep(budgetRetry)
+	}
+	return true
+}
+
+// budgetRetry is how often wait tries again.
+const budgetRetry = 5 * time.Millisecond
+
+// take takes a slot if fewer than limit are in use.
+func (b *budget) take(limit int) bool {
+	n := b.inUse.Add(1)
+	if limit > 0 && n > int64(limit) {
+		b.inUse.Add(-1)
+		return false
+	}
+	for peak := b.peak.Load(); n > peak && !b.peak.CompareAndSwap(peak, n); peak = b.peak.Load() {
+	}
+	return true
+}
+
+func (b *budget) release() {
+	b.inUse.Add(-1)
+}
+
+// String reports the budget's counters for /stats.
+func (b *budget) String() string {
+	return fmt.Sprintf("%d running, peak %d, %d refused", b.inUse.Load(), b.peak.Load(), b.rejected.Load())
+}
+
+// budgetStats describes every budget and its configured limit.
+func (s *Server) budgetStats() string {
+	limit := func(n int) string {
+		if n <= 0 {
+			return "no limit"
+		}
+		return fmt.Sprintf("limit %d", n)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "handshakes: %v (%s)\n", &s.handshakes, limit(s.config.MaxHandshakes))
+	fmt.Fprintf(&b, "deliveries: %v (%s)\n", &s.deliveries, limit(s.config.MaxDeliveries))
+	fmt.Fprintf(&b, "log writers: %v (%s)", &s.logWriters, limit(s.config.MaxLogWriters))
+	return b.String()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+// Test that a budget refuses work over its limit until a slot is released
+func TestBudget(t *testing.T) {
+	var b budget
+	if !b.acquire(2) || !b.acquire(2) {
+		t.Fatalf("Expected two slots to be available.")
+	}
+	if b.acquire(2) {
+		t.Errorf("Expected a third acquire to be refused.")
+	}
+	b.release()
+	if !b.acquire(2) {
+		t.Errorf("Expected a released slot to be reusable.")
+	}
+	if got := b.String(); got != "2 running, peak 2, 1 refused" {
+		t.Errorf("Unexpected budget stats %q", got)
+	}
+}
+
+// Test that connections over the handshake budget are turned away
+func TestHandshakeBudget(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.config.MaxHandshakes = 1
+	server.handshakes.acquire(1)
+
+	conn, peer := net.Pipe()
+	go server.handleConn(conn)
+
+	reply, _ := bufio.NewReader(peer).ReadString('\n')
+	if reply != "ERR_BUSY: Server is busy, please try again later.\n" {
+		t.Errorf("Expected the connection to be refused, got %q", reply)
+	}
+}
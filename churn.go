@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// When a bot farm restarts or the network hiccups, dozens of clients can
+// join or leave within a second, and one notice each drowns out the
+// conversation. With Config.NoticeBatchWindow set, a notice that follows
+// another within the window is held back, and everything held in that
+// window is then sent as one "5 users joined, 3 left" notice.
+
+// churn collects the join and leave notices being held back.
+type churn struct {
+	mu      sync.Mutex
+	last    time.Time
+	pending []presence
+}
+
+// presence is one join or leave.
+type presence struct {
+	kind   string
+	client Client
+}
+
+// add holds p back if another notice went out less than window before
+// now, reporting whether it did. flush is called once the window holding
+// the first of a batch has passed.
+func (c *churn) add(p presence, now time.Time, window time.Duration, flush func()) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 && now.Sub(c.last) >= window {
+		c.last = now
+		return false
+	}
+	if len(c.pending) == 0 {
+		time.AfterFunc(window, flush)
+	}
+	c.pending = append(c.pending, p)
+	return true
+}
+
+// take returns the notices held back and starts a new window.
+func (c *churn) take(now time.Time) []presence {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	batch := c.pending
+	c.pending = nil
+	c.last = now
+	return batch
+}
+
+// presenceNotice tells everyone that client joined or left, kind being
+// "join" or "leave", unless that notice is turned off. The main chat sees
+// who is online, so it is told even when client is in a room.
+func (s *Server) presenceNotice(kind string, client *Client) {
+	if _, ok := s.notice(kind, client); !ok {
+		return
+	}
+	if client.room != "" {
+		// Notices in a room are not batched, as the summary goes to the
+		// main chat.
+		s.sendNotice(kind, *client)
+		main := *client
+		main.room = ""
+		client = &main
+	}
+	if window := time.Duration(s.config.NoticeBatchWindow); window > 0 {
+		if s.churn.add(presence{kind, *client}, time.Now(), window, s.flushChurn) {
+			return
+		}
+	}
+	s.sendNotice(kind, *client)
+}
+
+// sendNotice broadcasts the kind notice for client now.
+func (s *Server) sendNotice(kind string, client Client) {
+	if text, ok := s.notice(kind, &client); ok {
+		s.broadcastNotice(client, text)
+	}
+}
+
+// flushChurn sends the notices held back as one summary, or as they are
+// if there is just one or the "churn" template is turned off.
+func (s *Server) flushChurn() {
+	now := time.Now()
+	batch := s.churn.take(now)
+
+	joined, left := 0, 0
+	for _, p := range batch {
+		if p.kind == "join" {
+			joined++
+		} else {
+			left++
+		}
+	}
+	text, ok := s.text("churn", map[string]string{
+		"Summary": churnSummary(joined, left),
+		"Time":    now.Format("02-01-2006 15:04:05"),
+		"Room":    s.roomLabel(""),
+		"Online":  s.onlineCount(),
+	})
+	if len(batch) == 1 || !ok {
+		for _, p := range batch {
+			s.sendNotice(p.kind, p.client)
+		}
+		return
+	}
+	s.broadcastNotice(Client{}, text)
+}
+
+// churnSummary describes how many users joined and left, such as
+// "5 users joined, 3 left".
+func churnSummary(joined, left int) string {
+	users := func(n int) string {
+		if n == 1 {
+			return "1 user"
+		}
+		return fmt.Sprintf("%d users", n)
+	}
+	switch {
+	case left == 0:
+		return users(joined) + " joined"
+	case joined == 0:
+		return users(left) + " left"
+	default:
+		return fmt.Sprintf("%s joined, %d left", users(joined), left)
+	}
+}
+
+// isPresenceNotice reports whether line is a join or leave notice sent by
+// name, or a summary of several.
+func (s *Server) isPresenceNotice(line, name string) bool {
+	if name != "" {
+		return s.isNoticeFor(line, name)
+	}
+	re := s.noticePattern("churn", "")
+	return re != nil && re.MatchString(line)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that joins and leaves close together are sent as one summary
+func TestChurnNotices(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.NoticeBatchWindow = Duration(50 * time.Millisecond)
+	server := newTestServer(t, cfg)
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	server.addClient(alice)
+
+	for _, name := range []string{"Bob", "Carol", "Dave"} {
+		c := mockClient(name, "192.168.1.2", nil)
+		server.presenceNotice("join", &c)
+	}
+	erin := mockClient("Erin", "192.168.1.3", nil)
+	server.presenceNotice("leave", &erin)
+
+	if !containsSubstring(output(), "Bob has joined our chat...") {
+		t.Errorf("Expected the first join to be announced at once, got %q", output())
+	}
+	if containsSubstring(output(), "Carol") {
+		t.Errorf("Expected the following joins to be held back, got %q", output())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if !containsSubstring(output(), "2 users joined, 1 left (1 user online)") {
+		t.Errorf("Expected a summary of the held notices, got %q", output())
+	}
+	if !server.isPresenceNotice("2 users joined, 1 left (1 user online)", "") || server.isPresenceNotice("hello there", "") {
+		t.Errorf("Expected only summaries to be recognised as presence notices.")
+	}
+}
+
+func TestChurnSummary(t *testing.T) {
+	for _, tc := range []struct {
+		joined, left int
+		want         string
+	}{
+		{5, 3, "5 users joined, 3 left"},
+		{1, 0, "1 user joined"},
+		{0, 2, "2 users left"},
+	} {
+		if got := churnSummary(tc.joined, tc.left); got != tc.want {
+			t.Errorf("churnSummary(%d, %d) = %q, want %q", tc.joined, tc.left, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"net-cat/pkg/client"
+)
+
+// errUsage is returned by a subcommand run with the wrong arguments, after
+// it has printed how to use it.
+var errUsage = errors.New("usage")
+
+// cliCommand is a net-cat subcommand, such as "serve".
+type cliCommand struct {
+	name string
+	// args describes the arguments after the flags, e.g. "[address]".
+	args  string
+	short string
+	// setup defines the command's flags on fs and returns the function
+	// that runs it with the remaining arguments, writing its output to out.
+	setup func(fs *flag.FlagSet, out io.Writer) func(args []string) error
+}
+
+// cliCommands lists the subcommands in the order help shows them. It is
+// filled in by init so help and completion can refer to it.
+var cliCommands []cliCommand
+
+func init() {
+	cliCommands = []cliCommand{
+		{name: "serve", args: "[address]", short: "run the chat server", setup: serveCmd},
+		{name: "client", args: "<address>", short: "join a chat from the terminal, one line per message", setup: clientCmd},
+		{name: "bench", args: "<address>", short: "measure how fast a server delivers messages", setup: benchCmd},
+		{name: "admin", args: "<address> <command>...", short: "run an operator command and print the reply", setup: adminCmd},
+		{name: "selftest", short: "start a throwaway server and check that chatting, /name and rate limiting work", setup: selftestCmd},
+		{name: "completion", args: "bash|zsh", short: "print a shell completion script", setup: completionCmd},
+		{name: "version", short: "print the version, commit and build date", setup: versionCmd},
+		{name: "help", args: "[command]", short: "show help for a command", setup: helpCmd},
+	}
+}
+
+// findCLICommand returns the subcommand called name.
+func findCLICommand(name string) (cliCommand, bool) {
+	i := slices.IndexFunc(cliCommands, func(c cliCommand) bool { return c.name == name })
+	if i < 0 {
+		return cliCommand{}, false
+	}
+	return cliCommands[i], true
+}
+
+// runCLI runs the command line args, without the program name, and returns
+// the exit status. With no subcommand it serves, so "net-cat 2525" still
+// starts a server on port 2525.
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	name := "serve"
+	if len(args) > 0 {
+		switch args[0] {
+		case "-h", "-help", "--help":
+			args = []string{"help"}
+		case "-version", "--version":
+			args = []string{"version"}
+		}
+		if _, ok := findCLICommand(args[0]); ok {
+			name, args = args[0], args[1:]
+		}
+	}
+	cmd, _ := findCLICommand(name)
+
+	fs := flag.NewFlagSet("net-cat "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { printCommandHelp(stderr, cmd, fs) }
+	run := cmd.setup(fs, stdout)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if err := run(fs.Args()); err != nil {
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		fmt.Fprintln(stderr, "net-cat "+cmd.name+":", err)
+		return 1
+	}
+	return 0
+}
+
+// printCommandHelp describes cmd and its flags.
+func printCommandHelp(w io.Writer, cmd cliCommand, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: net-cat %s", cmd.name)
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprint(w, " [flags]")
+	}
+	if cmd.args != "" {
+		fmt.Fprint(w, " "+cmd.args)
+	}
+	fmt.Fprintf(w, "\n\n%s%s.\n", strings.ToUpper(cmd.short[:1]), cmd.short[1:])
+	if hasFlags {
+		fmt.Fprint(w, "\nFlags:\n")
+		out := fs.Output()
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+		fs.SetOutput(out)
+	}
+}
+
+// printOverview lists every subcommand.
+func printOverview(w io.Writer) {
+	fmt.Fprint(w, "Usage: net-cat <command> [flags] [arguments]\n\nCommands:\n")
+	for _, cmd := range cliCommands {
+		fmt.Fprintf(w, "  %-11s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprint(w, "\nWith no command, net-cat serves. Run \"net-cat help <command>\" for its flags.\n")
+}
+
+func serveCmd(fs *flag.FlagSet, out io.Writer) func([]string) error {
+	configPath := fs.String("config", os.Getenv("NETCAT_CONFIG"), "JSON config file to load")
+	dryRun := fs.Bool("dry-run", false, "check the config, listen address and log file, then exit")
+	return func(args []string) error {
+		if len(args) > 1 {
+			return errUsage
+		}
+		cfg, err := LoadConfig(*configPath)
+		if err != nil {
+			return fmt.Errorf("loading config: %w", err)
+		}
+
+		listen := ":8989"
+		if cfg.Listen != "" {
+			listen = cfg.Listen
+		}
+		if len(args) == 1 {
+			listen = listenArg(args[0])
+			if err := ValidateListenAddr(listen); err != nil {
+				fmt.Fprintln(fs.Output(), err)
+				return errUsage
+			}
+		}
+
+		captureStacks.Store(cfg.Debug)
+
+		server := NewServerWithConfig(listen, cfg)
+		if *dryRun {
+			if err := server.DryRun(out); err != nil {
+				return err
+			}
+			fmt.Fprintln(out, "dry run passed")
+			return nil
+		}
+		stopOnSignal(server)
+		return server.Start()
+	}
+}
+
+func clientCmd(fs *flag.FlagSet, out io.Writer) func([]string) error {
+	name := fs.String("name", os.Getenv("USER"), "name to join the chat with")
+	return func(args []string) error {
+		if len(args) != 1 || *name == "" {
+			return errUsage
+		}
+		c, err := client.Dial(args[0], *name)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				if line := strings.TrimSpace(scanner.Text()); line != "" {
+					if c.Send(line) != nil {
+						return
+					}
+				}
+			}
+			c.Close()
+		}()
+		for ev := range c.Messages() {
+			fmt.Fprintln(out, describeEvent(ev))
+		}
+		if err := c.Err(); !errors.Is(err, client.ErrClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// describeEvent formats ev as one line for the terminal.
+func describeEvent(ev client.Event) string {
+	switch ev := ev.(type) {
+	case client.Message:
+		return fmt.Sprintf("[%s] %s: %s", ev.Time.Format(time.TimeOnly), ev.Name, ev.Text)
+	case client.Join:
+		return "* " + ev.Name + " joined"
+	case client.Leave:
+		return "* " + ev.Name + " left"
+	case client.ErrorReply:
+		return ev.Code + ": " + ev.Text
+	case client.Notice:
+		return ev.Text
+	}
+	return fmt.Sprint(ev)
+}
+
+func benchCmd(fs *flag.FlagSet, out io.Writer) func([]string) error {
+	senders := fs.Int("clients", 5, "how many clients send messages")
+	messages := fs.Int("messages", 100, "how many messages each client sends")
+	size := fs.Int("size", 32, "length of each message in bytes")
+	timeout := fs.Duration("timeout", 30*time.Second, "how long to wait for every message to arrive")
+	return func(args []string) error {
+		if len(args) != 1 || *senders < 1 || *messages < 1 || *size < 1 {
+			return errUsage
+		}
+		stamp := time.Now().Format("150405")
+
+		// One more client listens, and the run is over once it has seen
+		// every message.
+		listener, err := client.Dial(args[0], "bench-"+stamp)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		var clients []*client.Client
+		defer func() {
+			for _, c := range clients {
+				c.Close()
+			}
+		}()
+		for i := range *senders {
+			c, err := client.Dial(args[0], fmt.Sprintf("bench-%s-%d", stamp, i+1))
+			if err != nil {
+				return err
+			}
+			go func() {
+				for range c.Messages() {
+				}
+			}()
+			clients = append(clients, c)
+		}
+
+		text := strings.Repeat("x", *size)
+		start := time.Now()
+		for _, c := range clients {
+			go func() {
+				for range *messages {
+					if c.Send(text) != nil {
+						return
+					}
+				}
+			}()
+		}
+
+		want, received := *senders**messages, 0
+		deadline := time.After(*timeout)
+	wait:
+		for received < want {
+			select {
+			case ev, ok := <-listener.Messages():
+				if !ok {
+					return listener.Err()
+				}
+				// The server reads whatever has arrived as one message, so
+				// under load several can arrive run together.
+				if m, isMessage := ev.(client.Message); isMessage && m.Text != "" && strings.Trim(m.Text, "x") == "" {
+					received += len(m.Text) / *size
+				}
+			case <-deadline:
+				break wait
+			}
+		}
+		elapsed := time.Since(start)
+
+		fmt.Fprintf(out, "%d clients sent %d messages of %d bytes; %d arrived in %s (%.0f messages/s)\n",
+			*senders, want, *size, received, elapsed.Round(time.Millisecond), float64(received)/elapsed.Seconds())
+		if received < want {
+			return fmt.Errorf("%d messages did not arrive within %s", want-received, *timeout)
+		}
+		return nil
+	}
+}
+
+func adminCmd(fs *flag.FlagSet, out io.Writer) func([]string) error {
+	name := fs.String("name", "admin", "name to join the chat with")
+	password := fs.String("password", os.Getenv("NETCAT_OPERATOR_PASSWORD"), "operator password")
+	wait := fs.Duration("wait", time.Second, "how long to wait for more of the reply")
+	return func(args []string) error {
+		if len(args) < 2 {
+			return errUsage
+		}
+		command := strings.Join(args[1:], " ")
+		if !strings.HasPrefix(command, "/") {
+			command = "/" + command
+		}
+
+		c, err := client.Dial(args[0], *name)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		// Skip the history and notices sent on joining.
+		if err := adminReply(c, *wait, io.Discard); err != nil {
+			return err
+		}
+		if *password != "" {
+			if err := c.Send("/oper " + *password); err != nil {
+				return err
+			}
+			if err := adminReply(c, *wait, io.Discard); err != nil {
+				return err
+			}
+		}
+		if err := c.Send(command); err != nil {
+			return err
+		}
+		return adminReply(c, *wait, out)
+	}
+}
+
+// adminReply prints command replies from c to w until none arrive for
+// wait, and fails on an error reply.
+func adminReply(c *client.Client, wait time.Duration, w io.Writer) error {
+	for {
+		select {
+		case ev, ok := <-c.Messages():
+			if !ok {
+				return c.Err()
+			}
+			switch ev := ev.(type) {
+			case client.Notice:
+				fmt.Fprintln(w, ev.Text)
+			case client.ErrorReply:
+				return errors.New(ev.Code + ": " + ev.Text)
+			}
+		case <-time.After(wait):
+			return nil
+		}
+	}
+}
+
+func selftestCmd(fs *flag.FlagSet, out io.Writer) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		if err := selfTest(out); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "selftest passed")
+		return nil
+	}
+}
+
+func completionCmd(fs *flag.FlagSet, out io.Writer) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		var names []string
+		for _, cmd := range cliCommands {
+			names = append(names, cmd.name)
+		}
+		switch args[0] {
+		case "bash":
+			fmt.Fprintf(out, bashCompletion, strings.Join(names, " "))
+		case "zsh":
+			fmt.Fprintf(out, zshCompletion, strings.Join(names, " "))
+		default:
+			return errUsage
+		}
+		return nil
+	}
+}
+
+// bashCompletion and zshCompletion complete subcommand names, and the
+// shells' own file completion handles the rest.
+const bashCompletion = `# bash completion for net-cat; add to ~/.bashrc:
+#   source <(net-cat completion bash)
+_net_cat() {
+	if [ "$COMP_CWORD" -eq 1 ]; then
+		COMPREPLY=($(compgen -W "%s" -- "${COMP_WORDS[1]}"))
+	elif [ "${COMP_WORDS[1]}" = completion ]; then
+		COMPREPLY=($(compgen -W "bash zsh" -- "${COMP_WORDS[COMP_CWORD]}"))
+	fi
+}
+complete -o default -F _net_cat net-cat TCPChat
+`
+
+const zshCompletion = `#compdef net-cat TCPChat
+# zsh completion for net-cat; add to ~/.zshrc:
+#   source <(net-cat completion zsh)
+_net_cat() {
+	if (( CURRENT == 2 )); then
+		compadd %s
+	elif [[ $words[2] == completion ]]; then
+		compadd bash zsh
+	else
+		_files
+	fi
+}
+compdef _net_cat net-cat TCPChat
+`
+
+func versionCmd(fs *flag.FlagSet, out io.Writer) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		fmt.Fprintln(out, versionString())
+		return nil
+	}
+}
+
+func helpCmd(fs *flag.FlagSet, out io.Writer) func([]string) error {
+	return func(args []string) error {
+		switch len(args) {
+		case 0:
+			printOverview(out)
+			return nil
+		case 1:
+			cmd, ok := findCLICommand(args[0])
+			if !ok {
+				return fmt.Errorf("unknown command %q", args[0])
+			}
+			cmdFlags := flag.NewFlagSet("net-cat "+cmd.name, flag.ContinueOnError)
+			cmd.setup(cmdFlags, out)
+			printCommandHelp(out, cmd, cmdFlags)
+			return nil
+		}
+		return errUsage
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test that help, completion and version are generated from the
+// subcommand table
+func TestCLIHelpAndCompletion(t *testing.T) {
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr strings.Builder
+		code := runCLI(args, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, out, _ := run("--help")
+	if code != 0 {
+		t.Errorf("Expected --help to succeed, got %d", code)
+	}
+	for _, cmd := range cliCommands {
+		if !containsSubstring(out, "  "+cmd.name) {
+			t.Errorf("Expected the overview to list %s, got %q", cmd.name, out)
+		}
+	}
+
+	if _, out, _ := run("help", "bench"); !containsSubstring(out, "Usage: net-cat bench [flags] <address>") || !containsSubstring(out, "-clients") {
+		t.Errorf("Unexpected help for bench %q", out)
+	}
+	if code, _, _ := run("help", "nosuch"); code != 1 {
+		t.Errorf("Expected help for an unknown command to fail, got %d", code)
+	}
+
+	for _, shell := range []string{"bash", "zsh"} {
+		if _, out, _ := run("completion", shell); !containsSubstring(out, "serve client bench admin") {
+			t.Errorf("Expected the %s completion to offer the commands, got %q", shell, out)
+		}
+	}
+	if code, _, errOut := run("completion", "fish"); code != 2 || !containsSubstring(errOut, "Usage: net-cat completion bash|zsh") {
+		t.Errorf("Expected an unsupported shell to print usage, got %d %q", code, errOut)
+	}
+
+	if _, out, _ := run("--version"); out != versionString()+"\n" {
+		t.Errorf("Unexpected version %q", out)
+	}
+}
+
+// Test that a bare address still starts the server, and a bad one prints
+// the serve usage
+func TestCLIServeArguments(t *testing.T) {
+	t.Setenv("NETCAT_CONFIG", "")
+
+	var stdout, stderr strings.Builder
+	if code := runCLI([]string{"localhost"}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected a bad address to be a usage error, got %d", code)
+	}
+	if !containsSubstring(stderr.String(), "Usage: net-cat serve") {
+		t.Errorf("Expected the serve usage, got %q", stderr.String())
+	}
+
+	stderr.Reset()
+	if code := runCLI([]string{"serve", "-nosuch"}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected an unknown flag to be a usage error, got %d", code)
+	}
+	if code := runCLI([]string{"serve", "1", "2"}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected extra arguments to be a usage error, got %d", code)
+	}
+}
+
+// Test that selftest passes against a working server
+func TestCLISelfTest(t *testing.T) {
+	var stdout, stderr strings.Builder
+	if code := runCLI([]string{"selftest"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected selftest to pass, got %d: %s", code, stderr.String())
+	}
+	for _, step := range []string{"join: ok", "chat: ok", "rename: ok", "rate limit: ok", "selftest passed"} {
+		if !containsSubstring(stdout.String(), step) {
+			t.Errorf("Expected %q in the output, got %q", step, stdout.String())
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileChunkSize is how many bytes of a file go in each /filedata line.
+const fileChunkSize = 1024
+
+var (
+	fileOfferLine   = regexp.MustCompile(`^file offer (\d+) from (.+?): (.+) \((\d+) bytes\), /accept \d+ or /reject \d+$`)
+	fileOfferedLine = regexp.MustCompile(`^file offer (\d+) sent to .+?: (.+) \((\d+) bytes\)$`)
+	fileDataLine    = regexp.MustCompile(`^file data (\d+) ([A-Za-z0-9+/=]+)$`)
+	fileDoneLine    = regexp.MustCompile(`^file done (\d+): `)
+	fileAcceptLine  = regexp.MustCompile(`^file accepted (\d+): `)
+	fileEndLine     = regexp.MustCompile(`^file (?:rejected|cancelled) (\d+): `)
+)
+
+// incoming is a file being received.
+type incoming struct {
+	name string
+	data []byte
+}
+
+// offerFile reads the file at path and offers it to the user to. The
+// caller must hold s.mu.
+func (s *session) offerFile(to, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		s.term.printLine("cannot send file: " + err.Error())
+		return
+	}
+	if len(data) == 0 {
+		s.term.printLine("cannot send an empty file")
+		return
+	}
+	name := filepath.Base(path)
+	key := name + "\x00" + strconv.Itoa(len(data))
+	s.offered[key] = append(s.offered[key], data)
+	fmt.Fprintf(s.conn, "/sendfile %s %d %s\n", to, len(data), name)
+}
+
+// handleFileLine follows a file transfer line from the server, returning
+// what to show the user. The caller must hold s.mu.
+func (s *session) handleFileLine(line string) string {
+	if m := fileDataLine.FindStringSubmatch(line); m != nil {
+		if file := s.receiving[m[1]]; file != nil {
+			data, err := base64.StdEncoding.DecodeString(m[2])
+			if err == nil {
+				file.data = append(file.data, data...)
+			}
+		}
+		return ""
+	}
+	if m := fileOfferLine.FindStringSubmatch(line); m != nil {
+		s.receiving[m[1]] = &incoming{name: m[3]}
+	}
+	if m := fileDoneLine.FindStringSubmatch(line); m != nil {
+		if file := s.receiving[m[1]]; file != nil {
+			delete(s.receiving, m[1])
+			path, err := saveFile(file.name, file.data)
+			if err != nil {
+				return line + ", not saved: " + err.Error()
+			}
+			return line + ", saved as " + path
+		}
+	}
+	if m := fileOfferedLine.FindStringSubmatch(line); m != nil {
+		key := m[2] + "\x00" + m[3]
+		if queued := s.offered[key]; len(queued) > 0 {
+			s.sending[m[1]] = queued[0]
+			if s.offered[key] = queued[1:]; len(queued) == 1 {
+				delete(s.offered, key)
+			}
+		}
+	}
+	if m := fileAcceptLine.FindStringSubmatch(line); m != nil {
+		if data, ok := s.sending[m[1]]; ok {
+			go s.streamFile(m[1], data)
+		}
+	}
+	if m := fileEndLine.FindStringSubmatch(line); m != nil {
+		delete(s.sending, m[1])
+		delete(s.receiving, m[1])
+	}
+	return line
+}
+
+// streamFile sends the accepted transfer id in chunks, a line each time
+// the server is ready for one, stopping if it is cancelled or the server
+// stops answering.
+func (s *session) streamFile(id string, data []byte) {
+	send := func(line string) bool {
+		select {
+		case <-s.ready:
+		case <-time.After(30 * time.Second):
+			return false
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.sending[id]; !ok || s.conn == nil {
+			return false
+		}
+		fmt.Fprintf(s.conn, "%s\n", line)
+		return true
+	}
+
+	for len(data) > 0 {
+		n := min(len(data), fileChunkSize)
+		if !send("/filedata " + id + " " + base64.StdEncoding.EncodeToString(data[:n])) {
+			return
+		}
+		data = data[n:]
+	}
+	if send("/filedata " + id) {
+		s.mu.Lock()
+		delete(s.sending, id)
+		s.mu.Unlock()
+	}
+}
+
+// saveFile writes a received file to the current directory under its
+// name, adding a number if that is taken, and returns where it went.
+func saveFile(name string, data []byte) (string, error) {
+	name = filepath.Base(name)
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		name = "received"
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		path := name
+		if i > 0 {
+			path = fmt.Sprintf("%s.%d%s", base, i, ext)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return "", err
+		}
+		return path, f.Close()
+	}
+}
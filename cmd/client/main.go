@@ -0,0 +1,473 @@
+// Command client is an interactive chat client for the TCP-Chat server.
+// Incoming messages are printed above a persistent input line, so typing is
+// never clobbered the way it is with a raw nc session.
+//
+// If the connection drops the client reconnects with exponential backoff,
+// resuming its session so only missed messages are replayed.
+//
+// Pressing tab completes a command name from the list the server sends in
+// reply to /help json.
+//
+// "/sendfile <user> <path>" offers a local file, which is sent once they
+// accept it, and files you accept are saved in the current directory.
+//
+// With -script it runs without a terminal instead, sending lines from stdin
+// and printing messages to stdout, optionally as JSON with -json.
+//
+// Usage:
+//
+//	client [-name NAME] [-script [-json]] host:port
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// namePrompt is what the server sends when it wants a name.
+const namePrompt = "[ENTER YOUR NAME]:"
+
+// terminal draws incoming lines above an input line that the user edits.
+type terminal struct {
+	mu     sync.Mutex
+	prompt string
+	input  []byte
+
+	// commands are the command names tab completes, including aliases.
+	commands []string
+}
+
+// printLine writes line above the input line and redraws it.
+func (t *terminal) printLine(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Printf("\r\033[K%s\n%s%s", line, t.prompt, t.input)
+}
+
+// setPrompt changes the text shown before the input line.
+func (t *terminal) setPrompt(prompt string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prompt = prompt
+	fmt.Printf("\r\033[K%s%s", t.prompt, t.input)
+}
+
+// setCommands changes the command names tab completes.
+func (t *terminal) setCommands(names []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.commands = names
+}
+
+// complete extends a command name being typed to the longest prefix shared
+// by the commands it could be, listing them if that adds nothing. It must
+// be called with t.mu held.
+func (t *terminal) complete() {
+	typed := string(t.input)
+	if !strings.HasPrefix(typed, "/") || strings.Contains(typed, " ") {
+		return
+	}
+	var matches []string
+	for _, name := range t.commands {
+		if strings.HasPrefix(name, typed) {
+			matches = append(matches, name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+	case 1:
+		t.input = append(t.input[:0], matches[0]+" "...)
+	default:
+		common := matches[0]
+		for _, name := range matches[1:] {
+			for !strings.HasPrefix(name, common) {
+				common = common[:len(common)-1]
+			}
+		}
+		if len(common) > len(typed) {
+			t.input = append(t.input[:0], common...)
+		} else {
+			fmt.Printf("\r\033[K%s\n", strings.Join(matches, "  "))
+		}
+	}
+}
+
+// key applies one byte of keyboard input. It returns a completed line when
+// the user presses enter, and false when they ask to quit.
+func (t *terminal) key(b byte) (line string, done bool, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch b {
+	case 3, 4: // Ctrl-C, Ctrl-D
+		return "", false, false
+	case '\r', '\n':
+		line = string(t.input)
+		t.input = t.input[:0]
+		fmt.Printf("\r\033[K%s", t.prompt)
+		return line, true, true
+	case '\t':
+		t.complete()
+	case 127, 8: // backspace
+		if len(t.input) > 0 {
+			_, size := utf8.DecodeLastRune(t.input)
+			t.input = t.input[:len(t.input)-size]
+		}
+	default:
+		if b >= 32 {
+			t.input = append(t.input, b)
+		}
+	}
+	fmt.Printf("\r\033[K%s%s", t.prompt, t.input)
+	return "", false, true
+}
+
+// rawMode switches the terminal to unbuffered, unechoed input and returns a
+// function restoring it. It fails when stdin is not a terminal.
+func rawMode() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	return func() { stty(strings.TrimSpace(saved)) }, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// session tracks the current connection, where its handshake is and which
+// name we joined with. It survives reconnects.
+type session struct {
+	term *terminal
+
+	mu       sync.Mutex
+	conn     net.Conn
+	name     string
+	prompt   *regexp.Regexp
+	naming   bool
+	autoName string
+
+	// token resumes our session after a reconnect; resumeTried is set once
+	// it has been offered on the current connection.
+	token       string
+	resumeTried bool
+	joined      bool
+
+	// ready is signalled whenever the server has written our prompt, so
+	// file chunks are sent one line at a time.
+	ready chan struct{}
+	// offered holds files offered with /sendfile until the server says
+	// which transfer they are, keyed by name and size; sending and
+	// receiving hold the files in transfer, by its number.
+	offered   map[string][][]byte
+	sending   map[string][]byte
+	receiving map[string]*incoming
+}
+
+// askName is called whenever the server wants a name, which also means any
+// earlier answer was refused. After a reconnect the session token is tried
+// first, then the name we had; a name from the command line is used once.
+func (s *session) askName() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.naming = true
+	switch {
+	case s.token != "" && !s.resumeTried:
+		s.resumeTried = true
+		s.naming = false
+		fmt.Fprintf(s.conn, "/resume %s\n", s.token)
+	case s.autoName != "":
+		s.join(s.autoName)
+		s.autoName = ""
+	default:
+		s.token = ""
+		s.term.setPrompt("name> ")
+	}
+}
+
+// join sends name to the server. The caller must hold s.mu.
+func (s *session) join(name string) {
+	s.name = name
+	s.prompt = promptPattern(name)
+	s.naming = false
+	fmt.Fprintf(s.conn, "%s\n", name)
+	s.term.setPrompt("> ")
+}
+
+// submit sends a line the user entered.
+func (s *session) submit(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		s.term.printLine("not connected, message not sent")
+		return
+	}
+	if s.naming {
+		s.join(line)
+		return
+	}
+
+	if args := strings.Fields(line); len(args) == 3 && args[0] == "/sendfile" {
+		s.offerFile(args[1], args[2])
+		return
+	}
+	fmt.Fprintf(s.conn, "%s\n", line)
+	if !strings.HasPrefix(line, "/") {
+		// The server does not echo our own messages back.
+		s.term.printLine("[" + time.Now().Format("02-01-2006 15:04:05") + "][" + s.name + "]:" + line)
+	}
+}
+
+// handleLine filters a complete line from the server, returning what to
+// show the user. It strips the prompts the server writes for nc users and
+// keeps session tokens to itself.
+func (s *session) handleLine(line string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token, ok := strings.CutPrefix(line, "session token: "); ok {
+		s.token = token
+		return ""
+	}
+	if s.prompt != nil {
+		line = s.prompt.ReplaceAllString(line, "")
+	}
+	if name, ok := strings.CutPrefix(line, s.name+" is now known as "); ok && s.name != "" {
+		// We ran /name: follow it so our prompts are still recognised.
+		s.name, s.prompt = name, promptPattern(name)
+	}
+	if strings.HasPrefix(line, `{"commands":`) {
+		s.term.setCommands(commandNames(line))
+		return ""
+	}
+	return s.handleFileLine(line)
+}
+
+// commandNames returns the names and aliases of the commands in a reply to
+// /help json.
+func commandNames(reply string) []string {
+	var list struct {
+		Commands []struct {
+			Name    string   `json:"name"`
+			Aliases []string `json:"aliases"`
+		} `json:"commands"`
+	}
+	if err := json.Unmarshal([]byte(reply), &list); err != nil {
+		return nil
+	}
+	var names []string
+	for _, cmd := range list.Commands {
+		names = append(names, cmd.Name)
+		names = append(names, cmd.Aliases...)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// prompted is called when the server has written our chat prompt. The
+// first one on a connection means we have joined, and is when we ask for a
+// session token: anything sent earlier could be swallowed by the name
+// handshake.
+func (s *session) prompted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.joined {
+		return false
+	}
+	s.joined = true
+	if s.token == "" {
+		fmt.Fprintf(s.conn, "/session\n")
+	}
+	fmt.Fprintf(s.conn, "/help json\n")
+	s.resumeTried = false
+	return true
+}
+
+// connect dials addr and makes it the session's connection.
+func (s *session) connect(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = conn
+	s.joined = false
+	s.resumeTried = false
+	if s.name != "" && s.autoName == "" {
+		// Rejoin with the same name if the session cannot be resumed.
+		s.autoName = s.name
+	}
+	return nil
+}
+
+// disconnect forgets the current connection.
+func (s *session) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func main() {
+	name := flag.String("name", "", "name to join with; asked for if empty")
+	script := flag.Bool("script", false, "send lines from stdin and print messages to stdout without a terminal")
+	asJSON := flag.Bool("json", false, "in script mode, print messages as JSON objects; implies -script")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "[USAGE]: client [-name NAME] [-script [-json]] host:port")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	addr := flag.Arg(0)
+
+	if *script || *asJSON {
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "script mode needs -name")
+			os.Exit(2)
+		}
+		if err := runScript(addr, *name, *asJSON); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	sess := &session{term: &terminal{}, autoName: *name, ready: make(chan struct{}, 1),
+		offered: make(map[string][][]byte), sending: make(map[string][]byte), receiving: make(map[string]*incoming)}
+	if err := sess.connect(addr); err != nil {
+		fmt.Fprintln(os.Stderr, "connect:", err)
+		os.Exit(1)
+	}
+
+	restore, err := rawMode()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "stdin is not a terminal:", err)
+		os.Exit(1)
+	}
+	defer restore()
+
+	go sess.run(addr)
+
+	keys := bufio.NewReader(os.Stdin)
+	for {
+		b, err := keys.ReadByte()
+		if err != nil {
+			return
+		}
+		line, done, ok := sess.term.key(b)
+		if !ok || (done && line == "/quit") {
+			sess.disconnect()
+			return
+		}
+		if done && line != "" {
+			sess.submit(line)
+		}
+	}
+}
+
+// run receives messages and, whenever the connection drops, reconnects
+// with exponential backoff.
+func (s *session) run(addr string) {
+	const maxBackoff = 30 * time.Second
+	backoff := time.Second
+
+	for {
+		if s.receive() {
+			backoff = time.Second
+		}
+		s.disconnect()
+
+		for {
+			s.term.printLine(fmt.Sprintf("connection lost, reconnecting in %s", backoff))
+			time.Sleep(backoff)
+			backoff = min(backoff*2, maxBackoff)
+			if err := s.connect(addr); err == nil {
+				break
+			}
+		}
+		s.term.printLine("reconnected")
+	}
+}
+
+// receive prints what the server sends until the connection closes, and
+// reports whether we joined the chat on it.
+func (s *session) receive() bool {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+
+	joined := false
+	reader := bufio.NewReader(conn)
+	var pending strings.Builder
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if line := s.handleLine(pending.String()); line != "" {
+				s.term.printLine(line)
+			}
+			return joined
+		}
+
+		if b != '\n' {
+			pending.WriteByte(b)
+			text := pending.String()
+			if strings.HasSuffix(text, namePrompt) {
+				if text := strings.TrimSuffix(text, namePrompt); strings.TrimSpace(text) != "" {
+					s.term.printLine(text)
+				}
+				pending.Reset()
+				s.askName()
+			} else if b == ':' && s.isPrompt(text) {
+				select {
+				case s.ready <- struct{}{}:
+				default:
+				}
+				if s.prompted() {
+					joined = true
+				}
+			}
+			continue
+		}
+
+		line := s.handleLine(pending.String())
+		pending.Reset()
+		if line != "" {
+			s.term.printLine(line)
+		}
+	}
+}
+
+// isPrompt reports whether text ends with our chat prompt.
+func (s *session) isPrompt(text string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name != "" && strings.HasSuffix(text, "]["+s.name+"]:")
+}
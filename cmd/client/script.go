@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// chatLine matches a chat message as the server writes it.
+var chatLine = regexp.MustCompile(`^\[(\d\d-\d\d-\d{4} \d\d:\d\d:\d\d)\]\[([^\]]*)\]:(.*)$`)
+
+// scriptMessage is one line from the server in -json output. Lines that
+// are not chat messages, such as join notices, only have Text.
+type scriptMessage struct {
+	Time *time.Time `json:"time,omitempty"`
+	Name string     `json:"name,omitempty"`
+	Text string     `json:"text"`
+}
+
+// promptPattern matches the prompt the server writes for name.
+func promptPattern(name string) *regexp.Regexp {
+	return regexp.MustCompile(`\[\d\d-\d\d-\d{4} \d\d:\d\d:\d\d\]\[` + regexp.QuoteMeta(name) + `\]:`)
+}
+
+// runScript joins as name and sends every line read from stdin, while
+// printing what the server sends to stdout without any prompts. It
+// disconnects once stdin is exhausted, so it suits pipelines and cron jobs:
+//
+//	echo "backup done" | client -script -name cron localhost:8989
+func runScript(addr, name string, asJSON bool) error {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	prompt := promptPattern(name)
+	out := json.NewEncoder(os.Stdout)
+	emit := func(line string) {
+		if line = prompt.ReplaceAllString(line, ""); strings.TrimSpace(line) == "" {
+			return
+		}
+		if !asJSON {
+			fmt.Println(line)
+			return
+		}
+		msg := scriptMessage{Text: line}
+		if m := chatLine.FindStringSubmatch(line); m != nil {
+			if t, err := time.ParseInLocation("02-01-2006 15:04:05", m[1], time.Local); err == nil {
+				msg = scriptMessage{Time: &t, Name: m[2], Text: m[3]}
+			}
+		}
+		out.Encode(msg)
+	}
+
+	// The server reads whatever has arrived as one message, so a line is
+	// only sent once it has written our prompt again.
+	ready := make(chan struct{}, 1)
+	go sendLines(conn, ready)
+
+	reader := bufio.NewReader(conn)
+	var pending strings.Builder
+	named := false
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			emit(pending.String())
+			return nil
+		}
+		if b == '\n' {
+			// Skip the welcome banner.
+			if named {
+				emit(pending.String())
+			}
+			pending.Reset()
+			continue
+		}
+
+		pending.WriteByte(b)
+		text := pending.String()
+		if strings.HasSuffix(text, namePrompt) {
+			if named {
+				return fmt.Errorf("name refused: %s", strings.TrimSpace(strings.TrimSuffix(text, namePrompt)))
+			}
+			named = true
+			pending.Reset()
+			fmt.Fprintf(conn, "%s\n", name)
+		} else if b == ':' && strings.HasSuffix(text, "]["+name+"]:") && prompt.MatchString(text) {
+			select {
+			case ready <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// sendLines writes each non-empty line of stdin to conn, waiting for ready
+// before each one, then closes our side so the server disconnects us.
+func sendLines(conn net.Conn, ready <-chan struct{}) {
+	lines := bufio.NewScanner(os.Stdin)
+	for lines.Scan() {
+		if strings.TrimSpace(lines.Text()) == "" {
+			continue
+		}
+		<-ready
+		fmt.Fprintf(conn, "%s\n", lines.Text())
+	}
+	<-ready
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	} else {
+		conn.Close()
+	}
+}
@@ -0,0 +1,85 @@
+// Command dicebot is an example bot built with pkg/bot. It rolls dice on
+// "!roll 2d6" and greets people who say hello.
+//
+// Usage:
+//
+//	dicebot [-name NAME] host:port
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"net-cat/pkg/bot"
+)
+
+func main() {
+	name := flag.String("name", "dicebot", "name to join with")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "[USAGE]: dicebot [-name NAME] host:port")
+		os.Exit(2)
+	}
+
+	b := bot.New(flag.Arg(0), *name)
+	b.Command("roll", "roll dice, e.g. !roll 2d6", roll)
+	b.Handle(`(?i)^(hello|hi|hey)\b`, func(ctx *bot.Context) {
+		ctx.Reply("hello!")
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := b.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
+
+// roll answers "!roll [N]dM", rolling one six-sided die by default.
+func roll(ctx *bot.Context) {
+	dice := "1d6"
+	if len(ctx.Args) > 0 {
+		dice = ctx.Args[0]
+	}
+
+	count, sides, ok := parseDice(dice)
+	if !ok {
+		ctx.Reply("usage: !roll [N]dM, e.g. !roll 2d6")
+		return
+	}
+
+	rolls := make([]string, count)
+	total := 0
+	for i := range rolls {
+		n := rand.Intn(sides) + 1
+		total += n
+		rolls[i] = strconv.Itoa(n)
+	}
+	ctx.Reply(fmt.Sprintf("%s = %d (%s)", dice, total, strings.Join(rolls, " + ")))
+}
+
+// parseDice parses "NdM" with up to 20 dice of up to 1000 sides.
+func parseDice(dice string) (count, sides int, ok bool) {
+	n, m, found := strings.Cut(strings.ToLower(dice), "d")
+	if !found {
+		return 0, 0, false
+	}
+	count = 1
+	if n != "" {
+		var err error
+		if count, err = strconv.Atoi(n); err != nil {
+			return 0, 0, false
+		}
+	}
+	sides, err := strconv.Atoi(m)
+	if err != nil || count < 1 || count > 20 || sides < 2 || sides > 1000 {
+		return 0, 0, false
+	}
+	return count, sides, true
+}
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// command is a slash command a connected client can run instead of sending
+// a chat message.
+type command struct {
+	usage    string
+	help     string
+	operator bool
+	// posts commands say something to everyone, so only operators may
+	// run them while the chat is announcement-only.
+	posts bool
+	// text commands end in free text, such as a message: after their
+	// first words arguments, the rest of the line is passed as the last
+	// argument as it was typed, quotes and spacing included.
+	text  bool
+	words int
+	run   func(s *Server, client *Client, args []string)
+}
+
+// commands maps each command name, including its leading slash, to its
+// implementation. It is filled in by init so commands can refer to it.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"/help":       {usage: "/help [json]", help: "list the commands you can use", run: cmdHelp},
+		"/forgetme":   {usage: "/forgetme", help: "remove what you have said since you connected from the history and log", run: cmdForgetMe},
+		"/ephemeral":  {usage: "/ephemeral on|off", help: "stop or resume storing your messages in the history and log", run: cmdEphemeral},
+		"/name":       {usage: "/name <new name>", help: "change your name", run: cmdName},
+		"/who":        {usage: "/who", help: "list everyone in the chat, including linked servers", run: cmdWho},
+		"/whois":      {usage: "/whois <name>", help: "show details about a connected user", run: cmdWhois},
+		"/history":    {usage: "/history <count> | /history since <RFC 3339 time|duration> | /history page <limit> [offset <n>] [before <id>]", help: "show recent messages again", run: cmdHistory},
+		"/setinfo":    {usage: "/setinfo [text]", help: "set a short line about yourself shown in /whois, such as pronouns, role or contact, or clear it", text: true, run: cmdSetInfo},
+		"/join":       {usage: "/join <#room>", help: "move into a room, creating it if needed, where only its members see what you say", run: cmdJoin},
+		"/leave":      {usage: "/leave", help: "leave your room and go back to the main chat", run: cmdLeave},
+		"/rooms":      {usage: "/rooms", help: "list the rooms and who is in each", run: cmdRooms},
+		"/roomset":    {usage: "/roomset [max_message_size|message_rate_limit <value|default>]", help: "show your room's settings, or as its owner make it stricter than the server", run: cmdRoomSet},
+		"/msg":        {usage: "/msg <name>[,name...] <text>", help: "send a private message to one or more users", text: true, words: 1, run: cmdMsg},
+		"/r":          {usage: "/r <text>", help: "reply to the last direct message you received", text: true, run: cmdReply},
+		"/dms":        {usage: "/dms", help: "show the stored direct messages you sent or received", run: cmdDirects},
+		"/ignore":     {usage: "/ignore [name]", help: "stop receiving messages from a user, or list who you ignore", run: cmdIgnore},
+		"/unignore":   {usage: "/unignore <name>", help: "receive messages from a user again", run: cmdUnignore},
+		"/accessible": {usage: "/accessible on|off", help: "simplify output for screen readers", run: cmdAccessible},
+		"/color":      {usage: "/color on|off", help: "show *bold*, _italic_ and `code` formatted, for terminals with ANSI colors", run: cmdColor},
+		"/width":      {usage: "/width <columns>|off", help: "wrap long messages to your terminal width", run: cmdWidth},
+		"/sendfile":   {usage: "/sendfile <user> <size> <name>", help: "offer a user a file; clients such as cmd/client send it once accepted", run: cmdSendFile},
+		"/accept":     {usage: "/accept <id>", help: "accept a file offered to you", run: cmdAcceptFile},
+		"/reject":     {usage: "/reject <id>", help: "turn down a file offered to you", run: cmdRejectFile},
+		"/filedata":   {usage: "/filedata <id> [base64]", help: "send the next chunk of an accepted file, or end it", run: cmdFileData},
+		"/share":      {usage: "/share", help: "share a long block of text as a snippet others fetch with /get, ending it with a line holding just .", posts: true, run: cmdShare},
+		"/get":        {usage: "/get <id>", help: "show a snippet shared with /share", run: cmdGet},
+		"/read":       {usage: "/read [message id]", help: "mark messages read up to an ID, or all of them, for clients with the receipts capability", run: cmdRead},
+		"/unread":     {usage: "/unread", help: "count unread messages, for clients with the receipts capability", run: cmdUnread},
+		"/prefs":      {usage: "/prefs [reset]", help: "show the settings kept for your name, or forget your display settings", run: cmdPrefs},
+		"/dnd":        {usage: "/dnd [on [away message] | off]", help: "stop mentions ringing and hold direct messages until you turn it off", text: true, words: 1, run: cmdDND},
+		"/notify":     {usage: "/notify [joins|bells|previews on|off] | /notify read", help: "choose which notifications you get, or read direct messages held without a preview", run: cmdNotify},
+		"/session":    {usage: "/session", help: "get a token to resume this session after a disconnect", run: cmdSession},
+		"/poll":       {usage: "/poll [\"question\" <option> <option>...]", help: "start a poll, or show the running one", run: cmdPoll},
+		"/vote":       {usage: "/vote <option number or text>", help: "vote in the running poll", text: true, run: cmdVote},
+		"/endpoll":    {usage: "/endpoll", help: "close the poll you started and announce the result", run: cmdEndPoll},
+		"/pins":       {usage: "/pins", help: "list the pinned messages", run: cmdPins},
+		"/pin":        {usage: "/pin <message id|last>", help: "pin a message from the history so it is shown to everyone who joins", operator: true, run: cmdPin},
+		"/unpin":      {usage: "/unpin <message id>", help: "unpin a message", operator: true, run: cmdUnpin},
+		"/topic":      {usage: "/topic [new topic]", help: "show the topic, or change it if you are an operator", text: true, run: cmdTopic},
+		"/server":     {usage: "/server", help: "show the server version, uptime, limits and load", run: cmdServer},
+		"/oper":       {usage: "/oper <password>", help: "become an operator", run: cmdOper},
+		"/readonly":   {usage: "/readonly [on|off]", help: "show or change whether only operators can post", operator: true, run: cmdReadOnly},
+		"/forget":     {usage: "/forget <user>", help: "remove everything a name has ever said from the history, pins and log", operator: true, run: cmdForget},
+		"/kick":       {usage: "/kick <user> [cooldown] [reason]", help: "disconnect a user, keeping their name and address out for a cooldown such as 10m", operator: true, run: cmdKick},
+		"/global":     {usage: "/global <text>", help: "send an announcement to everyone on the server", operator: true, text: true, run: cmdGlobal},
+		"/schedule":   {usage: "/schedule [add <minute> <hour> <day> <month> <weekday> <text> | remove <n>]", help: "list, add or remove scheduled announcements", operator: true, text: true, words: 6, run: cmdSchedule},
+		"/config":     {usage: "/config [setting...]", help: "show the running configuration, with passwords and keys hidden", operator: true, run: cmdConfig},
+		"/set":        {usage: "/set [max_clients|max_message_size|message_rate_limit <value>]", help: "show or change limits while the server runs", operator: true, run: cmdSet},
+		"/stats":      {usage: "/stats", help: "show how much work the server is doing against its limits, and how busy the chat is", operator: true, run: cmdStats},
+		"/debug":      {usage: "/debug [file]", help: "show goroutines, memory, queues and every client's state, or write them with goroutine stacks to a file", operator: true, run: cmdDebug},
+		"/top":        {usage: "/top [count]", help: "list who sent the most messages in the last hour", operator: true, run: cmdTop},
+		"/export":     {usage: "/export <from|-> <to|-> json|text|html [file]", help: "export the history between two RFC 3339 times", operator: true, run: cmdExport},
+	}
+}
+
+// handleCommand runs the slash command in line on behalf of client and
+// reports whether line was a command at all.
+func (s *Server) handleCommand(client *Client, line string) bool {
+	if !strings.HasPrefix(line, "/") {
+		return false
+	}
+
+	name := line
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name = line[:i]
+	}
+	name = s.resolveAlias(name)
+	cmd, ok := s.lookupCommand(name)
+	if !ok {
+		s.replyError(client, newClientError(ErrCodeUnknownCommand, "unknown command "+name))
+		return true
+	}
+	if cmd.operator && !s.isOperator(client) {
+		s.replyError(client, newClientError(ErrCodePermissionDenied, name+" is only available to operators"))
+		return true
+	}
+	limit := -1
+	if cmd.text {
+		limit = cmd.words + 1
+	}
+	fields, err := splitArgs(line, limit)
+	if err != nil {
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, err.Error()))
+		return true
+	}
+	if cmd.posts && !s.mayPost(client) {
+		return true
+	}
+	cmd.run(s, client, fields[1:])
+	return true
+}
+
+// splitArgs splits a command line into words separated by spaces. A double
+// quote at the start of a word runs it to the closing quote, spaces and
+// all, and a backslash makes the next character literal, so
+// `/msg "John Doe" say \"hi\"` has the words /msg, John Doe, say and "hi".
+// With a limit of zero or more, once limit words have been read the rest of
+// the line is the last word, exactly as it was typed.
+func splitArgs(line string, limit int) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case !inWord && len(words) == limit && c != ' ' && c != '\t':
+			return append(words, line[i:]), nil
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case quoted && c == '"':
+			quoted = false
+		case quoted:
+			word.WriteByte(c)
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '"' && !inWord:
+			quoted, inWord = true, true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// CommandInfo describes a command for clients that offer completion.
+type CommandInfo struct {
+	Name  string `json:"name"`
+	Usage string `json:"usage"`
+	Help  string `json:"help,omitempty"`
+
+	// Aliases are the other names the command can be run by.
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// CommandList is the reply to /help json.
+type CommandList struct {
+	Commands []CommandInfo `json:"commands"`
+}
+
+// commandList returns the commands client may run, sorted by name.
+func (s *Server) commandList(client *Client) CommandList {
+	available := availableCommands(s.config)
+	operator := s.isOperator(client)
+
+	names := maps.Clone(builtinAliases)
+	maps.Copy(names, s.config.Aliases)
+	aliases := map[string][]string{}
+	for _, alias := range slices.Sorted(maps.Keys(names)) {
+		aliases[names[alias]] = append(aliases[names[alias]], alias)
+	}
+
+	list := CommandList{Commands: []CommandInfo{}}
+	for _, name := range slices.Sorted(maps.Keys(available)) {
+		cmd := available[name]
+		if cmd.operator && !operator {
+			continue
+		}
+		list.Commands = append(list.Commands, CommandInfo{Name: name, Usage: cmd.usage, Help: cmd.help, Aliases: aliases[name]})
+	}
+	return list
+}
+
+func cmdHelp(s *Server, client *Client, args []string) {
+	if len(args) == 1 && args[0] == "json" {
+		data, err := json.Marshal(s.commandList(client))
+		if err != nil {
+			s.replyJSONError(client, err)
+			return
+		}
+		s.reply(client, string(data))
+		return
+	}
+	if len(args) != 0 {
+		s.replyUsage(client, "/help")
+		return
+	}
+
+	available := availableCommands(s.config)
+	operator := s.isOperator(client)
+
+	var lines []string
+	for _, name := range slices.Sorted(maps.Keys(available)) {
+		cmd := available[name]
+		if cmd.operator && !operator {
+			continue
+		}
+		lines = append(lines, s.mustText("help", map[string]string{"Command": name, "Usage": cmd.usage, "Help": cmd.help}))
+	}
+	s.reply(client, strings.Join(lines, "\n"))
+}
+
+// forgetResult tells client how many messages Forget removed, and whether
+// it failed part way.
+func (s *Server) forgetResult(client *Client, removed int, err error) {
+	if err != nil {
+		fmt.Println("Error forgetting messages:", err)
+		s.replyError(client, wrapClientError(ErrCodeInternal, fmt.Sprintf("removed %d messages from history, but the files could not all be cleaned", removed), err))
+		return
+	}
+	s.reply(client, fmt.Sprintf("removed %d messages from history and the log file", removed))
+}
+
+func cmdForgetMe(s *Server, client *Client, args []string) {
+	// Anyone can pick any free name, so a client may only forget what it
+	// said itself; purging a name's whole history is /forget, for
+	// operators.
+	removed, err := s.Forget(client.name.get(), client.connectedAt)
+	s.forgetResult(client, removed, err)
+}
+
+func cmdForget(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/forget")
+		return
+	}
+	removed, err := s.Forget(args[0], time.Time{})
+	s.forgetResult(client, removed, err)
+}
+
+func cmdEphemeral(s *Server, client *Client, args []string) {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		s.replyUsage(client, "/ephemeral")
+		return
+	}
+
+	s.mu.Lock()
+	client.ephemeral = args[0] == "on"
+	s.mu.Unlock()
+
+	if args[0] == "on" {
+		s.reply(client, "ephemeral mode on: your messages will not be stored")
+	} else {
+		s.reply(client, "ephemeral mode off: your messages will be stored")
+	}
+}
+
+func cmdWho(s *Server, client *Client, args []string) {
+	s.reply(client, s.whoList(s.isOperator(client)))
+}
+
+func cmdWhois(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/whois")
+		return
+	}
+
+	target := s.findClient(args[0])
+	if target == nil {
+		s.replyError(client, newClientError(ErrCodeNoSuchUser, "no such user "+args[0]))
+		return
+	}
+
+	s.mu.Lock()
+	mode := "stored"
+	if target.ephemeral {
+		mode = "ephemeral"
+	}
+	dnd := ""
+	if target.dnd {
+		dnd = ", do not disturb"
+		if target.away != "" {
+			dnd += " (" + target.away + ")"
+		}
+	}
+	s.mu.Unlock()
+
+	reply := fmt.Sprintf("%s: %s, messages %s%s",
+		target.name.get(), s.clientDetails(target, s.isOperator(client)), mode, dnd)
+	if profile := s.profile(target.name.get()); profile != "" {
+		reply += "\ninfo: " + profile
+	}
+	s.reply(client, reply)
+}
+
+// maxProfileLength is the most characters /setinfo keeps.
+const maxProfileLength = 120
+
+func cmdSetInfo(s *Server, client *Client, args []string) {
+	profile := strings.Join(args, " ")
+	if utf8.RuneCountInString(profile) > maxProfileLength {
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, fmt.Sprintf("info can be at most %d characters", maxProfileLength)))
+		return
+	}
+	if err := s.setProfile(client.name.get(), profile); err != nil {
+		fmt.Println("Error saving server state:", err)
+	}
+	if profile == "" {
+		s.reply(client, "info cleared")
+	} else {
+		s.reply(client, "info set: "+profile)
+	}
+}
+
+func cmdMsg(s *Server, client *Client, args []string) {
+	if len(args) < 2 {
+		s.replyUsage(client, "/msg")
+		return
+	}
+
+	missing := s.sendDirect(client, strings.Split(args[0], ","), strings.Join(args[1:], " "))
+	if len(missing) > 0 {
+		s.replyError(client, newClientError(ErrCodeNoSuchUser, "not connected: "+strings.Join(missing, ", ")))
+	}
+}
+
+func cmdReply(s *Server, client *Client, args []string) {
+	if len(args) == 0 {
+		s.replyUsage(client, "/r")
+		return
+	}
+
+	names := s.replyTargets(client)
+	if len(names) == 0 {
+		s.replyError(client, newClientError(ErrCodeNotFound, "nobody has sent you a direct message yet"))
+		return
+	}
+
+	missing := s.sendDirect(client, names, strings.Join(args, " "))
+	if len(missing) > 0 {
+		s.replyError(client, newClientError(ErrCodeNoSuchUser, "not connected: "+strings.Join(missing, ", ")))
+	}
+}
+
+func cmdDirects(s *Server, client *Client, args []string) {
+	if s.config.DMRetention <= 0 {
+		s.replyError(client, newClientError(ErrCodeDisabled, "direct messages are not stored on this server"))
+		return
+	}
+
+	msgs := s.directsFor(client)
+	if len(msgs) == 0 {
+		s.reply(client, "no stored direct messages")
+		return
+	}
+
+	lines := make([]string, len(msgs))
+	for i, m := range msgs {
+		lines[i] = "[" + m.sent.Format("02-01-2006 15:04:05") + "][" + m.name + " -> " + strings.Join(m.to, ", ") + "]:" + string(m.payload)
+	}
+	s.reply(client, strings.Join(lines, "\n"))
+}
+
+func cmdIgnore(s *Server, client *Client, args []string) {
+	switch len(args) {
+	case 0:
+		if list := s.ignoreList(client.name.get()); len(list) > 0 {
+			s.reply(client, "ignoring: "+strings.Join(list, ", "))
+		} else {
+			s.reply(client, "you are not ignoring anyone")
+		}
+	case 1:
+		if args[0] == client.name.get() {
+			s.replyError(client, newClientError(ErrCodeInvalidArgument, "you cannot ignore yourself"))
+			return
+		}
+		if err := s.setIgnore(client.name.get(), args[0], true); err != nil {
+			fmt.Println("Error saving server state:", err)
+		}
+		s.reply(client, "ignoring "+args[0])
+	default:
+		s.replyUsage(client, "/ignore")
+	}
+}
+
+func cmdUnignore(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/unignore")
+		return
+	}
+	if err := s.setIgnore(client.name.get(), args[0], false); err != nil {
+		fmt.Println("Error saving server state:", err)
+	}
+	s.reply(client, "no longer ignoring "+args[0])
+}
+
+func cmdSession(s *Server, client *Client, args []string) {
+	s.reply(client, "session token: "+s.issueSession(client))
+}
+
+func cmdTopic(s *Server, client *Client, args []string) {
+	if len(args) == 0 {
+		if topic := s.topic(); topic != "" {
+			s.reply(client, s.mustText("topic", map[string]string{"Topic": topic}))
+		} else {
+			s.reply(client, "no topic is set")
+		}
+		return
+	}
+
+	if !s.isOperator(client) {
+		s.replyError(client, newClientError(ErrCodePermissionDenied, "only operators can change the topic"))
+		return
+	}
+
+	topic := strings.Join(args, " ")
+	if topic == "-" {
+		topic = ""
+	}
+	if err := s.setTopic(client, topic); err != nil {
+		fmt.Println("Error saving server state:", err)
+	}
+}
+
+func cmdServer(s *Server, client *Client, args []string) {
+	s.reply(client, s.serverInfo())
+}
+
+func cmdStats(s *Server, client *Client, args []string) {
+	s.reply(client, s.budgetStats()+"\n"+s.messageStats()+"\n"+s.latencyStats())
+}
+
+func cmdConfig(s *Server, client *Client, args []string) {
+	settings, err := s.configSettings(args)
+	if err != nil {
+		s.replyError(client, err)
+		return
+	}
+	s.reply(client, settings)
+}
+
+func cmdTop(s *Server, client *Client, args []string) {
+	n := 5
+	if len(args) > 1 {
+		s.replyUsage(client, "/top")
+		return
+	}
+	if len(args) == 1 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+			s.replyUsage(client, "/top")
+			return
+		}
+	}
+
+	var b strings.Builder
+	for i, t := range s.topTalkers(n) {
+		fmt.Fprintf(&b, "%d. %s: %d in the last hour, %d since joining\n", i+1, t.name, t.lastHour, t.total)
+	}
+	s.reply(client, strings.TrimSuffix(b.String(), "\n"))
+}
+
+func cmdGlobal(s *Server, client *Client, args []string) {
+	if len(args) == 0 {
+		s.replyUsage(client, "/global")
+		return
+	}
+	s.announceAll(s.mustText("global", map[string]string{"Name": client.name.get(), "Text": strings.Join(args, " ")}), client)
+}
+
+func cmdOper(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/oper")
+		return
+	}
+
+	password := s.config.OperatorPassword
+	if password == "" || subtle.ConstantTimeCompare([]byte(args[0]), []byte(password)) != 1 {
+		s.replyError(client, newClientError(ErrCodeBadPassword, "incorrect operator password"))
+		return
+	}
+
+	s.mu.Lock()
+	client.operator = true
+	s.mu.Unlock()
+	s.reply(client, "you are now an operator")
+}
+
+func cmdExport(s *Server, client *Client, args []string) {
+	if len(args) != 3 && len(args) != 4 {
+		s.replyUsage(client, "/export")
+		return
+	}
+
+	from, err := parseExportTime(args[0])
+	if err != nil {
+		s.replyError(client, wrapClientError(ErrCodeInvalidArgument, "invalid start time: "+err.Error(), err))
+		return
+	}
+	to, err := parseExportTime(args[1])
+	if err != nil {
+		s.replyError(client, wrapClientError(ErrCodeInvalidArgument, "invalid end time: "+err.Error(), err))
+		return
+	}
+
+	if len(args) == 3 {
+		if err := s.ExportHistory(client.conn, from, to, args[2]); err != nil {
+			s.replyError(client, wrapClientError(ErrCodeInternal, "export failed: "+err.Error(), err))
+		}
+		return
+	}
+
+	path, err := s.exportToFile(args[3], from, to, args[2])
+	if err != nil {
+		s.replyError(client, wrapClientError(ErrCodeInternal, "export failed: "+err.Error(), err))
+		return
+	}
+	s.reply(client, "history exported to "+path)
+}
+
+// parseExportTime parses an RFC 3339 time, with "-" meaning unbounded.
+func parseExportTime(arg string) (time.Time, error) {
+	if arg == "-" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, arg)
+}
+
+func cmdHistory(s *Server, client *Client, args []string) {
+	if len(args) > 0 && args[0] == "page" {
+		cmdHistoryPage(s, client, args[1:])
+		return
+	}
+
+	var msgs []Message
+	switch {
+	case len(args) == 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			s.replyUsage(client, "/history")
+			return
+		}
+		msgs = s.lastMessages(n)
+	case len(args) == 2 && args[0] == "since":
+		since, err := parseSince(args[1])
+		if err != nil {
+			s.replyError(client, wrapClientError(ErrCodeInvalidArgument, "invalid time: "+err.Error(), err))
+			return
+		}
+		msgs = s.messagesSince(since)
+	default:
+		s.replyUsage(client, "/history")
+		return
+	}
+
+	if len(msgs) == 0 {
+		s.reply(client, "no messages")
+		return
+	}
+
+	var b strings.Builder
+	for _, m := range msgs {
+		b.Write(m.payload)
+	}
+	s.reply(client, strings.TrimPrefix(b.String(), "\n"))
+}
+
+// cmdHistoryPage replies with one page of history as a JSON object, for
+// bots that page back through the scrollback.
+func cmdHistoryPage(s *Server, client *Client, args []string) {
+	usage := newClientError(ErrCodeUsage, "usage: /history page <limit> [offset <n>] [before <id>]")
+	if len(args) == 0 || len(args)%2 != 1 {
+		s.replyJSONError(client, usage)
+		return
+	}
+
+	limit, err := strconv.Atoi(args[0])
+	if err != nil || limit <= 0 {
+		s.replyJSONError(client, usage)
+		return
+	}
+
+	var offset int
+	var before uint64
+	for i := 1; i < len(args); i += 2 {
+		switch args[i] {
+		case "offset":
+			offset, err = strconv.Atoi(args[i+1])
+			if offset < 0 {
+				err = fmt.Errorf("negative offset")
+			}
+		case "before":
+			before, err = strconv.ParseUint(args[i+1], 10, 64)
+		default:
+			err = fmt.Errorf("unknown option %q", args[i])
+		}
+		if err != nil {
+			s.replyJSONError(client, usage)
+			return
+		}
+	}
+
+	data, err := json.Marshal(s.historyPage(before, limit, offset))
+	if err != nil {
+		s.replyJSONError(client, err)
+		return
+	}
+	s.reply(client, string(data))
+}
+
+// parseSince accepts either an RFC 3339 time or a duration such as "10m"
+// meaning that long ago.
+func parseSince(arg string) (time.Time, error) {
+	if d, err := time.ParseDuration(arg); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, arg)
+}
+
+func cmdSchedule(s *Server, client *Client, args []string) {
+	switch {
+	case len(args) == 0:
+		list := s.announcements()
+		if len(list) == 0 {
+			s.reply(client, "no announcements are scheduled")
+			return
+		}
+		var b strings.Builder
+		for i, a := range list {
+			fmt.Fprintf(&b, "%d. [%s] %s\n", i+1, a.Schedule, a.Text)
+		}
+		s.reply(client, strings.TrimSuffix(b.String(), "\n"))
+
+	case args[0] == "add" && len(args) >= 7:
+		a := Announcement{Schedule: strings.Join(args[1:6], " "), Text: strings.Join(args[6:], " ")}
+		if err := s.addAnnouncement(a); err != nil {
+			s.replyError(client, err)
+			return
+		}
+		s.reply(client, "scheduled: ["+a.Schedule+"] "+a.Text)
+
+	case args[0] == "remove" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			s.replyUsage(client, "/schedule")
+			return
+		}
+		if err := s.removeAnnouncement(n - 1); err != nil {
+			s.replyError(client, err)
+			return
+		}
+		s.reply(client, "removed announcement "+args[1])
+
+	default:
+		s.replyUsage(client, "/schedule")
+	}
+}
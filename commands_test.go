@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that operator-only commands are refused to regular clients
+func TestOperatorCommandRefused(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	server.handleCommand(&alice, "/global hello")
+
+	if !containsSubstring(output(), "only available to operators") {
+		t.Errorf("Expected /global to be refused, got %q", output())
+	}
+
+	if len(server.history.Messages()) != 0 {
+		t.Errorf("Expected nothing to be broadcast.")
+	}
+}
+
+// Test that /global reaches everyone with a distinct prefix
+func TestGlobalAnnouncement(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	op, opOutput := pipeClient(t, "Op", "192.168.1.1")
+	op.operator = true
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	server.addClient(op)
+	server.addClient(bob)
+
+	server.handleCommand(&op, "/global maintenance at noon")
+
+	want := "*** GLOBAL [Op]: maintenance at noon"
+	if !containsSubstring(bobOutput(), want) || !containsSubstring(opOutput(), want) {
+		t.Errorf("Expected both clients to see %q", want)
+	}
+}
+
+// Test that /who shows connection details, with addresses only for
+// operators
+func TestWhoDetails(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	alice.network, alice.compression, alice.messages = "tcp", "deflate", 3
+	alice.connectedAt = time.Now()
+	op, opOutput := pipeClient(t, "Op", "192.168.1.2")
+	op.operator = true
+	a := server.addClient(alice)
+	o := server.addClient(op)
+
+	server.handleCommand(a, "/who")
+	if out := aliceOutput(); !containsSubstring(out, "Alice (connected 0s, 3 messages)") || containsSubstring(out, "192.168.1.1") {
+		t.Errorf("Expected the limited view, got %q", out)
+	}
+
+	server.handleCommand(o, "/who")
+	if out := opOutput(); !containsSubstring(out, "Alice (connected 0s, 3 messages, from 192.168.1.1 over tcp, deflate)") {
+		t.Errorf("Expected the full view, got %q", out)
+	}
+}
+
+// Test that /who and /whois show how long a client has been idle, and that
+// anything it sends makes it active again
+func TestWhoIdle(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	alice.connectedAt = time.Now().Add(-20 * time.Minute)
+	alice.lastActivity = time.Now().Add(-12*time.Minute - time.Second)
+	a := server.addClient(alice)
+
+	server.handleCommand(a, "/who")
+	server.handleCommand(a, "/whois Alice")
+	if out := aliceOutput(); !containsSubstring(out, "Alice (connected 20m0s, 0 messages, idle 12m)") || !containsSubstring(out, "Alice: connected 20m0s, 0 messages, idle 12m,") {
+		t.Errorf("Expected Alice to be shown idle, got %q", out)
+	}
+
+	conn, peer := net.Pipe()
+	a.conn = conn
+	go io.Copy(io.Discard, peer)
+	go server.readLoop(conn, a)
+	peer.Write([]byte("hello\n"))
+	peer.Close()
+	time.Sleep(10 * time.Millisecond)
+	if details := server.clientDetails(a, false); containsSubstring(details, "idle") {
+		t.Errorf("Expected Alice to be active after sending, got %q", details)
+	}
+
+	for idle, want := range map[time.Duration]string{time.Minute: "1m", 90 * time.Minute: "1h30m", 2*time.Hour + 30*time.Second: "2h"} {
+		if got := idleTime(idle); got != want {
+			t.Errorf("idleTime(%s) = %q, want %q", idle, got, want)
+		}
+	}
+}
+
+// Test that a message longer than max_message_size is refused whole,
+// rather than sent in pieces
+func TestMessageTooLong(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxMessageSize = 16
+	server := newTestServer(t, cfg)
+
+	conn, peer := net.Pipe()
+	a := server.addClient(mockClient("Alice", "192.168.1.1", conn))
+	var out lockedBuffer
+	go io.Copy(&out, peer)
+	go server.readLoop(conn, a)
+	peer.Write([]byte("short\n"))
+	peer.Write([]byte(strings.Repeat("long ", 8) + "\n"))
+	peer.Write([]byte("after\n"))
+	time.Sleep(20 * time.Millisecond)
+	peer.Close()
+
+	if got := strings.Count(out.String(), "ERR_MSG_TOO_LONG: Messages can be at most 15 bytes"); got != 1 {
+		t.Errorf("Expected the long message to be refused once, got %q", out.String())
+	}
+	if history := server.replayHistory(); !containsSubstring(history, "short") || !containsSubstring(history, "after") || containsSubstring(history, "long") {
+		t.Errorf("Expected none of the long message to be sent, got %q", history)
+	}
+}
+
+// Test that error replies carry a stable code, as a field in JSON replies
+func TestErrorCodes(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	server.addClient(alice)
+
+	server.handleCommand(&alice, "/nope")
+	server.handleCommand(&alice, "/whois Zed")
+	server.handleCommand(&alice, "/history page many")
+
+	for _, want := range []string{
+		"ERR_UNKNOWN_COMMAND: unknown command /nope",
+		"ERR_NO_SUCH_USER: no such user Zed",
+		`{"code":"ERR_USAGE","error":"usage: /history page`,
+	} {
+		if !containsSubstring(output(), want) {
+			t.Errorf("Expected %q in %q", want, output())
+		}
+	}
+
+	if err := server.checkName(""); !errors.Is(err, ErrNameEmpty) {
+		t.Errorf("Expected an empty name to be ERR_NAME_EMPTY, got %v", err)
+	}
+	for _, name := range []string{"/who", "[admin]", "a,b", "bell\a", strings.Repeat("x", maxNameLength+1)} {
+		if err := server.checkName(name); !errors.Is(err, ErrNameInvalid) {
+			t.Errorf("Expected %q to be ERR_NAME_INVALID, got %v", name, err)
+		}
+	}
+	if err := server.checkName("John Doe"); err != nil {
+		t.Errorf("Expected a name with a space to be allowed, got %v", err)
+	}
+}
+
+// Test that errors match their sentinels and keep their causes
+func TestClientErrorChain(t *testing.T) {
+	err := newClientError(ErrCodeNameTaken, "Name Bob is already taken.")
+	if !errors.Is(err, ErrNameTaken) || errors.Is(err, ErrNameEmpty) {
+		t.Errorf("Expected %v to match only ErrNameTaken", err)
+	}
+
+	_, statErr := os.Stat(filepath.Join(t.TempDir(), "missing"))
+	wrapped := asClientError(statErr)
+	if !errors.Is(wrapped, ErrInternal) || !errors.Is(wrapped, os.ErrNotExist) {
+		t.Errorf("Expected an internal error wrapping the cause, got %v", wrapped)
+	}
+
+	var pathErr *os.PathError
+	if !errors.As(wrapped, &pathErr) {
+		t.Errorf("Expected errors.As to reach the *os.PathError")
+	}
+}
+
+// Test that %+v shows causes, and the stack only in debug mode
+func TestClientErrorFormat(t *testing.T) {
+	cause := errors.New("disk full")
+	err := wrapClientError(ErrCodeInternal, "export failed", cause)
+	if got := fmt.Sprintf("%v", err); got != "ERR_INTERNAL: export failed" {
+		t.Errorf("Unexpected %%v formatting %q", got)
+	}
+	if got := fmt.Sprintf("%+v", err); got != "ERR_INTERNAL: export failed\ncaused by: disk full" {
+		t.Errorf("Unexpected %%+v formatting %q", got)
+	}
+
+	captureStacks.Store(true)
+	defer captureStacks.Store(false)
+	err = wrapClientError(ErrCodeInternal, "export failed", cause)
+	if got := fmt.Sprintf("%+v", err); !containsSubstring(got, "TestClientErrorFormat") {
+		t.Errorf("Expected the stack to name the test, got %q", got)
+	}
+}
+
+// Test that /server reports the limits clients are subject to
+func TestServerInfo(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.config.ServerName = "chat1"
+	server.config.ClientSendRate = 4096
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+	server.handleCommand(a, "/server")
+
+	for _, want := range []string{
+		"net-cat dev (commit ",
+		"on chat1, up 0s",
+		"limits: up to 10 clients, messages up to 2048 bytes, sending up to 4096 bytes/s to each client",
+		"load: 1 clients connected, 0 messages in the last hour",
+	} {
+		if !containsSubstring(output(), want) {
+			t.Errorf("Expected %q in %q", want, output())
+		}
+	}
+}
+
+// Test that /config shows the running settings to operators with secrets
+// masked
+func TestConfigCommand(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.config.OperatorPassword = "hunter2"
+	server.config.LinkPassword = "linkpass"
+
+	op, output := pipeClient(t, "Op", "192.168.1.1")
+	op.operator = true
+	server.handleCommand(&op, "/config")
+
+	for _, want := range []string{
+		"max_clients: 10",
+		`log_file: "` + server.config.LogFile + `"`,
+		`operator_password: "********"`,
+		`link_password: "********"`,
+		`log_key: ""`,
+	} {
+		if !containsSubstring(output(), want) {
+			t.Errorf("Expected %q in %q", want, output())
+		}
+	}
+	if containsSubstring(output(), "hunter2") || containsSubstring(output(), "linkpass") {
+		t.Errorf("Expected secrets to be masked, got %q", output())
+	}
+
+	op2, output2 := pipeClient(t, "Op2", "192.168.1.2")
+	op2.operator = true
+	server.handleCommand(&op2, "/config max_clients nosuch")
+	if !containsSubstring(output2(), "ERR_NOT_FOUND: unknown setting nosuch") {
+		t.Errorf("Expected an unknown setting to be refused, got %q", output2())
+	}
+}
+
+// Test that command lines are split with quoting and escaping, and that
+// free text is passed as it was typed
+func TestSplitArgs(t *testing.T) {
+	for _, tc := range []struct {
+		line  string
+		limit int
+		want  []string
+	}{
+		{`/poll "lunch?" pizza  "fish and chips"`, -1, []string{"/poll", "lunch?", "pizza", "fish and chips"}},
+		{`/topic back\ slash \\ ""`, -1, []string{"/topic", "back slash", `\`, ""}},
+		{`/who`, -1, []string{"/who"}},
+		{`/msg "John Doe" hi  there`, 2, []string{"/msg", "John Doe", "hi  there"}},
+		{`/msg bob say \"hi\" to 5" screens`, 2, []string{"/msg", "bob", `say \"hi\" to 5" screens`}},
+		{`/topic  "quoted"   spaced`, 1, []string{"/topic", `"quoted"   spaced`}},
+		{`/dnd off`, 2, []string{"/dnd", "off"}},
+	} {
+		got, err := splitArgs(tc.line, tc.limit)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("splitArgs(%q, %d) = %q, %v, want %q", tc.line, tc.limit, got, err, tc.want)
+		}
+	}
+
+	if _, err := splitArgs(`/poll "lunch? pizza`, -1); err == nil {
+		t.Errorf("Expected an unterminated quote to be an error")
+	}
+}
+
+// Test that a message keeps its quotes and spacing
+func TestFreeText(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, output := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	server.addClient(bob)
+
+	server.handleCommand(a, `/msg Bob it's a 5" screen,  "really"`)
+	if !containsSubstring(output(), `it's a 5" screen,  "really"`) {
+		t.Errorf("Expected the message as typed, got %q", output())
+	}
+}
+
+// Test that built-in and configured aliases run their commands
+func TestCommandAliases(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Plugins = []string{"fun"}
+	cfg.Aliases = map[string]string{"/dice": "/roll", "/w": "/whois"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, cfg)
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	server.addClient(bob)
+	server.handleCommand(a, "/m Bob hi Bob")
+	if !containsSubstring(bobOutput(), "hi Bob") {
+		t.Errorf("Expected /m to send a direct message, got %q", bobOutput())
+	}
+	server.handleCommand(a, "/w Alice")
+	if !containsSubstring(output(), "Alice: ") {
+		t.Errorf("Expected the configured /w to run /whois, got %q", output())
+	}
+	server.handleCommand(a, "/dice")
+	if containsSubstring(output(), ErrCodeUnknownCommand) {
+		t.Errorf("Expected /dice to run /roll, got %q", output())
+	}
+
+	for _, tc := range []struct{ alias, target, want string }{
+		{"w", "/who", "must start with /"},
+		{"/who", "/whois", "would hide the command"},
+		{"/x", "/nosuch", "unknown command /nosuch"},
+	} {
+		cfg.Aliases = map[string]string{tc.alias: tc.target}
+		if err := cfg.Validate(); err == nil || !containsSubstring(err.Error(), tc.want) {
+			t.Errorf("Expected alias %s to be refused with %q, got %v", tc.alias, tc.want, err)
+		}
+	}
+}
+
+// Test that text commands from the config reply or broadcast their text
+func TestTextCommands(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TextCommands = map[string]TextCommand{
+		"/rules": {Text: "1. Be kind\n2. No spam", Help: "show the house rules"},
+		"/wiki":  {Text: "Docs: https://wiki.example.org", Broadcast: true},
+	}
+	cfg.Aliases = map[string]string{"/ru": "/rules"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, cfg)
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	server.addClient(bob)
+
+	server.handleCommand(a, "/ru")
+	if !containsSubstring(output(), "1. Be kind\n2. No spam") || containsSubstring(bobOutput(), "Be kind") {
+		t.Errorf("Expected /rules to reply to Alice alone, got %q", output())
+	}
+	server.handleCommand(a, "/wiki")
+	if !containsSubstring(bobOutput(), "Docs: https://wiki.example.org") {
+		t.Errorf("Expected /wiki to be broadcast, got %q", bobOutput())
+	}
+	server.handleCommand(a, "/help")
+	if !containsSubstring(output(), "/rules - show the house rules") {
+		t.Errorf("Expected /help to list /rules, got %q", output())
+	}
+
+	cfg.TextCommands = map[string]TextCommand{"/who": {Text: "nobody"}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a text command replacing /who to be refused.")
+	}
+	cfg.TextCommands = map[string]TextCommand{"/empty": {}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a text command without text to be refused.")
+	}
+}
+
+// Test that /help json lists the commands a client may run with their
+// usage and aliases
+func TestHelpJSON(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Aliases = map[string]string{"/wi": "/whois"}
+	server := newTestServer(t, cfg)
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+	server.handleCommand(a, "/help json")
+
+	var list CommandList
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output())), &list); err != nil {
+		t.Fatalf("Expected a JSON reply, got %q: %v", output(), err)
+	}
+	byName := map[string]CommandInfo{}
+	for _, cmd := range list.Commands {
+		byName[cmd.Name] = cmd
+	}
+	if who := byName["/who"]; who.Usage != "/who" || !slices.Equal(who.Aliases, []string{"/w"}) {
+		t.Errorf("Expected /who with alias /w, got %+v", who)
+	}
+	if whois := byName["/whois"]; !slices.Equal(whois.Aliases, []string{"/wi"}) {
+		t.Errorf("Expected /whois with alias /wi, got %+v", whois)
+	}
+	if _, ok := byName["/global"]; ok {
+		t.Errorf("Expected operator commands to be left out for regular clients.")
+	}
+}
+
+// Test that only operators can post while the chat is announcement-only
+func TestAnnounceOnly(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AnnounceOnly = true
+	cfg.OperatorPassword = "secret"
+	cfg.Plugins = []string{"fun"}
+	server := newTestServer(t, cfg)
+
+	op, _ := pipeClient(t, "Op", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	o := server.addClient(op)
+	b := server.addClient(bob)
+
+	if server.mayPost(b) || !containsSubstring(bobOutput(), "ERR_PERMISSION_DENIED: the chat is announcement-only") {
+		t.Errorf("Expected Bob not to be allowed to post, got %q", bobOutput())
+	}
+	server.handleCommand(b, "/flip")
+	if containsSubstring(server.replayHistory(), "flipped a coin") {
+		t.Errorf("Expected /flip to be refused, got %q", server.replayHistory())
+	}
+	server.handleCommand(b, "/who")
+	if !containsSubstring(bobOutput(), "Op") {
+		t.Errorf("Expected Bob to still run commands that only answer him, got %q", bobOutput())
+	}
+
+	server.handleCommand(o, "/oper secret")
+	if !server.mayPost(o) {
+		t.Errorf("Expected the operator to be allowed to post.")
+	}
+	server.handleCommand(o, "/readonly off")
+	if !server.mayPost(b) || !containsSubstring(bobOutput(), "Op opened the chat: everyone can post again") {
+		t.Errorf("Expected /readonly off to let Bob post, got %q", bobOutput())
+	}
+}
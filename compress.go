@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Machine clients and bridges that move a lot of text can ask for the rest
+// of the connection to be compressed by answering the name prompt with
+// "/compress deflate" or "/compress gzip". The server replies
+// "compression: <method>" in plain text and, from then on, both directions
+// are compressed streams, each write flushed as it is made. Everyone else
+// keeps talking plain text.
+
+// compressionMethods are the methods a client may ask for.
+var compressionMethods = []string{"deflate", "gzip"}
+
+// compressionRequest reports whether a name line asks for compression and,
+// if so, which method.
+func compressionRequest(line string) (string, bool) {
+	method, ok := strings.CutPrefix(line, "/compress ")
+	return strings.TrimSpace(method), ok
+}
+
+// flushWriter is a compressor that can push out what it holds so far.
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressedConn is a connection whose traffic is compressed with one of
+// compressionMethods in both directions.
+type compressedConn struct {
+	net.Conn
+	method string
+
+	// src is where compressed input is read from. The decompressor is
+	// opened on the first Read, since a gzip reader blocks until the
+	// peer's header arrives.
+	src    io.Reader
+	readMu sync.Mutex
+	r      io.Reader
+
+	writeMu sync.Mutex
+	w       flushWriter
+}
+
+// newCompressedConn compresses traffic on conn with method. Input is read
+// through reader, which may already hold data buffered from conn.
+func newCompressedConn(conn net.Conn, reader *bufio.Reader, method string) (*compressedConn, error) {
+	c := &compressedConn{Conn: conn, method: method, src: reader}
+	switch method {
+	case "deflate":
+		w, err := flate.NewWriter(conn, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		c.w = w
+	case "gzip":
+		c.w = gzip.NewWriter(conn)
+	default:
+		return nil, newClientError(ErrCodeInvalidArgument, "unsupported compression "+method+", try "+strings.Join(compressionMethods, " or "))
+	}
+	return c, nil
+}
+
+// compressionMethod returns the compression negotiated on conn, or "".
+func compressionMethod(conn net.Conn) string {
+	if c, ok := conn.(*compressedConn); ok {
+		return c.method
+	}
+	return ""
+}
+
+func (c *compressedConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if c.r == nil {
+		if c.method == "gzip" {
+			r, err := gzip.NewReader(c.src)
+			if err != nil {
+				return 0, err
+			}
+			c.r = r
+		} else {
+			c.r = flate.NewReader(c.src)
+		}
+	}
+	return c.r.Read(p)
+}
+
+// Write compresses p and flushes it, so the peer sees every message as soon
+// as it is sent.
+func (c *compressedConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+// Close ends the compressed stream before closing the connection.
+func (c *compressedConn) Close() error {
+	c.writeMu.Lock()
+	c.w.Close()
+	c.writeMu.Unlock()
+	return c.Conn.Close()
+}
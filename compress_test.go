@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"net-cat/pkg/client"
+)
+
+// Test that compressed and plain clients can talk to each other
+func TestCompressedClients(t *testing.T) {
+	cfg := DefaultConfig()
+	server := newTestServer(t, cfg)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.handleConn(conn)
+		}
+	}()
+	addr := ln.Addr().String()
+
+	plain, err := client.Dial(addr, "plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+
+	for _, method := range []string{"deflate", "gzip"} {
+		c, err := client.DialCompressed(addr, method+"bot", method)
+		if err != nil {
+			t.Fatalf("Expected to join with %s, got %v", method, err)
+		}
+		if err := c.Send("hello over " + method); err != nil {
+			t.Fatal(err)
+		}
+
+		timeout := time.After(2 * time.Second)
+	wait:
+		for {
+			select {
+			case ev := <-plain.Messages():
+				if m, ok := ev.(client.Message); ok && m.Text == "hello over "+method {
+					break wait
+				}
+			case <-timeout:
+				t.Fatalf("Expected the %s message to reach the plain client.", method)
+			}
+		}
+		c.Close()
+	}
+
+	if _, err := client.DialCompressed(addr, "zipbot", "zip"); err == nil {
+		t.Errorf("Expected an unsupported method to be refused.")
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config holds the server settings that can be changed without
+// recompiling. It is read from the JSON file named by NETCAT_CONFIG, and
+// secrets can also be supplied through the environment.
+type Config struct {
+	// Listen is the address to listen on, as accepted by
+	// ValidateListenAddr. An address given on the command line takes
+	// precedence; with neither the server listens on port 8989.
+	Listen string `json:"listen"`
+
+	// PortFallback is a range of ports, such as "8990-8999", to try in
+	// turn when the listen port is already in use.
+	PortFallback string `json:"port_fallback"`
+
+	// TCPKeepAlive is how long a client connection may be silent before
+	// keepalive probes check that its peer is still there, and the interval
+	// between probes. Zero uses Go's default of 15 seconds; a negative
+	// value turns keepalives off.
+	TCPKeepAlive Duration `json:"tcp_keepalive"`
+
+	// TCPNoDelay turns Nagle's algorithm off (true, Go's default) or on for
+	// accepted connections. TCPReadBuffer and TCPWriteBuffer, when
+	// positive, set their socket buffer sizes in bytes. TCPReuseAddr and
+	// TCPReusePort set SO_REUSEADDR and SO_REUSEPORT on the listener, the
+	// latter letting several servers share one port.
+	TCPNoDelay     *bool `json:"tcp_nodelay"`
+	TCPReadBuffer  int   `json:"tcp_read_buffer"`
+	TCPWriteBuffer int   `json:"tcp_write_buffer"`
+	TCPReuseAddr   bool  `json:"tcp_reuse_addr"`
+	TCPReusePort   bool  `json:"tcp_reuse_port"`
+
+	// ClientSendRate, when positive, limits how many bytes per second are
+	// sent to each client, so replaying a long history to one client does
+	// not slow the chat down for everyone else.
+	ClientSendRate int `json:"client_send_rate"`
+
+	// ReverseDNS looks up the host name of each client's address and shows
+	// it instead of the address to operators and in the event log. Lookups
+	// are cached and give up after a couple of seconds. It has no effect
+	// while RedactIPs is set.
+	ReverseDNS bool `json:"reverse_dns"`
+
+	// GeoIPDatabase, when set, is a MaxMind country database such as
+	// GeoLite2-Country.mmdb. Each client is tagged with the country its
+	// address is in, which operators see, and AllowCountries and
+	// DenyCountries, lists of ISO country codes, can then limit who may
+	// connect. Clients whose country is unknown are always let in.
+	GeoIPDatabase  string   `json:"geoip_database"`
+	AllowCountries []string `json:"allow_countries"`
+	DenyCountries  []string `json:"deny_countries"`
+
+	// DefaultRoom, such as "#lobby", names the main chat, where everyone
+	// starts, as a room clients can /join back to and see in /rooms.
+	DefaultRoom string `json:"default_room"`
+
+	// RoomCapacity caps how many clients can be in a room at once, by room
+	// name, apart from MaxClients for the whole server. Rooms left out
+	// have no cap of their own.
+	RoomCapacity map[string]int `json:"room_capacity"`
+
+	// JoinMessage and LeaveMessage are the notices broadcast when someone
+	// joins or leaves, as text/templates that can use {{.Name}}, {{.Time}},
+	// {{.Room}} and {{.Online}}, the last being e.g. "(7 users online)". An
+	// empty template turns the notice off.
+	JoinMessage  string `json:"join_message"`
+	LeaveMessage string `json:"leave_message"`
+
+	// AnnounceJoins and AnnounceLeaves broadcast the join and leave
+	// notices. Busy servers can turn them off while keeping the templates,
+	// which also pick the notices out when a user's messages are scrubbed.
+	AnnounceJoins  bool `json:"announce_joins"`
+	AnnounceLeaves bool `json:"announce_leaves"`
+
+	// AnnounceOnly makes the chat announcement-only: only operators can
+	// post, see mayPost.
+	AnnounceOnly bool `json:"announce_only"`
+
+	// NoticeBatchWindow, when set, holds back join and leave notices that
+	// follow another within the window and sends them as one summary.
+	NoticeBatchWindow Duration `json:"notice_batch_window"`
+
+	// DedupeWindow, when set, holds back a system notice that repeats the
+	// one before within the window, and then sends the repeats as one
+	// line with a count.
+	DedupeWindow Duration `json:"dedupe_window"`
+
+	// Templates replaces the text of other output templates by name, such
+	// as "banner" or "help"; see outputTemplates for the names and the
+	// fields each can use.
+	Templates map[string]string `json:"templates"`
+
+	// ShowBanner sends new connections the ASCII art welcome banner. Turn
+	// it off for devices that cannot cope with it; clients can also skip
+	// it by announcing the "nobanner" capability.
+	ShowBanner bool `json:"show_banner"`
+
+	// TelnetNAWS asks clients to report their terminal width with the
+	// telnet NAWS option, so messages are wrapped to fit. Telnet clients
+	// answer; others show the request as a few stray characters.
+	TelnetNAWS bool `json:"telnet_naws"`
+
+	// MaxHandshakes, MaxDeliveries and MaxLogWriters cap how many
+	// connections may be joining at once, how many messages may be being
+	// broadcast at once and how many log writes may be waiting. Work over
+	// a cap is refused with ERR_BUSY, or for log writes dropped, so a
+	// connection storm cannot exhaust the process. Zero means no limit.
+	MaxHandshakes int `json:"max_handshakes"`
+	MaxDeliveries int `json:"max_deliveries"`
+	MaxLogWriters int `json:"max_log_writers"`
+
+	// DeliveryOverflow is what happens to a chat message sent while
+	// MaxDeliveries are already being broadcast: "refuse" (the default),
+	// "wait" up to DeliveryWait first, "drop" it silently, or "disconnect"
+	// the sender; see overflowPolicies.
+	DeliveryOverflow string   `json:"delivery_overflow"`
+	DeliveryWait     Duration `json:"delivery_wait"`
+
+	// HandshakeTimeout is how long a new connection has to pick a name,
+	// so ones that never do cannot hold on to the MaxHandshakes slots.
+	// Zero means no limit.
+	HandshakeTimeout Duration `json:"handshake_timeout"`
+
+	// AcceptRate, when set, is how many new connections a second are
+	// started, with up to AcceptQueue more waiting; connections beyond
+	// that are refused with ERR_BUSY.
+	AcceptRate  int `json:"accept_rate"`
+	AcceptQueue int `json:"accept_queue"`
+
+	// MaxClients is how many clients may be in the chat at once. Zero
+	// means no limit.
+	MaxClients int `json:"max_clients"`
+
+	// MaxMessageSize is the longest message a client may send, in bytes
+	// with its newline; longer ones are refused with ERR_MSG_TOO_LONG.
+	// Zero uses 2048.
+	MaxMessageSize int `json:"max_message_size"`
+
+	// MessageRateLimit, when positive, is how many chat messages each
+	// client may send a minute. Further messages are refused with
+	// ERR_RATE_LIMIT until the minute is over.
+	MessageRateLimit int `json:"message_rate_limit"`
+
+	// MaxFileSize is the largest file /sendfile may offer, in bytes. Zero
+	// turns file transfers off.
+	MaxFileSize int64 `json:"max_file_size"`
+
+	// ShareMaxSize is the largest snippet /share keeps, in bytes, and
+	// ShareTTL how long it is kept. A zero ShareMaxSize turns /share off.
+	ShareMaxSize int      `json:"share_max_size"`
+	ShareTTL     Duration `json:"share_ttl"`
+
+	// LogFile is where chat messages are appended.
+	LogFile string `json:"log_file"`
+
+	// LogKey, when set, encrypts the log file at rest. Any string is
+	// accepted; it is stretched into an AES-256 key. NETCAT_LOG_KEY
+	// overrides the value from the file.
+	LogKey string `json:"log_key"`
+
+	// RedactIPs replaces remote addresses in the event log with a keyed
+	// hash, so records from one connection can still be correlated.
+	RedactIPs bool `json:"redact_ips"`
+
+	// OperatorPassword is required by /oper to gain operator rights. Operator
+	// commands are disabled while it is empty. NETCAT_OPERATOR_PASSWORD
+	// overrides the value from the file.
+	OperatorPassword string `json:"operator_password"`
+
+	// ExportDir is where /export writes transcript files.
+	ExportDir string `json:"export_dir"`
+
+	// LogSyncInterval is how often the log file is synced to disk and a
+	// checkpoint of how much of it is complete is written beside it. Zero
+	// syncs after every message.
+	LogSyncInterval Duration `json:"log_sync_interval"`
+
+	// SnapshotFile, when set, is where the in-memory history is saved every
+	// SnapshotInterval and on shutdown, and loaded from on startup.
+	SnapshotFile     string   `json:"snapshot_file"`
+	SnapshotInterval Duration `json:"snapshot_interval"`
+
+	// MessageTTL, when positive, is how long messages are kept before a
+	// background janitor prunes them from memory and disk.
+	MessageTTL Duration `json:"message_ttl"`
+
+	// DMRetention is how long direct messages are kept, in memory only and
+	// apart from the public history, so participants can review them with
+	// /dms. Zero means direct messages are never stored.
+	DMRetention Duration `json:"dm_retention"`
+
+	// StateFile is where chat state such as the topic is saved so it
+	// survives restarts. An empty value keeps it in memory only.
+	StateFile string `json:"state_file"`
+
+	// ServerName identifies this server to linked servers. It defaults to
+	// the host name.
+	ServerName string `json:"server_name"`
+
+	// Links lists the addresses of servers to link to so they share one
+	// chat. Both ends must use the same LinkPassword, which
+	// NETCAT_LINK_PASSWORD overrides. Incoming links are refused while
+	// LinkPassword is empty.
+	Links        []string `json:"links"`
+	LinkPassword string   `json:"link_password"`
+
+	// Upstream, when set, is a server this one joins as an ordinary client
+	// called UpstreamName, mirroring messages in both directions.
+	// UpstreamName defaults to "relay-" followed by ServerName.
+	Upstream     string `json:"upstream"`
+	UpstreamName string `json:"upstream_name"`
+
+	// Announcements are sent to everyone on their schedules. Operators can
+	// add more at runtime with /schedule.
+	Announcements []Announcement `json:"announcements"`
+
+	// Plugins names the optional command plugins to enable, such as "fun"
+	// for /roll, /flip and /8ball.
+	Plugins []string `json:"plugins"`
+
+	// MemoryBudget, when positive, is roughly how many bytes the in-memory
+	// history and the direct messages held for clients may take up. Past
+	// it the oldest messages are dropped from the history, and if the held
+	// messages alone exceed it new messages are refused with ERR_BUSY.
+	MemoryBudget int `json:"memory_budget"`
+
+	// WatchdogTimeout is how long a message may take to be written to one
+	// client before the watchdog logs it, with a dump of every goroutine,
+	// as stuck. With WatchdogDisconnect set the client is also
+	// disconnected, so it stops holding up the rest of the broadcast.
+	// Zero turns the watchdog off.
+	WatchdogTimeout    Duration `json:"watchdog_timeout"`
+	WatchdogDisconnect bool     `json:"watchdog_disconnect"`
+
+	// SlowClientThreshold flags a client to operators as slow when most
+	// recent deliveries to it took longer. Zero never flags anyone.
+	SlowClientThreshold Duration `json:"slow_client_threshold"`
+
+	// KickCooldown is how long someone kicked with /kick may not rejoin
+	// under the same name or from the same address, unless /kick is given
+	// another. Zero lets them rejoin straight away.
+	KickCooldown Duration `json:"kick_cooldown"`
+
+	// ReapAfter is how long a client whose connection the server closed
+	// may stay in the chat before the reaper removes it. Zero turns the
+	// reaper off.
+	ReapAfter Duration `json:"reap_after"`
+
+	// MetricsListen, when set, is an address such as "127.0.0.1:9100"
+	// where Prometheus can scrape the server's metrics from /metrics.
+	MetricsListen string `json:"metrics_listen"`
+
+	// TextCommands are extra commands, such as "/rules", that send
+	// configured text.
+	TextCommands map[string]TextCommand `json:"text_commands"`
+
+	// Aliases maps extra short command names to the commands they stand
+	// for, such as "/j": "/join", on top of the built-in /h, /m and /w.
+	Aliases map[string]string `json:"aliases"`
+
+	// Debug records a stack trace with every error reported to a client,
+	// so internal failures logged by the server show where they happened.
+	Debug bool `json:"debug"`
+}
+
+// Duration is a time.Duration written in config files as a string such as
+// "30s" or "5m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultConfig returns the settings used when no config file is given.
+func DefaultConfig() Config {
+	return Config{
+		JoinMessage:         defaultJoinMessage,
+		LeaveMessage:        defaultLeaveMessage,
+		AnnounceJoins:       true,
+		AnnounceLeaves:      true,
+		ShowBanner:          true,
+		MaxHandshakes:       64,
+		MaxDeliveries:       256,
+		MaxLogWriters:       256,
+		HandshakeTimeout:    Duration(30 * time.Second),
+		DeliveryOverflow:    overflowRefuse,
+		DeliveryWait:        Duration(time.Second),
+		AcceptQueue:         16,
+		MaxClients:          10,
+		MaxMessageSize:      defaultMaxMessageSize,
+		MaxFileSize:         1 << 20,
+		ShareMaxSize:        64 << 10,
+		ShareTTL:            Duration(24 * time.Hour),
+		LogFile:             "server_log.txt",
+		LogSyncInterval:     Duration(time.Second),
+		ExportDir:           "exports",
+		SnapshotInterval:    Duration(time.Minute),
+		StateFile:           "server_state.json",
+		WatchdogTimeout:     Duration(30 * time.Second),
+		ReapAfter:           Duration(time.Minute),
+		SlowClientThreshold: Duration(500 * time.Millisecond),
+		ServerName:          hostname(),
+	}
+}
+
+// LoadConfig reads the JSON config file at path on top of DefaultConfig and
+// applies environment overrides. An empty path skips the file.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	if key := os.Getenv("NETCAT_LOG_KEY"); key != "" {
+		cfg.LogKey = key
+	}
+	if password := os.Getenv("NETCAT_OPERATOR_PASSWORD"); password != "" {
+		cfg.OperatorPassword = password
+	}
+	if password := os.Getenv("NETCAT_LINK_PASSWORD"); password != "" {
+		cfg.LinkPassword = password
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate reports the first setting that cannot work.
+func (c Config) Validate() error {
+	if c.Listen != "" {
+		if err := ValidateListenAddr(c.Listen); err != nil {
+			return err
+		}
+	}
+	if c.DefaultRoom != "" {
+		if _, err := roomName(c.DefaultRoom); err != nil {
+			return fmt.Errorf("default_room: %w", err)
+		}
+	}
+	lobby, _ := roomName(c.DefaultRoom)
+	for name, limit := range c.RoomCapacity {
+		room, err := roomName(name)
+		if err != nil {
+			return fmt.Errorf("room_capacity: %w", err)
+		}
+		if limit < 1 {
+			return fmt.Errorf("room_capacity: %s must hold at least 1 client", name)
+		}
+		if room == lobby {
+			return fmt.Errorf("room_capacity: %s is the main chat, which max_clients caps", name)
+		}
+	}
+	if c.PortFallback != "" {
+		if _, _, err := parsePortRange(c.PortFallback); err != nil {
+			return err
+		}
+	}
+	if _, err := parseTemplates(c.templateOverrides()); err != nil {
+		return err
+	}
+	if c.MaxMessageSize < 0 || c.MessageRateLimit < 0 {
+		return errors.New("max_message_size and message_rate_limit cannot be negative")
+	}
+	if c.MaxMessageSize > maxMaxMessageSize {
+		return fmt.Errorf("max_message_size cannot be more than %d", maxMaxMessageSize)
+	}
+	if c.MaxFileSize < 0 || c.ShareMaxSize < 0 {
+		return errors.New("max_file_size and share_max_size cannot be negative")
+	}
+	if c.DeliveryOverflow != "" && !slices.Contains(overflowPolicies, c.DeliveryOverflow) {
+		return fmt.Errorf("delivery_overflow must be one of %s", strings.Join(overflowPolicies, ", "))
+	}
+	if c.AcceptRate < 0 || c.AcceptQueue < 0 {
+		return errors.New("accept_rate and accept_queue cannot be negative")
+	}
+	for _, a := range c.Announcements {
+		if _, err := parseCron(a.Schedule); err != nil {
+			return err
+		}
+	}
+	if err := checkPlugins(c.Plugins); err != nil {
+		return err
+	}
+	if err := checkTextCommands(c.TextCommands, c.Plugins); err != nil {
+		return err
+	}
+	return checkAliases(c.Aliases, availableCommands(c))
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "net-cat"
+	}
+	return name
+}
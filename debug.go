@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+)
+
+// writeDebugDump writes what is needed to debug a live incident: the
+// goroutine count, memory, the broadcasts in flight and every client's
+// state and queues. With stacks, it ends with the stacks of all goroutines,
+// grouped and counted by where they are.
+func (s *Server) writeDebugDump(w io.Writer, stacks bool) {
+	now := time.Now()
+	fmt.Fprintf(w, "debug dump at %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(w, "%s on %s, up %s\n", versionString(), s.config.ServerName, now.Sub(s.startedAt).Round(time.Second))
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	history, queues := s.memoryUsage()
+	fmt.Fprintf(w, "goroutines: %d\n", runtime.NumGoroutine())
+	fmt.Fprintf(w, "memory: %d bytes of heap in use, %d from the OS, %d collections\n", mem.HeapInuse, mem.Sys, mem.NumGC)
+	fmt.Fprintf(w, "history: %d messages, about %d bytes; held direct messages: about %d bytes\n", len(s.history.Messages()), history, queues)
+	fmt.Fprintln(w, s.budgetStats())
+	fmt.Fprintf(w, "dropped broadcasts: %d\n", s.droppedMessages.Load())
+
+	clients := s.clients.All()
+	waiting := make([]int, len(clients))
+	backlog := 0
+	for i, c := range clients {
+		if c.gate != nil {
+			waiting[i] = c.gate.pending()
+			backlog += waiting[i]
+		}
+	}
+	fmt.Fprintf(w, "deliveries waiting for a client: %d\n", backlog)
+
+	s.mu.Lock()
+	fmt.Fprintf(w, "file transfers: %d, shared snippets: %d, rooms: %d\n", len(s.transfers), len(s.snippets), len(s.rooms))
+	fmt.Fprintf(w, "clients: %d\n", len(clients))
+	for i, c := range clients {
+		fmt.Fprintf(w, "  %s %s from %s, joined %s ago, %s, %d waiting, %d held, %d sent\n",
+			c.connID, c.name.get(), c.ipAdd, now.Sub(c.connectedAt).Round(time.Second), clientState(c),
+			waiting[i], len(c.held), c.messages)
+	}
+	s.mu.Unlock()
+
+	if stacks {
+		fmt.Fprintln(w)
+		pprof.Lookup("goroutine").WriteTo(w, 1)
+	}
+}
+
+// clientState describes what c is doing for the debug dump. The caller
+// must hold s.mu.
+func clientState(c *Client) string {
+	var states []string
+	switch {
+	case c.left:
+		states = append(states, "left")
+	case !c.closedAt.IsZero():
+		states = append(states, "closing since "+c.closedAt.Format(time.TimeOnly))
+	default:
+		states = append(states, "connected")
+	}
+	if c.room != "" {
+		states = append(states, "in "+c.room)
+	}
+	if c.operator {
+		states = append(states, "operator")
+	}
+	if c.dnd {
+		states = append(states, "dnd")
+	}
+	if c.share != nil {
+		states = append(states, "sharing")
+	}
+	if c.ephemeral {
+		states = append(states, "ephemeral")
+	}
+	return strings.Join(states, " ")
+}
+
+// debugToFile writes a debug dump with stacks to a new file in
+// Config.ExportDir and returns its path.
+func (s *Server) debugToFile() (string, error) {
+	if err := os.MkdirAll(s.config.ExportDir, 0o755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.config.ExportDir, "debug-"+time.Now().Format("20060102-150405.000")+".txt")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s.writeDebugDump(f, true)
+	return path, f.Close()
+}
+
+func cmdDebug(s *Server, client *Client, args []string) {
+	switch {
+	case len(args) == 0:
+		var b strings.Builder
+		s.writeDebugDump(&b, false)
+		s.reply(client, strings.TrimSuffix(b.String(), "\n"))
+	case len(args) == 1 && args[0] == "file":
+		path, err := s.debugToFile()
+		if err != nil {
+			s.replyError(client, wrapClientError(ErrCodeInternal, "debug dump failed: "+err.Error(), err))
+			return
+		}
+		s.reply(client, "debug dump written to "+path)
+	default:
+		s.replyUsage(client, "/debug")
+	}
+}
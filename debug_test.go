@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that the debug dump shows each client's state and can be written
+// to a file with goroutine stacks
+func TestDebugDump(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OperatorPassword = "secret"
+	cfg.ExportDir = t.TempDir()
+	server := newTestServer(t, cfg)
+
+	op, opOutput := pipeClient(t, "Op", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	op.connectedAt = time.Now()
+	bob.connectedAt = time.Now()
+	o := server.addClient(op)
+	b := server.addClient(bob)
+	server.handleCommand(b, "/dnd on")
+
+	server.handleCommand(b, "/debug")
+	if !containsSubstring(bobOutput(), "ERR_PERMISSION_DENIED") {
+		t.Errorf("Expected /debug to be for operators, got %q", bobOutput())
+	}
+
+	server.handleCommand(o, "/oper secret")
+	server.handleCommand(o, "/debug")
+	out := opOutput()
+	for _, want := range []string{"goroutines: ", "clients: 2", "Op from 192.168.1.1, joined 0s ago, connected operator", "Bob from 192.168.1.2", "connected dnd"} {
+		if !containsSubstring(out, want) {
+			t.Errorf("Expected %q in the dump, got %q", want, out)
+		}
+	}
+
+	server.handleCommand(o, "/debug file")
+	files, _ := filepath.Glob(filepath.Join(cfg.ExportDir, "debug-*.txt"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one dump file, got %v", files)
+	}
+	if !containsSubstring(opOutput(), "debug dump written to "+files[0]) {
+		t.Errorf("Expected to be told where the dump went, got %q", opOutput())
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), "goroutine profile:") || !strings.Contains(string(data), "Bob from") {
+		t.Errorf("Expected the file to hold the dump and stacks, got %q", data)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A flapping client or a looping bot can make the server say the same
+// thing over and over. With Config.DedupeWindow set, a system notice that
+// repeats the one before it within the window is held back, and once the
+// window is over the repeats are sent, and stored in the history, as one
+// "(repeated 3 more times)" line.
+
+// dedupe tracks the last system notice and how often it has repeated.
+type dedupe struct {
+	mu    sync.Mutex
+	last  noticeRun
+	first time.Time
+	// gen tells a flush timer whether the run it was set for is still the
+	// current one.
+	gen uint64
+}
+
+// noticeRun is a notice and how many times it was repeated.
+type noticeRun struct {
+	sender  Client
+	text    string
+	repeats int
+}
+
+// add records a notice about to be sent at now, reporting whether it
+// repeats the last one within window and so must be held back. If it ends
+// a run with repeats still held, that run is returned to be sent first.
+// flush is called when a run's window is over.
+func (d *dedupe) add(sender Client, text string, now time.Time, window time.Duration, flush func(gen uint64)) (repeated bool, ended noticeRun) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if text == d.last.text && now.Sub(d.first) < window {
+		if d.last.repeats == 0 {
+			gen := d.gen
+			time.AfterFunc(d.first.Add(window).Sub(now), func() { flush(gen) })
+		}
+		d.last.repeats++
+		return true, noticeRun{}
+	}
+	ended = d.last
+	d.gen++
+	d.last, d.first = noticeRun{sender: sender, text: text}, now
+	return false, ended
+}
+
+// take returns the repeats held for the run gen, if it is still the
+// current one, and starts counting again.
+func (d *dedupe) take(gen uint64) noticeRun {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if gen != d.gen {
+		return noticeRun{}
+	}
+	run := d.last
+	d.last.repeats = 0
+	d.last.text = ""
+	d.gen++
+	return run
+}
+
+// broadcastNotice sends a system notice from sender to everyone and stores
+// it, unless it repeats the one before.
+func (s *Server) broadcastNotice(sender Client, text string) {
+	if window := time.Duration(s.config.DedupeWindow); window > 0 {
+		repeated, ended := s.dedupe.add(sender, text, time.Now(), window, s.flushRepeats)
+		s.sendRepeats(ended)
+		if repeated {
+			return
+		}
+	}
+	tf := "[" + time.Now().Format("02-01-2006 15:04:05") + "]"
+	s.messageClients(sender, "\n"+text, tf)
+}
+
+// flushRepeats sends the repeats held for the run gen once its window is
+// over.
+func (s *Server) flushRepeats(gen uint64) {
+	s.sendRepeats(s.dedupe.take(gen))
+}
+
+// sendRepeats sends one line standing for the repeats of run, if it had
+// any.
+func (s *Server) sendRepeats(run noticeRun) {
+	if run.repeats == 0 {
+		return
+	}
+	times := "once more"
+	if run.repeats > 1 {
+		times = fmt.Sprintf("%d more times", run.repeats)
+	}
+	tf := "[" + time.Now().Format("02-01-2006 15:04:05") + "]"
+	s.messageClients(run.sender, "\n"+s.mustText("repeated", map[string]string{"Text": run.text, "Times": times}), tf)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that a notice repeated within the window is sent and stored once,
+// followed by one line counting the repeats
+func TestDedupeNotices(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DedupeWindow = Duration(50 * time.Millisecond)
+	server := newTestServer(t, cfg)
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	server.addClient(alice)
+
+	for range 4 {
+		server.announce("the printer is on fire", nil)
+	}
+	if got := strings.Count(output(), "the printer is on fire"); got != 1 {
+		t.Errorf("Expected the notice once before the window is over, got %d in %q", got, output())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if !containsSubstring(output(), "the printer is on fire (repeated 3 more times)") {
+		t.Errorf("Expected the repeats to be counted, got %q", output())
+	}
+	if n := len(server.history.Messages()); n != 2 {
+		t.Errorf("Expected two lines in the history, got %d", n)
+	}
+
+	server.announce("one", nil)
+	server.announce("one", nil)
+	server.announce("two", nil)
+	if !containsSubstring(output(), "one (repeated once more)") || strings.Index(output(), "(repeated once more)") > strings.Index(output(), "two") {
+		t.Errorf("Expected the repeats of one before two, got %q", output())
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// sendDirect delivers text from sender to each named user and nobody else.
+// Several recipients make an ad-hoc group: everyone sees who else is in
+// it. Direct messages are never stored in the history or the log file. It
+// returns the names that are not connected.
+func (s *Server) sendDirect(sender *Client, names []string, text string) []string {
+	var targets []*Client
+	var missing []string
+	for _, name := range names {
+		c := s.findClient(name)
+		switch {
+		case c == nil:
+			missing = append(missing, name)
+		case c != sender && !slices.Contains(targets, c):
+			targets = append(targets, c)
+		}
+	}
+	if len(targets) == 0 {
+		return missing
+	}
+
+	tf := "[" + time.Now().Format("02-01-2006 15:04:05") + "]"
+	for _, c := range targets {
+		header := "[DM from " + sender.name.get() + "]"
+		others := otherNames(targets, c)
+		if len(others) > 0 {
+			header = "[DM from " + sender.name.get() + " to you, " + strings.Join(others, ", ") + "]"
+		}
+
+		s.mu.Lock()
+		blocked := s.ignoring(c.name.get(), sender.name.get())
+		if !blocked {
+			c.replyTo = append([]string{sender.name.get()}, others...)
+			addPeers(sender, c)
+		}
+		s.mu.Unlock()
+
+		// Blocked messages are dropped silently so the sender cannot tell.
+		if blocked {
+			continue
+		}
+		if s.holdDirect(c, tf+header+":"+text) {
+			s.reply(sender, s.dndReply(c))
+			continue
+		}
+		if s.holdPreview(c, tf+header+":"+text) {
+			s.deliver(c, "\n"+tf+header+": new message, /notify read to see it", tf, priorityChat)
+			continue
+		}
+		s.deliver(c, "\n"+tf+header+":"+text, tf, priorityChat)
+	}
+
+	var to []string
+	conns := []string{sender.connID}
+	for _, c := range targets {
+		to = append(to, c.name.get())
+		conns = append(conns, c.connID)
+	}
+	s.reply(sender, tf+"[DM to "+strings.Join(to, ", ")+"]:"+text)
+
+	if s.config.DMRetention > 0 {
+		s.mu.Lock()
+		s.directs = append(s.directs, Message{name: sender.name.get(), to: to, conns: conns, payload: []byte(text), sent: time.Now()})
+		s.mu.Unlock()
+	}
+	return missing
+}
+
+// directsFor returns the stored direct messages client sent or received.
+// They are matched by connection rather than name, as names are not
+// registered: whoever takes a name after its holder leaves must not read
+// their messages.
+func (s *Server) directsFor(client *Client) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var msgs []Message
+	for _, m := range s.directs {
+		if slices.Contains(m.conns, client.connID) {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// replyTargets returns who /r from client should reach.
+func (s *Server) replyTargets(client *Client) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return client.replyTo
+}
+
+// otherNames lists the names of group members other than c.
+func otherNames(group []*Client, c *Client) []string {
+	var names []string
+	for _, member := range group {
+		if member != c {
+			names = append(names, member.name.get())
+		}
+	}
+	return names
+}
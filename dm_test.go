@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that a group DM reaches only its participants and is not stored
+func TestGroupDirectMessage(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	carol, carolOutput := pipeClient(t, "Carol", "192.168.1.3")
+	dave, daveOutput := pipeClient(t, "Dave", "192.168.1.4")
+	a := server.addClient(alice)
+	server.addClient(bob)
+	server.addClient(carol)
+	server.addClient(dave)
+
+	missing := server.sendDirect(a, []string{"Bob", "Carol", "Eve"}, "lunch?")
+
+	if len(missing) != 1 || missing[0] != "Eve" {
+		t.Errorf("Expected Eve to be reported missing, got %v", missing)
+	}
+
+	if !containsSubstring(bobOutput(), "[DM from Alice to you, Carol]:lunch?") {
+		t.Errorf("Unexpected output for Bob: %q", bobOutput())
+	}
+
+	if !containsSubstring(carolOutput(), "[DM from Alice to you, Bob]:lunch?") {
+		t.Errorf("Unexpected output for Carol: %q", carolOutput())
+	}
+
+	if !containsSubstring(aliceOutput(), "[DM to Bob, Carol]:lunch?") {
+		t.Errorf("Expected Alice to see the DM echoed back, got %q", aliceOutput())
+	}
+
+	if daveOutput() != "" {
+		t.Errorf("Expected Dave to see nothing, got %q", daveOutput())
+	}
+
+	if len(server.history.Messages()) != 0 {
+		t.Errorf("Expected DMs to stay out of the history.")
+	}
+}
+
+// Test that /r answers the sender and the rest of the group
+func TestReplyToLastDirectMessage(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	bob, _ := pipeClient(t, "Bob", "192.168.1.2")
+	carol, carolOutput := pipeClient(t, "Carol", "192.168.1.3")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+	server.addClient(carol)
+
+	server.handleCommand(b, "/r anyone?")
+	server.sendDirect(a, []string{"Bob", "Carol"}, "lunch?")
+	server.handleCommand(b, "/r sure")
+
+	if !containsSubstring(aliceOutput(), "[DM from Bob to you, Carol]:sure") {
+		t.Errorf("Expected Alice to get the reply, got %q", aliceOutput())
+	}
+
+	if !containsSubstring(carolOutput(), "[DM from Bob to you, Alice]:sure") {
+		t.Errorf("Expected Carol to get the reply, got %q", carolOutput())
+	}
+}
+
+// Test that stored DMs are only visible to participants, and not to
+// whoever takes a participant's name after they leave
+func TestDirectMessageRetention(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DMRetention = Duration(time.Hour)
+	server := newTestServer(t, cfg)
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, _ := pipeClient(t, "Bob", "192.168.1.2")
+	carol, _ := pipeClient(t, "Carol", "192.168.1.3")
+	alice.connID, bob.connID, carol.connID = "a1", "b1", "c1"
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+	c := server.addClient(carol)
+
+	server.sendDirect(a, []string{"Bob"}, "secret")
+
+	if len(server.directsFor(b)) != 1 || len(server.directsFor(a)) != 1 {
+		t.Errorf("Expected both participants to see the stored DM.")
+	}
+
+	if len(server.directsFor(c)) != 0 {
+		t.Errorf("Expected other users not to see the DM.")
+	}
+
+	if containsSubstring(server.replayHistory(), "secret") {
+		t.Errorf("Expected the DM to stay out of the public history.")
+	}
+
+	server.removeClient(*b)
+	impostor, output := pipeClient(t, "Bob", "192.168.1.4")
+	impostor.connID = "b2"
+	i := server.addClient(impostor)
+	server.handleCommand(i, "/dms")
+	if out := output(); containsSubstring(out, "secret") || !containsSubstring(out, "no stored direct messages") {
+		t.Errorf("Expected a new Bob not to read the old Bob's DMs, got %q", out)
+	}
+
+	server.pruneDirects(time.Now().Add(time.Minute))
+	if len(server.directsFor(a)) != 0 {
+		t.Errorf("Expected expired DMs to be pruned.")
+	}
+}
+
+// Test that do-not-disturb silences mentions and holds direct messages
+func TestDoNotDisturb(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+
+	server.messageClients(*a, "\n[01-01-2025 10:00:00][Alice]:hi @bob", "")
+	if !containsSubstring(bobOutput(), bell+"\n[01-01-2025 10:00:00][Alice]:hi @bob") {
+		t.Errorf("Expected the mention to ring Bob's bell, got %q", bobOutput())
+	}
+
+	server.handleCommand(b, "/dnd on in a meeting")
+	server.messageClients(*a, "\n[01-01-2025 10:01:00][Alice]:still there @Bob?", "")
+	if containsSubstring(bobOutput(), bell+"\n[01-01-2025 10:01:00]") || !containsSubstring(bobOutput(), "still there @Bob?") {
+		t.Errorf("Expected the message without a bell, got %q", bobOutput())
+	}
+
+	server.sendDirect(a, []string{"Bob"}, "call me")
+	if containsSubstring(bobOutput(), "call me") {
+		t.Errorf("Expected the direct message to be held.")
+	}
+	if !containsSubstring(aliceOutput(), "Bob does not want to be disturbed (in a meeting)") {
+		t.Errorf("Expected Alice to get an automatic reply, got %q", aliceOutput())
+	}
+
+	server.handleCommand(a, "/whois Bob")
+	if !containsSubstring(aliceOutput(), "do not disturb (in a meeting)") {
+		t.Errorf("Expected /whois to show do-not-disturb, got %q", aliceOutput())
+	}
+
+	server.handleCommand(b, "/dnd off")
+	if !containsSubstring(bobOutput(), "[DM from Alice]:call me") {
+		t.Errorf("Expected the held message once back, got %q", bobOutput())
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := map[string]bool{
+		"hi @Bob":        true,
+		"@bob, look":     true,
+		"hi @Bobby":      false,
+		"hi @Bobby @bob": true,
+		"hi Bob":         false,
+	}
+	for message, want := range tests {
+		if got := mentions(message, "Bob"); got != want {
+			t.Errorf("mentions(%q) = %v, want %v", message, got, want)
+		}
+	}
+}
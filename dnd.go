@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bell rings the terminal of a client mentioned as "@name" in a public
+// message.
+const bell = "\a"
+
+// mentions reports whether message mentions name as "@name", ignoring
+// case.
+func mentions(message, name string) bool {
+	lower, target := strings.ToLower(message), "@"+strings.ToLower(name)
+	for i := strings.Index(lower, target); i >= 0; {
+		end := i + len(target)
+		r, _ := utf8.DecodeRuneInString(lower[end:])
+		if end == len(lower) || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			return true
+		}
+		next := strings.Index(lower[end:], target)
+		if next < 0 {
+			break
+		}
+		i = end + next
+	}
+	return false
+}
+
+// holdDirect keeps a direct message for c if it is in do-not-disturb
+// mode, and reports whether it did.
+func (s *Server) holdDirect(c *Client, message string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !c.dnd {
+		return false
+	}
+	c.held = append(c.held, message)
+	return true
+}
+
+// setDND turns do-not-disturb mode on for client with an optional away
+// message, or off, in which case the direct messages held meanwhile are
+// returned.
+func (s *Server) setDND(client *Client, on bool, away string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client.dnd, client.away = on, away
+	if on {
+		return nil
+	}
+	held := client.held
+	client.held = nil
+	return held
+}
+
+// dndReply is the automatic reply to a direct message sent to c while it
+// is in do-not-disturb mode.
+func (s *Server) dndReply(c *Client) string {
+	s.mu.Lock()
+	away := c.away
+	s.mu.Unlock()
+
+	reply := c.name.get() + " does not want to be disturbed"
+	if away != "" {
+		reply += " (" + away + ")"
+	}
+	return reply + "; they will see your message when they are back"
+}
+
+func cmdDND(s *Server, client *Client, args []string) {
+	switch {
+	case len(args) == 0:
+		s.mu.Lock()
+		on, away := client.dnd, client.away
+		s.mu.Unlock()
+		if !on {
+			s.reply(client, "do not disturb is off")
+		} else if away != "" {
+			s.reply(client, "do not disturb is on: "+away)
+		} else {
+			s.reply(client, "do not disturb is on")
+		}
+
+	case args[0] == "on":
+		s.setDND(client, true, strings.Join(args[1:], " "))
+		s.reply(client, "do not disturb on: mentions will not ring and direct messages are held until you turn it off")
+
+	case args[0] == "off" && len(args) == 1:
+		held := s.setDND(client, false, "")
+		s.reply(client, "do not disturb off")
+		for _, message := range held {
+			s.reply(client, message)
+		}
+
+	default:
+		s.replyUsage(client, "/dnd")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// DryRun checks the server could start without starting it: that the
+// listen address can be bound, the log file written and the GeoIP
+// database, if any, opened. The config itself is checked by LoadConfig.
+// Each check is reported to w, and the first failure is returned.
+func (s *Server) DryRun(w io.Writer) error {
+	if s.config.GeoIPDatabase != "" {
+		if _, err := openGeoDB(s.config.GeoIPDatabase); err != nil {
+			return fmt.Errorf("geoip database: %w", err)
+		}
+		fmt.Fprintf(w, "geoip database %s: ok\n", s.config.GeoIPDatabase)
+	}
+
+	ln, err := s.listen(s.listenAddr)
+	if err != nil {
+		return err
+	}
+	addr := ln.Addr()
+	if err := ln.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "listen %s: ok\n", addr)
+
+	if s.logPath != "" {
+		if err := checkWritable(s.logPath); err != nil {
+			return fmt.Errorf("log file: %w", err)
+		}
+		fmt.Fprintf(w, "log file %s: ok\n", s.logPath)
+	}
+	return nil
+}
+
+// checkWritable reports whether path can be appended to, without leaving a
+// new file behind if there was none.
+func checkWritable(path string) error {
+	_, err := os.Stat(path)
+	existed := !errors.Is(err, fs.ErrNotExist)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o666)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if !existed {
+		return os.Remove(path)
+	}
+	return nil
+}
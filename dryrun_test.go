@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test that a dry run binds and releases the address, leaves no log file
+// behind and reports what would stop the server starting
+func TestDryRun(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LogFile = filepath.Join(t.TempDir(), "server_log.txt")
+	server := newTestServer(t, cfg)
+	server.listenAddr = "127.0.0.1:0"
+
+	var out strings.Builder
+	if err := server.DryRun(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !containsSubstring(out.String(), "listen 127.0.0.1:") || !containsSubstring(out.String(), "log file "+cfg.LogFile+": ok") {
+		t.Errorf("Unexpected dry run report %q", out.String())
+	}
+	if _, err := os.Stat(cfg.LogFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the dry run not to create the log file, got %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := NewServerWithConfig(ln.Addr().String(), cfg).DryRun(&out); err == nil {
+		t.Errorf("Expected a taken port to fail the dry run.")
+	}
+
+	cfg.LogFile = filepath.Join(t.TempDir(), "missing", "server_log.txt")
+	if err := NewServerWithConfig("127.0.0.1:0", cfg).DryRun(&out); err == nil || !containsSubstring(err.Error(), "log file") {
+		t.Errorf("Expected an unwritable log file to fail the dry run, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// Error codes prefix error replies, as in "ERR_NAME_TAKEN: Name Bob is
+// already taken.", so clients and bots can react to an error without
+// matching its wording. The wording may change; the codes do not.
+const (
+	ErrCodeUnknownCommand   = "ERR_UNKNOWN_COMMAND"
+	ErrCodeUsage            = "ERR_USAGE"
+	ErrCodeInvalidArgument  = "ERR_INVALID_ARGUMENT"
+	ErrCodePermissionDenied = "ERR_PERMISSION_DENIED"
+	ErrCodeBadPassword      = "ERR_BAD_PASSWORD"
+	ErrCodeNoSuchUser       = "ERR_NO_SUCH_USER"
+	ErrCodeNotFound         = "ERR_NOT_FOUND"
+	ErrCodeConflict         = "ERR_CONFLICT"
+	ErrCodeDisabled         = "ERR_DISABLED"
+	ErrCodeNameEmpty        = "ERR_NAME_EMPTY"
+	ErrCodeNameInvalid      = "ERR_NAME_INVALID"
+	ErrCodeNameTaken        = "ERR_NAME_TAKEN"
+	ErrCodeServerFull       = "ERR_SERVER_FULL"
+	ErrCodeRoomFull         = "ERR_ROOM_FULL"
+	ErrCodeSessionInvalid   = "ERR_SESSION_INVALID"
+	ErrCodeBusy             = "ERR_BUSY"
+	ErrCodeRateLimit        = "ERR_RATE_LIMIT"
+	ErrCodeMsgTooLong       = "ERR_MSG_TOO_LONG"
+	ErrCodeTimeout          = "ERR_TIMEOUT"
+	ErrCodeInternal         = "ERR_INTERNAL"
+)
+
+// Sentinel errors for each code. errors.Is reports whether any ClientError
+// has the same code as one of these, whatever its message:
+//
+//	if errors.Is(s.checkName(name), ErrNameTaken) { ... }
+var (
+	ErrUnknownCommand   = &ClientError{Code: ErrCodeUnknownCommand}
+	ErrUsage            = &ClientError{Code: ErrCodeUsage}
+	ErrInvalidArgument  = &ClientError{Code: ErrCodeInvalidArgument}
+	ErrPermissionDenied = &ClientError{Code: ErrCodePermissionDenied}
+	ErrBadPassword      = &ClientError{Code: ErrCodeBadPassword}
+	ErrNoSuchUser       = &ClientError{Code: ErrCodeNoSuchUser}
+	ErrNotFound         = &ClientError{Code: ErrCodeNotFound}
+	ErrConflict         = &ClientError{Code: ErrCodeConflict}
+	ErrDisabled         = &ClientError{Code: ErrCodeDisabled}
+	ErrNameEmpty        = &ClientError{Code: ErrCodeNameEmpty}
+	ErrNameInvalid      = &ClientError{Code: ErrCodeNameInvalid}
+	ErrNameTaken        = &ClientError{Code: ErrCodeNameTaken}
+	ErrServerFull       = &ClientError{Code: ErrCodeServerFull}
+	ErrRoomFull         = &ClientError{Code: ErrCodeRoomFull}
+	ErrSessionInvalid   = &ClientError{Code: ErrCodeSessionInvalid}
+	ErrBusy             = &ClientError{Code: ErrCodeBusy}
+	ErrRateLimit        = &ClientError{Code: ErrCodeRateLimit}
+	ErrMsgTooLong       = &ClientError{Code: ErrCodeMsgTooLong}
+	ErrTimeout          = &ClientError{Code: ErrCodeTimeout}
+	ErrInternal         = &ClientError{Code: ErrCodeInternal}
+)
+
+// captureStacks makes new ClientErrors record where they were created. It
+// is set from Config.Debug, as walking the stack for every refused command
+// is wasted work in production.
+var captureStacks atomic.Bool
+
+// ClientError is an error reported to a client, with one of the ErrCode
+// constants. Cause, if set, is the underlying error; it is never shown to
+// the client but stays reachable through errors.Is and errors.As.
+//
+// Formatting with %+v adds the chain of causes and, in debug mode, the
+// stack where the error was created.
+type ClientError struct {
+	Code    string
+	Message string
+	Cause   error
+
+	stack []uintptr
+}
+
+func newClientError(code, message string) *ClientError {
+	return &ClientError{Code: code, Message: message, stack: callers()}
+}
+
+// wrapClientError is newClientError for a failure caused by cause.
+func wrapClientError(code, message string, cause error) *ClientError {
+	return &ClientError{Code: code, Message: message, Cause: cause, stack: callers()}
+}
+
+// callers returns the stack of newClientError's caller when stacks are
+// being captured.
+func callers() []uintptr {
+	if !captureStacks.Load() {
+		return nil
+	}
+	pcs := make([]uintptr, 32)
+	return pcs[:runtime.Callers(3, pcs)]
+}
+
+func (e *ClientError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func (e *ClientError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *ClientError) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('+'):
+		io.WriteString(f, e.Error())
+		for cause := e.Cause; cause != nil; cause = errors.Unwrap(cause) {
+			io.WriteString(f, "\ncaused by: "+cause.Error())
+		}
+		if len(e.stack) > 0 {
+			io.WriteString(f, "\n"+e.stackTrace())
+		}
+	case verb == 'q':
+		fmt.Fprintf(f, "%q", e.Error())
+	default:
+		io.WriteString(f, e.Error())
+	}
+}
+
+// stackTrace lists the recorded stack, one "function\n\tfile:line" entry
+// per frame as in a panic.
+func (e *ClientError) stackTrace() string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(e.stack)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Is matches any ClientError with the same code, so the sentinels above
+// can be used with errors.Is.
+func (e *ClientError) Is(target error) bool {
+	t, ok := target.(*ClientError)
+	return ok && t.Code == e.Code
+}
+
+// asClientError returns err as a ClientError. Other errors are internal
+// failures, wrapped so the original error is not lost.
+func asClientError(err error) *ClientError {
+	var ce *ClientError
+	if errors.As(err, &ce) {
+		return ce
+	}
+	return wrapClientError(ErrCodeInternal, err.Error(), err)
+}
+
+// replyError sends err to client alone, prefixed with its code. Internal
+// failures are also logged in full, since the client's copy leaves out the
+// causes.
+func (s *Server) replyError(client *Client, err error) {
+	ce := asClientError(err)
+	if ce.Code == ErrCodeInternal {
+		fmt.Printf("%s Error handling command from %s: %+v\n", client.connID, client.name.get(), ce)
+	}
+	s.reply(client, s.errorText(ce))
+}
+
+// replyUsage tells client how to use the command name.
+func (s *Server) replyUsage(client *Client, name string) {
+	cmd, _ := s.lookupCommand(name)
+	s.replyError(client, newClientError(ErrCodeUsage, "usage: "+cmd.usage))
+}
+
+// replyJSONError sends err to client as a JSON object with "code" and
+// "error" fields, for commands whose replies are JSON.
+func (s *Server) replyJSONError(client *Client, err error) {
+	ce := asClientError(err)
+	data, _ := json.Marshal(struct {
+		Code  string `json:"code"`
+		Error string `json:"error"`
+	}{ce.Code, ce.Message})
+	s.reply(client, string(data))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Lifecycle event types emitted for every connection.
+const (
+	EventConnect     = "connect"
+	EventAuthSuccess = "auth_success"
+	EventAuthFailure = "auth_failure"
+	EventJoin        = "join"
+	EventLeave       = "leave"
+	EventTimeout     = "timeout"
+	EventSendFailure = "send_failure"
+)
+
+// Event is a machine-readable record of something that happened to a
+// connection, written as one JSON object per line.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"event"`
+	Conn     string    `json:"conn,omitempty"`
+	Addr     string    `json:"addr"`
+	Name     string    `json:"name,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	Country  string    `json:"country,omitempty"`
+	Duration string    `json:"duration,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	// Code is the error code a refused connection was given, such as
+	// "ERR_SERVER_FULL".
+	Code string `json:"code,omitempty"`
+}
+
+// emitEvent counts ev in the metrics and writes it to the server's event
+// log. Events are best effort: a failure to encode or write one never
+// affects the connection.
+func (s *Server) emitEvent(ev Event) {
+	s.countEvent(ev)
+	if s.eventLog == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ev.Addr = s.redactAddr(ev.Addr)
+	if s.config.RedactIPs {
+		ev.Host = ""
+	}
+
+	line, err := json.Marshal(ev)
+	if err != nil {
+		fmt.Println("Error encoding event:", err)
+		return
+	}
+	s.eventLog.Write(append(line, '\n'))
+}
+
+// redactAddr hashes the host part of addr when Config.RedactIPs is set.
+// The hash is keyed with a secret chosen at startup, so it cannot be
+// reversed by hashing every IPv4 address, but it changes on restart.
+func (s *Server) redactAddr(addr string) string {
+	if !s.config.RedactIPs || addr == "" {
+		return addr
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+
+	mac := hmac.New(sha256.New, s.redactKey)
+	mac.Write([]byte(host))
+	redacted := "ip-" + hex.EncodeToString(mac.Sum(nil))[:12]
+	if port == "" {
+		return redacted
+	}
+	return net.JoinHostPort(redacted, port)
+}
+
+// newConnID returns a short random ID for a new connection.
+func newConnID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// clientEvent builds an event for client, including how long it has been
+// connected.
+func clientEvent(kind string, client Client, reason string) Event {
+	ev := Event{Type: kind, Conn: client.connID, Addr: client.ipAdd, Name: client.name.get(), Host: client.host, Country: client.country, Reason: reason}
+	if !client.connectedAt.IsZero() {
+		ev.Duration = time.Since(client.connectedAt).Round(time.Millisecond).String()
+	}
+	return ev
+}
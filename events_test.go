@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that lifecycle events are written as JSON lines with a duration
+func TestEmitEvent(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	var buf bytes.Buffer
+	server.eventLog = &buf
+
+	client := mockClient("Alice", "192.168.1.1", nil)
+	client.connectedAt = time.Now().Add(-time.Minute)
+	server.emitEvent(clientEvent(EventLeave, client, "EOF"))
+
+	var ev Event
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatalf("Expected a JSON event, got %q: %v", buf.String(), err)
+	}
+
+	if ev.Type != EventLeave || ev.Name != "Alice" || ev.Addr != "192.168.1.1" {
+		t.Errorf("Unexpected event: %+v", ev)
+	}
+
+	if ev.Duration == "" {
+		t.Errorf("Expected leave event to carry a duration.")
+	}
+}
+
+// Test that addresses are hashed in events when RedactIPs is set
+func TestEmitEventRedactsIPs(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RedactIPs = true
+	server := newTestServer(t, cfg)
+	var buf bytes.Buffer
+	server.eventLog = &buf
+
+	server.emitEvent(Event{Type: EventConnect, Addr: "192.168.1.1:50000"})
+
+	if containsSubstring(buf.String(), "192.168.1.1") {
+		t.Errorf("Expected address to be redacted, got %q", buf.String())
+	}
+
+	if server.redactAddr("192.168.1.1:1") != server.redactAddr("192.168.1.1:1") {
+		t.Errorf("Expected the same address to redact to the same value.")
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to write from several goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Test that every event about one connection carries the same ID, and
+// other connections get different ones
+func TestEventConnID(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	var log lockedBuffer
+	server.eventLog = &log
+
+	session := func(name string) {
+		conn, peer := net.Pipe()
+		go server.handleConn(conn)
+		reader := bufio.NewReader(peer)
+		readUntil(reader, namePrompt)
+		peer.Write([]byte(name + "\n"))
+		readUntil(reader, "]["+name+"]:")
+		peer.Close()
+	}
+	session("Alice")
+	session("Bob")
+	time.Sleep(20 * time.Millisecond)
+
+	ids := map[string]map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(log.String()), "\n") {
+		var ev Event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Conn == "" {
+			t.Errorf("Expected a connection ID in %s", line)
+		}
+		if ids[ev.Conn] == nil {
+			ids[ev.Conn] = map[string]bool{}
+		}
+		ids[ev.Conn][ev.Type] = true
+	}
+	if len(ids) != 2 {
+		t.Fatalf("Expected two connection IDs, got %v", ids)
+	}
+	for id, types := range ids {
+		for _, want := range []string{EventConnect, EventAuthSuccess, EventJoin, EventLeave} {
+			if !types[want] {
+				t.Errorf("Expected a %s event for connection %s, got %v", want, id, types)
+			}
+		}
+	}
+}
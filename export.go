@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportedMessage is the JSON form of a history entry.
+type exportedMessage struct {
+	ID   uint64    `json:"id,omitempty"`
+	Time time.Time `json:"time"`
+	Name string    `json:"name"`
+	Text string    `json:"text"`
+}
+
+// ExportHistory writes the stored messages sent between from and to to w as
+// "json", "text" or "html". A zero from or to leaves that end unbounded.
+func (s *Server) ExportHistory(w io.Writer, from, to time.Time, format string) error {
+	var msgs []exportedMessage
+	for _, m := range s.history.Messages() {
+		if (!from.IsZero() && m.sent.Before(from)) || (!to.IsZero() && m.sent.After(to)) {
+			continue
+		}
+		msgs = append(msgs, exportedMessage{Time: m.sent, Name: m.name, Text: strings.TrimPrefix(string(m.payload), "\n")})
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if msgs == nil {
+			msgs = []exportedMessage{}
+		}
+		return enc.Encode(msgs)
+	case "text":
+		for _, m := range msgs {
+			if _, err := fmt.Fprintln(w, m.Text); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "html":
+		fmt.Fprintln(w, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>TCP-Chat transcript</title></head><body><pre>")
+		for _, m := range msgs {
+			fmt.Fprintln(w, html.EscapeString(m.Text))
+		}
+		_, err := fmt.Fprintln(w, "</pre></body></html>")
+		return err
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// exportToFile writes an export to name inside Config.ExportDir and returns
+// the path it used. Only the base name is kept so an export can never be
+// written elsewhere on the host.
+func (s *Server) exportToFile(name string, from, to time.Time, format string) (string, error) {
+	if err := os.MkdirAll(s.config.ExportDir, 0o755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.config.ExportDir, filepath.Base(name))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := s.ExportHistory(f, from, to, format); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Test exporting a time range of the history in each format
+func TestExportHistory(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	start := time.Now()
+	server.history.Load([]Message{
+		{name: "Alice", payload: []byte("\n[old]"), sent: start.Add(-time.Hour)},
+		{name: "Bob", payload: []byte("\n[01-01-2025 10:00:05][Bob]:<b>hi</b>"), sent: start},
+	})
+
+	var buf bytes.Buffer
+	if err := server.ExportHistory(&buf, start.Add(-time.Minute), time.Time{}, "json"); err != nil {
+		t.Fatalf("json export failed: %v", err)
+	}
+
+	var msgs []exportedMessage
+	if err := json.Unmarshal(buf.Bytes(), &msgs); err != nil {
+		t.Fatalf("Expected valid JSON, got %q: %v", buf.String(), err)
+	}
+
+	if len(msgs) != 1 || msgs[0].Name != "Bob" {
+		t.Errorf("Expected only Bob's message in range, got %+v", msgs)
+	}
+
+	buf.Reset()
+	if err := server.ExportHistory(&buf, time.Time{}, time.Time{}, "html"); err != nil {
+		t.Fatalf("html export failed: %v", err)
+	}
+
+	if !containsSubstring(buf.String(), "&lt;b&gt;hi&lt;/b&gt;") {
+		t.Errorf("Expected HTML export to escape messages, got %q", buf.String())
+	}
+
+	if err := server.ExportHistory(&buf, time.Time{}, time.Time{}, "pdf"); err == nil {
+		t.Errorf("Expected an error for an unknown format.")
+	}
+}
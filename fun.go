@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// The fun plugin adds games of chance. Results are announced to everyone so
+// nobody can quietly reroll.
+func init() {
+	registerPlugin("fun", map[string]command{
+		"/roll":  {usage: "/roll [N]dM", help: "roll dice, e.g. /roll 2d6", posts: true, run: cmdRoll},
+		"/flip":  {usage: "/flip", help: "flip a coin", posts: true, run: cmdFlip},
+		"/8ball": {usage: "/8ball <question>", help: "ask the magic 8-ball", posts: true, text: true, run: cmd8Ball},
+	})
+}
+
+var eightBallAnswers = []string{
+	"It is certain.", "Without a doubt.", "You may rely on it.", "Most likely.",
+	"Signs point to yes.", "Reply hazy, try again.", "Ask again later.",
+	"Cannot predict now.", "Don't count on it.", "My sources say no.",
+	"Outlook not so good.", "Very doubtful.",
+}
+
+func cmdRoll(s *Server, client *Client, args []string) {
+	dice := "1d6"
+	if len(args) > 0 {
+		dice = args[0]
+	}
+	count, sides, ok := parseDice(dice)
+	if len(args) > 1 || !ok {
+		s.replyUsage(client, "/roll")
+		return
+	}
+
+	rolls := make([]string, count)
+	total := 0
+	for i := range rolls {
+		n := rand.IntN(sides) + 1
+		total += n
+		rolls[i] = strconv.Itoa(n)
+	}
+	s.announce(fmt.Sprintf("%s rolled %s: %s = %d", client.name.get(), dice, strings.Join(rolls, " + "), total), client)
+}
+
+// parseDice parses "NdM", where N defaults to 1, allowing up to 20 dice of
+// up to 1000 sides.
+func parseDice(dice string) (count, sides int, ok bool) {
+	n, m, found := strings.Cut(strings.ToLower(dice), "d")
+	if !found {
+		return 0, 0, false
+	}
+	count = 1
+	if n != "" {
+		var err error
+		if count, err = strconv.Atoi(n); err != nil {
+			return 0, 0, false
+		}
+	}
+	sides, err := strconv.Atoi(m)
+	if err != nil || count < 1 || count > 20 || sides < 2 || sides > 1000 {
+		return 0, 0, false
+	}
+	return count, sides, true
+}
+
+func cmdFlip(s *Server, client *Client, args []string) {
+	side := "heads"
+	if rand.IntN(2) == 1 {
+		side = "tails"
+	}
+	s.announce(client.name.get()+" flipped a coin: "+side, client)
+}
+
+func cmd8Ball(s *Server, client *Client, args []string) {
+	if len(args) == 0 {
+		s.replyUsage(client, "/8ball")
+		return
+	}
+	answer := eightBallAnswers[rand.IntN(len(eightBallAnswers))]
+	s.announce(client.name.get()+" asked the 8-ball \""+strings.Join(args, " ")+"\": "+answer, client)
+}
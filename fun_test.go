@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that plugin commands only work on servers that enable the plugin
+func TestFunPluginToggle(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	server.addClient(alice)
+	server.addClient(bob)
+
+	server.handleCommand(&alice, "/flip")
+	if !containsSubstring(aliceOutput(), "unknown command /flip") {
+		t.Errorf("Expected /flip to be unknown without the plugin, got %q", aliceOutput())
+	}
+
+	server.config.Plugins = []string{"fun"}
+	server.handleCommand(&alice, "/roll 3d6")
+	if !containsSubstring(bobOutput(), "Alice rolled 3d6: ") {
+		t.Errorf("Expected Bob to see Alice's roll, got %q", bobOutput())
+	}
+
+	server.handleCommand(&alice, "/roll 0d6")
+	if !containsSubstring(aliceOutput(), "usage: /roll") {
+		t.Errorf("Expected a usage message for a bad roll, got %q", aliceOutput())
+	}
+
+	if err := checkPlugins([]string{"fun", "nope"}); err == nil {
+		t.Errorf("Expected an unknown plugin to be rejected")
+	}
+}
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		dice         string
+		count, sides int
+		ok           bool
+	}{
+		{"2d6", 2, 6, true},
+		{"d20", 1, 20, true},
+		{"21d6", 0, 0, false},
+		{"1d1", 0, 0, false},
+		{"six", 0, 0, false},
+	}
+	for _, tt := range tests {
+		count, sides, ok := parseDice(tt.dice)
+		if count != tt.count || sides != tt.sides || ok != tt.ok {
+			t.Errorf("parseDice(%q) = %d, %d, %v", tt.dice, count, sides, ok)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// geoDB is a MaxMind DB file, such as GeoLite2-Country.mmdb, read into
+// memory. Only what is needed to find an address's country is decoded; see
+// https://maxmind.github.io/MaxMind-DB/ for the format.
+type geoDB struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+
+	// ipv4Start is the node reached after the 96 zero bits that prefix
+	// IPv4 addresses in an IPv6 database.
+	ipv4Start uint
+}
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// openGeoDB reads the MaxMind DB file at path.
+func openGeoDB(path string) (*geoDB, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := parseGeoDB(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return db, nil
+}
+
+func parseGeoDB(file []byte) (*geoDB, error) {
+	at := bytes.LastIndex(file, mmdbMetadataMarker)
+	if at < 0 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+	meta, _, err := decodeMMDB(file[at+len(mmdbMetadataMarker):], 0)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %v", err)
+	}
+	fields, ok := meta.(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+	number := func(key string) uint {
+		n, _ := fields[key].(uint64)
+		return uint(n)
+	}
+
+	db := &geoDB{nodeCount: number("node_count"), recordSize: number("record_size"), ipVersion: number("ip_version")}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(at) {
+		return nil, errors.New("search tree is larger than the file")
+	}
+	db.tree = file[:treeSize]
+	db.data = file[treeSize+16 : at]
+
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *geoDB) record(node, bit uint) uint {
+	b := db.tree[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the data stored for ip, or nil if there is none.
+func (db *geoDB) lookup(ip net.IP) (any, error) {
+	node, bits := uint(0), ip.To16()
+	if v4 := ip.To4(); v4 != nil {
+		node, bits = db.ipv4Start, v4
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(bits[i/8]>>(7-i%8)&1))
+	}
+	if node <= db.nodeCount {
+		return nil, nil
+	}
+
+	offset := int(node - db.nodeCount - 16)
+	if offset >= len(db.data) {
+		return nil, errors.New("record points outside the data section")
+	}
+	value, _, err := decodeMMDB(db.data, offset)
+	return value, err
+}
+
+// country returns the ISO 3166 code of the country ip is in, or "" if the
+// database does not know.
+func (db *geoDB) country(ip net.IP) string {
+	value, err := db.lookup(ip)
+	if err != nil {
+		return ""
+	}
+	record, _ := value.(map[string]any)
+	for _, key := range []string{"country", "registered_country"} {
+		if c, ok := record[key].(map[string]any); ok {
+			if code, ok := c["iso_code"].(string); ok {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+// decodeMMDB decodes the value at offset in a MaxMind DB data section and
+// returns it with the offset just past it. Maps decode as map[string]any,
+// arrays as []any and unsigned integers as uint64.
+func decodeMMDB(data []byte, offset int) (any, int, error) {
+	next := func(n int) ([]byte, error) {
+		if n < 0 || offset+n > len(data) {
+			return nil, errors.New("value runs past the end of the data")
+		}
+		b := data[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+	uintOf := func(b []byte) uint64 {
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v
+	}
+
+	ctrl, err := next(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	kind, size := int(ctrl[0]>>5), int(ctrl[0]&0x1f)
+
+	if kind == 1 {
+		n := int(size>>3) + 1
+		b, err := next(n)
+		if err != nil {
+			return nil, 0, err
+		}
+		ptr := uint64(size & 7)
+		if n == 4 {
+			ptr = 0
+		}
+		ptr = ptr<<(8*n) | uintOf(b)
+		ptr += []uint64{0, 2048, 526336, 0}[n-1]
+		value, _, err := decodeMMDB(data, int(ptr))
+		return value, offset, err
+	}
+
+	if kind == 0 {
+		b, err := next(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + int(b[0])
+	}
+	if size >= 29 {
+		n := size - 28
+		b, err := next(n)
+		if err != nil {
+			return nil, 0, err
+		}
+		size = []int{29, 285, 65821}[n-1] + int(uintOf(b))
+	}
+
+	switch kind {
+	case 2:
+		b, err := next(size)
+		return string(b), offset, err
+	case 3:
+		b, err := next(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4:
+		b, err := next(size)
+		return slices.Clone(b), offset, err
+	case 5, 6, 9, 10:
+		b, err := next(size)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(b) > 8 {
+			b = b[len(b)-8:]
+		}
+		return uintOf(b), offset, nil
+	case 8:
+		b, err := next(size)
+		return int32(uintOf(b)), offset, err
+	case 7:
+		m := make(map[string]any, size)
+		for range size {
+			key, end, err := decodeMMDB(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, end, err := decodeMMDB(data, end)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, _ := key.(string)
+			m[k], offset = value, end
+		}
+		return m, offset, nil
+	case 11:
+		a := make([]any, 0, size)
+		for range size {
+			value, end, err := decodeMMDB(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, offset = append(a, value), end
+		}
+		return a, offset, nil
+	case 14:
+		return size != 0, offset, nil
+	case 15:
+		b, err := next(4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
+
+// countryOf returns the country addr is in, when Config.GeoIPDatabase is
+// set.
+func (s *Server) countryOf(addr string) string {
+	if s.geoip == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	return s.geoip.country(ip)
+}
+
+// countryAllowed reports whether clients from country may connect under
+// Config.AllowCountries and DenyCountries. Clients whose country is not
+// known are always allowed.
+func (s *Server) countryAllowed(country string) bool {
+	if country == "" {
+		return true
+	}
+	has := func(list []string) bool {
+		return slices.ContainsFunc(list, func(c string) bool { return strings.EqualFold(c, country) })
+	}
+	if len(s.config.AllowCountries) > 0 && !has(s.config.AllowCountries) {
+		return false
+	}
+	return !has(s.config.DenyCountries)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testGeoDB builds an IPv4 MaxMind DB with record size 24 that places
+// 81.2.69.0/24 in GB and nothing else.
+func testGeoDB(t *testing.T) string {
+	t.Helper()
+
+	const nodes = 24
+	prefix := net.ParseIP("81.2.69.0").To4()
+	var file []byte
+	for i := 0; i < nodes; i++ {
+		next := uint(i + 1)
+		if i == nodes-1 {
+			next = nodes + 16 // the first record in the data section
+		}
+		records := [2]uint{nodes, nodes}
+		records[prefix[i/8]>>(7-i%8)&1] = next
+		for _, r := range records {
+			file = append(file, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	file = append(file, make([]byte, 16)...)
+
+	str := func(s string) []byte { return append([]byte{0x40 | byte(len(s))}, s...) }
+	// {"country": {"iso_code": "GB"}}
+	file = append(file, 0xe1)
+	file = append(file, str("country")...)
+	file = append(file, 0xe1)
+	file = append(file, str("iso_code")...)
+	file = append(file, str("GB")...)
+
+	// {"node_count": 24, "record_size": 24, "ip_version": 4}
+	file = append(file, mmdbMetadataMarker...)
+	file = append(file, 0xe3)
+	file = append(file, str("node_count")...)
+	file = append(file, 0xc1, nodes)
+	file = append(file, str("record_size")...)
+	file = append(file, 0xa1, 24)
+	file = append(file, str("ip_version")...)
+	file = append(file, 0xa1, 4)
+
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Test that addresses are tagged with their country
+func TestGeoDBCountry(t *testing.T) {
+	db, err := openGeoDB(testGeoDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"81.2.69.160": "GB",
+		"81.2.70.1":   "",
+		"10.0.0.1":    "",
+		"::1":         "",
+	}
+	for ip, want := range tests {
+		if got := db.country(net.ParseIP(ip)); got != want {
+			t.Errorf("Expected %s to be in %q, got %q", ip, want, got)
+		}
+	}
+
+	if _, err := parseGeoDB([]byte("not a database")); err == nil {
+		t.Errorf("Expected a file without metadata to be refused.")
+	}
+}
+
+// Test that connections from a denied country are refused
+func TestCountryRules(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.config.DenyCountries = []string{"gb"}
+
+	if server.countryAllowed("GB") || !server.countryAllowed("FR") || !server.countryAllowed("") {
+		t.Errorf("Expected only GB to be denied.")
+	}
+	server.config.AllowCountries = []string{"DE"}
+	if server.countryAllowed("FR") || !server.countryAllowed("DE") || !server.countryAllowed("") {
+		t.Errorf("Expected only DE and unknown countries to be allowed.")
+	}
+
+	db, err := openGeoDB(testGeoDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.geoip = db
+	if country := server.countryOf("81.2.69.1:5000"); country != "GB" {
+		t.Fatalf("Expected the address to be in GB, got %q", country)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// replayHistory returns the stored messages in the order they were sent,
+// ready to be written to a newly joined client.
+func (s *Server) replayHistory() string {
+	return s.replayAfter(0)
+}
+
+// replayAfter is replayHistory limited to messages newer than the one with
+// ID after.
+func (s *Server) replayAfter(after uint64) string {
+	var b strings.Builder
+	for _, m := range s.history.Messages() {
+		if m.id > after {
+			b.Write(m.payload)
+		}
+	}
+	return b.String()
+}
+
+// lastMessages returns up to n of the most recent stored messages.
+func (s *Server) lastMessages(n int) []Message {
+	history := s.history.Messages()
+	return history[max(len(history)-n, 0):]
+}
+
+// messagesSince returns the stored messages sent at or after since.
+func (s *Server) messagesSince(since time.Time) []Message {
+	var msgs []Message
+	for _, m := range s.history.Messages() {
+		if !m.sent.Before(since) {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// HistoryPage is one page of a paginated history query.
+type HistoryPage struct {
+	Messages []exportedMessage `json:"messages"`
+
+	// NextBefore is the cursor for the following, older page, or zero when
+	// there are no older messages.
+	NextBefore uint64 `json:"next_before,omitempty"`
+}
+
+// historyPage returns up to limit messages older than the message with ID
+// before (or the newest when before is zero), skipping the offset newest of
+// those. Messages are returned oldest first.
+func (s *Server) historyPage(before uint64, limit, offset int) HistoryPage {
+	history := s.history.Messages()
+	end := len(history)
+	if before != 0 {
+		end = sort.Search(len(history), func(i int) bool { return history[i].id >= before })
+	}
+	end = max(end-offset, 0)
+	start := max(end-limit, 0)
+
+	page := HistoryPage{Messages: []exportedMessage{}}
+	for _, m := range history[start:end] {
+		page.Messages = append(page.Messages, exportedMessage{ID: m.id, Time: m.sent, Name: m.name, Text: strings.TrimPrefix(string(m.payload), "\n")})
+	}
+	if start > 0 {
+		page.NextBefore = history[start].id
+	}
+	return page
+}
+
+// appendLog adds message to the log file.
+func (s *Server) appendLog(message string) {
+	if !s.logWriters.acquire(s.config.MaxLogWriters) {
+		fmt.Println("Error writing to log file: too many writers waiting, message dropped")
+		return
+	}
+	defer s.logWriters.release()
+
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	// Create or open the log file
+	logFile, err := os.OpenFile(s.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o666)
+	if err != nil {
+		fmt.Println("Error opening log file:", err)
+		return
+	}
+	defer logFile.Close()
+
+	// Create a bufio.Writer
+	writer := bufio.NewWriter(logFile)
+
+	// Write the message to the log file
+	record := []byte(message)
+	if s.logCipher != nil {
+		record = sealRecord(s.logCipher, record)
+	}
+	_, err = writer.Write(record)
+	if err != nil {
+		fmt.Println("Error writing to log file:", err)
+		return
+	}
+
+	// Flush the writer to ensure the data is written to the file immediately
+	err = writer.Flush()
+	if err != nil {
+		fmt.Println("Error flushing writer:", err)
+		return
+	}
+
+	s.logDirty = true
+	if s.config.LogSyncInterval <= 0 {
+		if err := s.syncLog(); err != nil {
+			fmt.Println("Error syncing log file:", err)
+		}
+	}
+}
+
+// Forget removes what name has said since since, or if since is zero
+// everything they said, the notices quoting it such as pins, and their join
+// and leave notices. It removes them from the in-memory history, the room
+// histories, the pins, the snapshot and the log file, and returns how many
+// history entries were dropped. The event log is written to stderr and is
+// left untouched.
+func (s *Server) Forget(name string, since time.Time) (int, error) {
+	mine := func(m Message) bool {
+		if since.IsZero() && quotes(string(m.payload), name) {
+			return true
+		}
+		return m.name == name && !m.sent.Before(since)
+	}
+	removed := s.history.Remove(mine)
+
+	s.mu.Lock()
+	directs := s.directs[:0]
+	for _, m := range s.directs {
+		if !mine(m) {
+			directs = append(directs, m)
+		}
+	}
+	removed += len(s.directs) - len(directs)
+	s.directs = directs
+	for _, r := range s.rooms {
+		removed += r.history.Remove(mine)
+	}
+	pins := len(s.state.Pins)
+	s.state.Pins = slices.DeleteFunc(s.state.Pins, func(p Pin) bool { return s.forgets(p.Text, name, since) })
+	unpinned := len(s.state.Pins) < pins
+	s.mu.Unlock()
+
+	var errs []error
+	if unpinned {
+		errs = append(errs, s.saveState())
+	}
+	if removed > 0 {
+		errs = append(errs, s.saveSnapshot())
+	}
+	errs = append(errs, s.scrubLog(name, since))
+	return removed, errors.Join(errs...)
+}
+
+// forgets reports whether Forget(name, since) drops the log line line.
+// Join and leave notices carry no timestamp, so they are only dropped when
+// forgetting everything.
+func (s *Server) forgets(line, name string, since time.Time) bool {
+	if since.IsZero() {
+		return writtenBy(line, name) || quotes(line, name) || s.isNoticeFor(line, name)
+	}
+	sent, ok := lineTime(line)
+	return writtenBy(line, name) && ok && !sent.Before(since.Truncate(time.Second))
+}
+
+// scrubLog rewrites the log file without the lines Forget(name, since)
+// drops.
+func (s *Server) scrubLog(name string, since time.Time) error {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	data, err := s.readLog()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !s.forgets(line, name, since) {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == len(lines) {
+		return nil
+	}
+
+	return s.rewriteLog([]byte(strings.Join(kept, "\n")))
+}
+
+// readLog returns the plaintext contents of the log file. The caller must
+// hold logMu.
+func (s *Server) readLog() ([]byte, error) {
+	data, err := os.ReadFile(s.logPath)
+	if err != nil || s.logCipher == nil {
+		return data, err
+	}
+	return openRecords(s.logCipher, data)
+}
+
+// rewriteLog replaces the log file with content, encrypting it if needed.
+// The caller must hold logMu.
+func (s *Server) rewriteLog(content []byte) error {
+	if s.logCipher != nil && len(content) > 0 {
+		content = sealRecord(s.logCipher, content)
+	}
+
+	// Write to a temporary file first so a failed rewrite never truncates
+	// the log.
+	tmp := s.logPath + ".tmp"
+	if err := writeSynced(tmp, content); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.logPath); err != nil {
+		return err
+	}
+	return s.syncLog()
+}
+
+// quotes reports whether text contains a message from name, as a pin
+// notice does.
+func quotes(text, name string) bool {
+	return strings.Contains(text, "]["+name+"]:")
+}
+
+// writtenBy reports whether a log line is a message from name.
+func writtenBy(line, name string) bool {
+	end := strings.Index(line, "]")
+	return strings.HasPrefix(line, "[") && end >= 0 && strings.HasPrefix(line[end+1:], "["+name+"]:")
+}
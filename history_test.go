@@ -0,0 +1,213 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that Forget drops a user's messages from history and the log file
+func TestForget(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice := mockClient("Alice", "192.168.1.1", nil)
+	bob := mockClient("Bob", "192.168.1.2", nil)
+	server.messageClients(alice, "\nAlice has joined our chat... (1 user online)", "")
+	server.messageClients(alice, "\n[01-01-2025 10:00:00][Alice]:hello", "")
+	server.messageClients(bob, "\n[01-01-2025 10:00:05][Bob]:hi Alice", "")
+
+	removed, err := server.Forget("Alice", time.Time{})
+	if err != nil {
+		t.Fatalf("Forget returned an error: %v", err)
+	}
+
+	if removed != 2 {
+		t.Errorf("Expected 2 messages removed, got %d", removed)
+	}
+
+	if got := server.replayHistory(); got != "\n[01-01-2025 10:00:05][Bob]:hi Alice" {
+		t.Errorf("Unexpected history after Forget: %q", got)
+	}
+
+	data, err := os.ReadFile(server.logPath)
+	if err != nil {
+		t.Fatalf("Reading log file: %v", err)
+	}
+
+	if string(data) != "\n[01-01-2025 10:00:05][Bob]:hi Alice" {
+		t.Errorf("Unexpected log file after Forget: %q", data)
+	}
+}
+
+// Test that /forgetme only removes what was said since connecting
+func TestForgetMe(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+	server.messageClients(*a, "\n[01-01-2025 10:00:00][Alice]:said by someone else as Alice", "")
+	a.connectedAt = time.Now()
+	server.messageClients(*a, "\n["+time.Now().Format("02-01-2006 15:04:05")+"][Alice]:said just now", "")
+
+	server.handleCommand(a, "/forgetme")
+	if !containsSubstring(output(), "removed 1 messages") {
+		t.Errorf("Expected one message removed, got %q", output())
+	}
+	if history := server.replayHistory(); !containsSubstring(history, "someone else") || containsSubstring(history, "just now") {
+		t.Errorf("Expected only the new message to be forgotten, got %q", history)
+	}
+	data, _ := os.ReadFile(server.logPath)
+	if !containsSubstring(string(data), "someone else") || containsSubstring(string(data), "just now") {
+		t.Errorf("Expected only the new line to be scrubbed, got %q", data)
+	}
+}
+
+// Test that /forget is for operators and also clears pins, rooms and the
+// snapshot
+func TestForgetName(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LogFile = filepath.Join(t.TempDir(), "server_log.txt")
+	cfg.SnapshotFile = filepath.Join(t.TempDir(), "snapshot.json")
+	cfg.OperatorPassword = "secret"
+	server := newTestServer(t, cfg)
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+	server.messageClients(*a, "\n[01-01-2025 10:00:00][Alice]:pin me", "")
+	server.handleCommand(b, "/forget Alice")
+	if !containsSubstring(bobOutput(), "ERR_PERMISSION_DENIED") {
+		t.Errorf("Expected /forget to need an operator, got %q", bobOutput())
+	}
+
+	server.handleCommand(b, "/oper secret")
+	server.handleCommand(b, "/pin last")
+	server.handleCommand(a, "/join #side")
+	server.messageClients(*a, "\n[01-01-2025 10:00:05][Alice]:in the room", "")
+	server.handleCommand(b, "/forget Alice")
+
+	if history := server.replayHistory(); containsSubstring(history, "[Alice]:") {
+		t.Errorf("Expected Alice's messages to be forgotten, got %q", history)
+	}
+	if pins := server.pins(); len(pins) != 0 {
+		t.Errorf("Expected Alice's pin to be removed, got %v", pins)
+	}
+	server.mu.Lock()
+	room := server.rooms["#side"].history
+	server.mu.Unlock()
+	for _, m := range room.Messages() {
+		if m.name == "Alice" {
+			t.Errorf("Expected the room history to be forgotten, got %q", m.payload)
+		}
+	}
+	data, err := os.ReadFile(cfg.SnapshotFile)
+	if err != nil || containsSubstring(string(data), "pin me") {
+		t.Errorf("Expected the snapshot to be rewritten, got %q", data)
+	}
+}
+
+// Test that ephemeral clients are broadcast but not stored
+func TestEphemeralNotStored(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice := mockClient("Alice", "192.168.1.1", nil)
+	alice.ephemeral = true
+	server.messageClients(alice, "\n[01-01-2025 10:00:00][Alice]:off the record", "")
+
+	if n := len(server.history.Messages()); n != 0 {
+		t.Errorf("Expected no stored history, got %d messages", n)
+	}
+
+	if _, err := os.Stat(server.logPath); !os.IsNotExist(err) {
+		t.Errorf("Expected log file not to be written.")
+	}
+}
+
+// Test that an encrypted log is unreadable on disk but can still be scrubbed
+func TestEncryptedLog(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LogFile = filepath.Join(t.TempDir(), "server_log.txt")
+	cfg.LogKey = "secret"
+	server := newTestServer(t, cfg)
+
+	alice := mockClient("Alice", "192.168.1.1", nil)
+	bob := mockClient("Bob", "192.168.1.2", nil)
+	server.messageClients(alice, "\n[01-01-2025 10:00:00][Alice]:hello", "")
+	server.messageClients(bob, "\n[01-01-2025 10:00:05][Bob]:hi Alice", "")
+
+	data, err := os.ReadFile(cfg.LogFile)
+	if err != nil {
+		t.Fatalf("Reading log file: %v", err)
+	}
+
+	if containsSubstring(string(data), "hello") {
+		t.Errorf("Expected log file to be encrypted, got %q", data)
+	}
+
+	if _, err := server.Forget("Alice", time.Time{}); err != nil {
+		t.Fatalf("Forget returned an error: %v", err)
+	}
+
+	plain, err := server.readLog()
+	if err != nil {
+		t.Fatalf("Decrypting log file: %v", err)
+	}
+
+	if string(plain) != "\n[01-01-2025 10:00:05][Bob]:hi Alice" {
+		t.Errorf("Unexpected decrypted log: %q", plain)
+	}
+}
+
+// Test selecting recent history by count and by time
+func TestRecentHistory(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	now := time.Now()
+	server.history.Load([]Message{
+		{name: "Alice", payload: []byte("\none"), sent: now.Add(-time.Hour)},
+		{name: "Bob", payload: []byte("\ntwo"), sent: now.Add(-time.Minute)},
+		{name: "Alice", payload: []byte("\nthree"), sent: now},
+	})
+
+	if msgs := server.lastMessages(2); len(msgs) != 2 || string(msgs[0].payload) != "\ntwo" {
+		t.Errorf("Expected the last 2 messages, got %d", len(msgs))
+	}
+
+	if msgs := server.lastMessages(10); len(msgs) != 3 {
+		t.Errorf("Expected all 3 messages, got %d", len(msgs))
+	}
+
+	if msgs := server.messagesSince(now.Add(-5 * time.Minute)); len(msgs) != 2 {
+		t.Errorf("Expected 2 messages in the last 5 minutes, got %d", len(msgs))
+	}
+}
+
+// Test paging back through history with offsets and before-ID cursors
+func TestHistoryPage(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	alice := mockClient("Alice", "192.168.1.1", nil)
+	for _, text := range []string{"one", "two", "three", "four", "five"} {
+		server.messageClients(alice, "\n"+text, "")
+	}
+
+	page := server.historyPage(0, 2, 0)
+	if len(page.Messages) != 2 || page.Messages[0].Text != "four" || page.Messages[1].Text != "five" {
+		t.Fatalf("Unexpected newest page: %+v", page.Messages)
+	}
+
+	page = server.historyPage(page.NextBefore, 2, 0)
+	if len(page.Messages) != 2 || page.Messages[0].Text != "two" {
+		t.Fatalf("Unexpected second page: %+v", page.Messages)
+	}
+
+	page = server.historyPage(page.NextBefore, 2, 0)
+	if len(page.Messages) != 1 || page.Messages[0].Text != "one" || page.NextBefore != 0 {
+		t.Errorf("Unexpected last page: %+v", page)
+	}
+
+	page = server.historyPage(0, 1, 1)
+	if len(page.Messages) != 1 || page.Messages[0].Text != "four" {
+		t.Errorf("Expected offset to skip the newest message, got %+v", page.Messages)
+	}
+}
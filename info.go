@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// version, commit and buildDate identify the build in --version and
+// /server. Release builds set them with, for example,
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them, the commit and date recorded by the go command are used
+// when there are any.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// versionString describes the running build, such as
+// "net-cat v1.2.3 (commit 1a2b3c4, built 2025-01-01T10:00:00Z)".
+func versionString() string {
+	rev, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value[:min(len(setting.Value), 7)]
+			case setting.Key == "vcs.time" && date == "":
+				date = setting.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("net-cat %s (commit %s, built %s)", version, rev, date)
+}
+
+// serverInfo describes the server for /server: what is running, the limits
+// clients are subject to and how busy it is.
+func (s *Server) serverInfo() string {
+	clients := "no client limit"
+	if limit := s.maxClients.Load(); limit > 0 {
+		clients = fmt.Sprintf("up to %d clients", limit)
+	}
+	rate := "no send rate limit"
+	if s.config.ClientSendRate > 0 {
+		rate = fmt.Sprintf("sending up to %d bytes/s to each client", s.config.ClientSendRate)
+	}
+
+	s.mu.Lock()
+	lastHour := s.lastHour.count(time.Now())
+	s.mu.Unlock()
+
+	return fmt.Sprintf("%s on %s, up %s\nlimits: %s, messages up to %d bytes, %s\nload: %d clients connected, %d messages in the last hour",
+		versionString(), s.config.ServerName, time.Since(s.startedAt).Round(time.Second),
+		clients, s.maxMessageSize.Load(), rate,
+		s.clients.Count(), lastHour)
+}
+
+// secretMask replaces secrets set in the config when it is shown.
+const secretMask = "********"
+
+// masked returns a copy of c with its passwords and keys hidden, showing
+// only whether they are set.
+func (c Config) masked() Config {
+	for _, secret := range []*string{&c.LogKey, &c.OperatorPassword, &c.LinkPassword} {
+		if *secret != "" {
+			*secret = secretMask
+		}
+	}
+	return c
+}
+
+// configSettings lists the running config for /config, one "name: value"
+// line per setting in the order Config declares them, with secrets masked.
+// With names, only those settings are listed; unknown names are reported.
+func (s *Server) configSettings(names []string) (string, error) {
+	cfg := reflect.ValueOf(s.runningConfig().masked())
+	settings := make(map[string]string)
+	var order []string
+	for i := range cfg.NumField() {
+		name, _, _ := strings.Cut(cfg.Type().Field(i).Tag.Get("json"), ",")
+		value, err := json.Marshal(cfg.Field(i).Interface())
+		if err != nil {
+			return "", err
+		}
+		settings[name] = string(value)
+		order = append(order, name)
+	}
+
+	if len(names) > 0 {
+		order = names
+	}
+	var lines []string
+	for _, name := range order {
+		value, ok := settings[name]
+		if !ok {
+			return "", newClientError(ErrCodeNotFound, "unknown setting "+name)
+		}
+		lines = append(lines, name+": "+value)
+	}
+	return strings.Join(lines, "\n"), nil
+}
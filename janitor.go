@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// pruneExpired drops messages sent before cutoff from the history, the log
+// file and the snapshot. It returns how many history entries were dropped.
+func (s *Server) pruneExpired(cutoff time.Time) (int, error) {
+	removed := s.history.Remove(func(m Message) bool { return m.sent.Before(cutoff) })
+
+	if err := s.pruneLog(cutoff); err != nil {
+		return removed, err
+	}
+	if removed > 0 {
+		return removed, s.saveSnapshot()
+	}
+	return removed, nil
+}
+
+// pruneLog rewrites the log file starting from the first message stamped at
+// or after cutoff, if any message before it is stamped before cutoff. Join
+// and leave notices carry no timestamp, so those before that message are
+// dropped with the expired ones.
+func (s *Server) pruneLog(cutoff time.Time) error {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	data, err := s.readLog()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	start, expired := len(lines), false
+	for i, line := range lines {
+		sent, ok := lineTime(line)
+		if !ok {
+			continue
+		}
+		if !sent.Before(cutoff) {
+			start = i
+			break
+		}
+		expired = true
+	}
+	if !expired {
+		return nil
+	}
+
+	// Keep the leading newline every message starts with.
+	return s.rewriteLog([]byte(strings.Join(append([]string{""}, lines[start:]...), "\n")))
+}
+
+// lineTime parses the timestamp a chat message line starts with.
+func lineTime(line string) (time.Time, bool) {
+	end := strings.Index(line, "]")
+	if !strings.HasPrefix(line, "[") || end < 0 {
+		return time.Time{}, false
+	}
+	sent, err := time.ParseInLocation("02-01-2006 15:04:05", line[1:end], time.Local)
+	return sent, err == nil
+}
+
+// pruneDirects drops stored direct messages sent before cutoff.
+func (s *Server) pruneDirects(cutoff time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.directs[:0]
+	for _, m := range s.directs {
+		if !m.sent.Before(cutoff) {
+			kept = append(kept, m)
+		}
+	}
+	s.directs = kept
+}
+
+// errReaped is why a client removed by reapClosed left.
+var errReaped = errors.New("connection closed but never cleaned up")
+
+// reapClosed removes clients whose connection the server closed before
+// cutoff but which are still in the chat, because whatever should have
+// noticed is wedged. It returns how many it removed.
+func (s *Server) reapClosed(cutoff time.Time) int {
+	var stale []*Client
+	s.mu.Lock()
+	for _, c := range s.clients.All() {
+		if !c.closedAt.IsZero() && c.closedAt.Before(cutoff) && !c.left {
+			stale = append(stale, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range stale {
+		fmt.Printf("%s Reaping %s, disconnected at %s but still in the chat\n", c.connID, c.name.get(), c.closedAt.Format(time.RFC3339))
+		s.leave(c, errReaped)
+	}
+	return len(stale)
+}
+
+// reaperLoop removes clients stuck disconnecting for longer than
+// Config.ReapAfter until the server stops.
+func (s *Server) reaperLoop() {
+	after := time.Duration(s.config.ReapAfter)
+	if after <= 0 {
+		return
+	}
+	ticker := time.NewTicker(max(after/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.reapClosed(now.Add(-after))
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+// janitorLoop prunes messages older than Config.MessageTTL, and direct
+// messages older than Config.DMRetention, until the server stops.
+func (s *Server) janitorLoop() {
+	ttl := time.Duration(s.config.MessageTTL)
+	dmTTL := time.Duration(s.config.DMRetention)
+	if ttl <= 0 && dmTTL <= 0 {
+		return
+	}
+
+	shortest := ttl
+	if shortest <= 0 || (dmTTL > 0 && dmTTL < shortest) {
+		shortest = dmTTL
+	}
+	interval := min(max(shortest/10, time.Second), time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if ttl > 0 {
+				if _, err := s.pruneExpired(time.Now().Add(-ttl)); err != nil {
+					fmt.Println("Error pruning expired messages:", err)
+				}
+			}
+			if dmTTL > 0 {
+				s.pruneDirects(time.Now().Add(-dmTTL))
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that expired messages are pruned from history and the log file
+func TestPruneExpired(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	stamp := func(at time.Time) string { return "[" + at.Format("02-01-2006 15:04:05") + "]" }
+
+	alice := mockClient("Alice", "192.168.1.1", nil)
+	server.messageClients(alice, "\n"+stamp(old)+"[Alice]:old news", "")
+	server.messageClients(alice, "\nBob has joined our chat...", "")
+	server.messageClients(alice, "\n"+stamp(now)+"[Alice]:fresh", "")
+	msgs := server.history.Messages()
+	msgs[0].sent = old
+	server.history.Load(msgs)
+
+	removed, err := server.pruneExpired(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("pruneExpired failed: %v", err)
+	}
+
+	if kept := len(server.history.Messages()); removed != 1 || kept != 2 {
+		t.Errorf("Expected 1 message pruned and 2 kept, got %d and %d", removed, kept)
+	}
+
+	data, err := os.ReadFile(server.logPath)
+	if err != nil {
+		t.Fatalf("Reading log file: %v", err)
+	}
+
+	if string(data) != "\n"+stamp(now)+"[Alice]:fresh" {
+		t.Errorf("Unexpected log file after pruning: %q", data)
+	}
+
+	// With nothing expired the log is left as it is, rather than rewritten
+	// on every tick.
+	info, _ := os.Stat(server.logPath)
+	server.messageClients(alice, "\nCarol has joined our chat...", "")
+	if _, err := server.pruneExpired(now.Add(-time.Hour)); err != nil {
+		t.Fatalf("pruneExpired failed: %v", err)
+	}
+	if after, _ := os.Stat(server.logPath); !os.SameFile(info, after) {
+		t.Errorf("Expected the log not to be rewritten with nothing expired")
+	}
+}
+
+// Test that a client whose connection was closed but which never left is
+// reaped once, with one leave notice
+func TestReapClosed(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	server.addClient(alice)
+	bob, _ := pipeClient(t, "Bob", "192.168.1.2")
+	b := server.addClient(bob)
+
+	server.closeClient(b)
+	if n := server.reapClosed(time.Now().Add(-time.Minute)); n != 0 {
+		t.Errorf("Expected a freshly closed client to be left alone, reaped %d", n)
+	}
+	if n := server.reapClosed(time.Now().Add(time.Second)); n != 1 {
+		t.Errorf("Expected Bob to be reaped, reaped %d", n)
+	}
+	if server.findClient("Bob") != nil {
+		t.Errorf("Expected Bob to be removed from the chat.")
+	}
+
+	server.leave(b, errReaped)
+	if got := strings.Count(output(), "Bob has left our chat..."); got != 1 {
+		t.Errorf("Expected one leave notice, got %d in %q", got, output())
+	}
+}
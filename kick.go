@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// /kick disconnects a user. With a cooldown, from Config.KickCooldown or
+// given to /kick, neither their name nor their address may rejoin until it
+// is over, and their session cannot be resumed, so they cannot come
+// straight back. Cooldowns are kept in memory only; unlike a ban they are
+// meant to be short.
+
+// kickNameKey and kickAddrKey are the keys of Server.kicked for a name and
+// for the address a client connected from.
+func kickNameKey(name string) string {
+	return "name " + name
+}
+
+func kickAddrKey(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) == nil {
+		// Clients on a Unix socket share its address, so only the name
+		// is held back.
+		return ""
+	}
+	return "addr " + host
+}
+
+// kickedFor returns how long until key may rejoin, or zero if it may now.
+func (s *Server) kickedFor(key string) time.Duration {
+	if key == "" {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.kicked[key]
+	if !ok {
+		return 0
+	}
+	wait := time.Until(until)
+	if wait <= 0 {
+		delete(s.kicked, key)
+		return 0
+	}
+	return wait
+}
+
+// kickedError tells someone kicked how long until they may rejoin.
+func kickedError(wait time.Duration) *ClientError {
+	return newClientError(ErrCodePermissionDenied, fmt.Sprintf("You were kicked, try again in %s.", wait.Round(time.Second)))
+}
+
+// kick disconnects target for reason, holding back its name and address
+// for cooldown, and tells everyone.
+func (s *Server) kick(by, target *Client, cooldown time.Duration, reason string) {
+	s.mu.Lock()
+	if cooldown > 0 {
+		if s.kicked == nil {
+			s.kicked = make(map[string]time.Time)
+		}
+		until := time.Now().Add(cooldown)
+		s.kicked[kickNameKey(target.name.get())] = until
+		if key := kickAddrKey(target.ipAdd); key != "" {
+			s.kicked[key] = until
+		}
+	}
+	delete(s.sessions, target.session)
+	target.session = ""
+	s.mu.Unlock()
+
+	notice := target.name.get() + " was kicked by " + by.name.get()
+	told := "You were kicked by " + by.name.get()
+	if reason != "" {
+		notice += ": " + reason
+		told += ": " + reason
+	}
+	if cooldown > 0 {
+		told += fmt.Sprintf("\nYou can rejoin in %s.", cooldown)
+	}
+	fmt.Printf("%s %s\n", target.connID, notice)
+	s.reply(target, told)
+	s.closeClient(target)
+	s.announce(notice, by)
+}
+
+func cmdKick(s *Server, client *Client, args []string) {
+	if len(args) == 0 {
+		s.replyUsage(client, "/kick")
+		return
+	}
+	target := s.findClient(args[0])
+	if target == nil {
+		s.replyError(client, newClientError(ErrCodeNoSuchUser, "no such user "+args[0]))
+		return
+	}
+	if target == client {
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, "you cannot kick yourself"))
+		return
+	}
+
+	cooldown := time.Duration(s.config.KickCooldown)
+	reason := args[1:]
+	if len(reason) > 0 {
+		if d, err := time.ParseDuration(reason[0]); err == nil && d >= 0 {
+			cooldown, reason = d, reason[1:]
+		}
+	}
+	s.kick(client, target, cooldown, strings.Join(reason, " "))
+}
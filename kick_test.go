@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that a kicked user is disconnected and kept out for the cooldown
+func TestKickCooldown(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OperatorPassword = "secret"
+	server := newTestServer(t, cfg)
+
+	op, opOutput := pipeClient(t, "Op", "192.168.1.1:4000")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2:5000")
+	o := server.addClient(op)
+	b := server.addClient(bob)
+	server.issueSession(b)
+
+	server.handleCommand(o, "/kick Bob 10m spamming")
+	if !containsSubstring(opOutput(), "ERR_PERMISSION_DENIED") {
+		t.Errorf("Expected /kick to be for operators, got %q", opOutput())
+	}
+	server.handleCommand(o, "/oper secret")
+	server.handleCommand(o, "/kick Bob 10m spamming")
+
+	if !containsSubstring(bobOutput(), "You were kicked by Op: spamming\nYou can rejoin in 10m0s.") {
+		t.Errorf("Expected Bob to be told they were kicked, got %q", bobOutput())
+	}
+	if !containsSubstring(opOutput(), "Bob was kicked by Op: spamming") {
+		t.Errorf("Expected the kick to be announced, got %q", opOutput())
+	}
+	if b.closedAt.IsZero() || b.session != "" || len(server.sessions) != 0 {
+		t.Errorf("Expected Bob's connection closed and session dropped")
+	}
+	server.removeClient(bob)
+
+	err := server.checkName("Bob")
+	if err == nil || asClientError(err).Code != ErrCodePermissionDenied {
+		t.Errorf("Expected the name Bob to be held back, got %v", err)
+	}
+	if wait := server.kickedFor(kickAddrKey("192.168.1.2:6000")); wait <= 9*time.Minute {
+		t.Errorf("Expected Bob's address to be held back for 10m, got %s", wait)
+	}
+	if server.checkName("Carol") != nil || server.kickedFor(kickAddrKey("192.168.1.3:5000")) != 0 {
+		t.Errorf("Expected others to still be able to join")
+	}
+
+	// Once the cooldown is over, Bob may come back.
+	server.mu.Lock()
+	for key := range server.kicked {
+		server.kicked[key] = time.Now().Add(-time.Second)
+	}
+	server.mu.Unlock()
+	if err := server.checkName("Bob"); err != nil {
+		t.Errorf("Expected Bob to be allowed back after the cooldown, got %v", err)
+	}
+}
+
+// Test that without a cooldown a kicked user may rejoin straight away
+func TestKickWithoutCooldown(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OperatorPassword = "secret"
+	server := newTestServer(t, cfg)
+
+	op, _ := pipeClient(t, "Op", "192.168.1.1:4000")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2:5000")
+	o := server.addClient(op)
+	server.addClient(bob)
+
+	server.handleCommand(o, "/oper secret")
+	server.handleCommand(o, "/kick Bob")
+	if !containsSubstring(bobOutput(), "You were kicked by Op") {
+		t.Errorf("Expected Bob to be told they were kicked, got %q", bobOutput())
+	}
+	server.removeClient(bob)
+	if err := server.checkName("Bob"); err != nil {
+		t.Errorf("Expected Bob to be allowed back, got %v", err)
+	}
+}
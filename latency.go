@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// latencySamples is how many recent deliveries latencies keeps, and
+// slowSamples how many a client needs before it can be called slow.
+const (
+	latencySamples = 128
+	slowSamples    = 16
+)
+
+// latencies keeps how long the most recent deliveries took, so their
+// percentiles can be reported.
+type latencies struct {
+	samples [latencySamples]time.Duration
+	n, next int
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % latencySamples
+	l.n = min(l.n+1, latencySamples)
+}
+
+// quantile returns the q quantile, between 0 and 1, of the kept latencies,
+// or zero if there are none.
+func (l *latencies) quantile(q float64) time.Duration {
+	if l.n == 0 {
+		return 0
+	}
+	sorted := slices.Clone(l.samples[:l.n])
+	slices.Sort(sorted)
+	return sorted[min(int(q*float64(l.n)), l.n-1)]
+}
+
+// recordLatency notes that a delivery to c took d.
+func (s *Server) recordLatency(c *Client, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.latency.add(d)
+	s.latency.add(d)
+}
+
+// isSlow reports whether most recent deliveries to c took longer than
+// Config.SlowClientThreshold. The caller must hold s.mu.
+func (s *Server) isSlow(c *Client) bool {
+	threshold := time.Duration(s.config.SlowClientThreshold)
+	return threshold > 0 && c.latency.n >= slowSamples && c.latency.quantile(0.5) > threshold
+}
+
+// latencyDetails describes the delivery latency to c for operators,
+// flagging it if the client is slow. It is empty until something has been
+// delivered.
+func (s *Server) latencyDetails(c *Client) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.latency.n == 0 {
+		return ""
+	}
+	details := fmt.Sprintf(", delivery p50 %s p99 %s", c.latency.quantile(0.5), c.latency.quantile(0.99))
+	if s.isSlow(c) {
+		details += ", SLOW"
+	}
+	return details
+}
+
+// slowClients lists the names of the clients that are slow, for /stats.
+func (s *Server) slowClients() []string {
+	clients := s.clients.All()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var slow []string
+	for _, c := range clients {
+		if s.isSlow(c) {
+			slow = append(slow, fmt.Sprintf("%s (p50 %s)", c.name.get(), c.latency.quantile(0.5)))
+		}
+	}
+	return slow
+}
+
+// latencyStats describes the delivery latency to everyone for /stats.
+func (s *Server) latencyStats() string {
+	s.mu.Lock()
+	stats := fmt.Sprintf("delivery: p50 %s, p99 %s", s.latency.quantile(0.5), s.latency.quantile(0.99))
+	s.mu.Unlock()
+	if slow := s.slowClients(); len(slow) > 0 {
+		stats += "; slow clients: " + strings.Join(slow, ", ")
+	}
+	return stats
+}
+
+// writeLatencyMetrics writes the delivery latency percentiles, overall and
+// for each connected client.
+func (s *Server) writeLatencyMetrics(w io.Writer, clients []*Client) {
+	quantiles := []float64{0.5, 0.99}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprint(w, "# HELP netcat_delivery_seconds How long recent writes to clients took.\n# TYPE netcat_delivery_seconds summary\n")
+	for _, q := range quantiles {
+		fmt.Fprintf(w, "netcat_delivery_seconds{quantile=\"%g\"} %g\n", q, s.latency.quantile(q).Seconds())
+	}
+	fmt.Fprint(w, "# HELP netcat_client_delivery_seconds How long recent writes to each connected client took.\n# TYPE netcat_client_delivery_seconds summary\n")
+	for _, c := range clients {
+		for _, q := range quantiles {
+			fmt.Fprintf(w, "netcat_client_delivery_seconds{client=%s,quantile=\"%g\"} %g\n", quoteLabel(c.name.get()), q, c.latency.quantile(q).Seconds())
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that delivery latency percentiles are reported and slow clients are
+// flagged only once they are consistently slow
+func TestDeliveryLatency(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.config.SlowClientThreshold = Duration(100 * time.Millisecond)
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+	bob, _ := pipeClient(t, "Bob", "192.168.1.2")
+	b := server.addClient(bob)
+
+	for i := range 20 {
+		server.recordLatency(a, time.Duration(i+1)*time.Millisecond)
+		server.recordLatency(b, time.Second)
+	}
+
+	if got := a.latency.quantile(0.5); got != 11*time.Millisecond {
+		t.Errorf("Expected Alice's p50 to be 11ms, got %s", got)
+	}
+	if got := a.latency.quantile(0.99); got != 20*time.Millisecond {
+		t.Errorf("Expected Alice's p99 to be 20ms, got %s", got)
+	}
+	if stats := server.latencyStats(); !strings.Contains(stats, "slow clients: Bob (p50 1s)") || strings.Contains(stats, "Alice") {
+		t.Errorf("Expected only Bob to be slow, got %q", stats)
+	}
+	if details := server.latencyDetails(b); !strings.HasSuffix(details, "SLOW") {
+		t.Errorf("Expected Bob's details to flag Bob, got %q", details)
+	}
+
+	var out strings.Builder
+	server.writeMetrics(&out)
+	if want := `netcat_client_delivery_seconds{client="Bob",quantile="0.99"} 1`; !strings.Contains(out.String(), want) {
+		t.Errorf("Expected %q in %q", want, out.String())
+	}
+
+	carol, _ := pipeClient(t, "Carol", "192.168.1.3")
+	c := server.addClient(carol)
+	server.recordLatency(c, time.Second)
+	if strings.Contains(server.latencyStats(), "Carol") {
+		t.Errorf("Expected one slow delivery not to flag Carol.")
+	}
+}
+
+// Test that delivery time includes waiting behind deliveries already queued
+func TestDeliveryLatencyQueued(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+
+	a.gate.lock(priorityChat)
+	done := make(chan struct{})
+	go func() {
+		server.write(a, "queued", "", priorityChat)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	a.gate.unlock()
+	<-done
+
+	server.mu.Lock()
+	got := a.latency.quantile(0.5)
+	server.mu.Unlock()
+	if got < 50*time.Millisecond {
+		t.Errorf("Expected the wait to count towards delivery time, got %s", got)
+	}
+}
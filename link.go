@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server-to-server links let several servers share one chat. A server
+// dials each address in Config.Links and, at the name prompt, introduces
+// itself with "/link <server name> <password>". From then on both ends
+// exchange linkFrames, one JSON object per line. Every frame carries an ID
+// made from its origin server's name, and a server drops any ID it has
+// already seen, so frames can be forwarded to every other link without
+// looping.
+
+const (
+	frameMessage  = "msg"
+	framePresence = "presence"
+
+	// seenLimit bounds how many frame IDs are remembered for loop
+	// prevention.
+	seenLimit = 10000
+)
+
+// linkFrame is a message or presence change relayed between servers.
+type linkFrame struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Origin    string `json:"origin"`
+	Name      string `json:"name,omitempty"`
+	Text      string `json:"text,omitempty"`
+	Ephemeral bool   `json:"ephemeral,omitempty"`
+	Online    bool   `json:"online,omitempty"`
+}
+
+// link is an established connection to another server.
+type link struct {
+	peer string
+	conn net.Conn
+
+	// mu serialises writes to conn.
+	mu sync.Mutex
+}
+
+func (l *link) send(f linkFrame) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.conn.Write(append(data, '\n'))
+	return err
+}
+
+// isLinkHandshake reports whether a name line is a server introducing
+// itself rather than a user.
+func isLinkHandshake(name string) bool {
+	return strings.HasPrefix(name, "/link ")
+}
+
+// acceptLink checks a "/link <name> <password>" handshake and, if it is
+// valid, serves the link on conn.
+func (s *Server) acceptLink(conn net.Conn, reader *bufio.Reader, handshake string) {
+	fields := strings.Fields(handshake)
+	password := s.config.LinkPassword
+	if len(fields) != 3 || password == "" || subtle.ConstantTimeCompare([]byte(fields[2]), []byte(password)) != 1 {
+		s.emitEvent(Event{Type: EventAuthFailure, Addr: remoteAddr(conn), Reason: "invalid link handshake", Code: ErrCodePermissionDenied})
+		conn.Write([]byte(newClientError(ErrCodePermissionDenied, "link refused").Error() + "\n"))
+		conn.Close()
+		return
+	}
+
+	conn.Write([]byte("LINKED " + s.config.ServerName + "\n"))
+	go s.serveLink(conn, reader, fields[1])
+}
+
+// dialLinks keeps a link to each address in Config.Links, reconnecting
+// after a delay whenever one drops.
+func (s *Server) dialLinks() {
+	for _, addr := range s.config.Links {
+		go func() {
+			for {
+				if err := s.dialLink(addr); err != nil {
+					fmt.Println("link to", addr, "failed:", err)
+				}
+				select {
+				case <-s.quitch:
+					return
+				case <-time.After(5 * time.Second):
+				}
+			}
+		}()
+	}
+}
+
+// dialLink connects to the server at addr and serves the link until it
+// drops.
+func (s *Server) dialLink(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	if err := readUntil(reader, namePrompt); err != nil {
+		conn.Close()
+		return err
+	}
+	fmt.Fprintf(conn, "/link %s %s\n", s.config.ServerName, s.config.LinkPassword)
+
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	peer, ok := strings.CutPrefix(strings.TrimSpace(reply), "LINKED ")
+	if !ok {
+		conn.Close()
+		return fmt.Errorf("handshake rejected: %s", strings.TrimSpace(reply))
+	}
+
+	s.serveLink(conn, reader, peer)
+	return nil
+}
+
+// readUntil consumes input up to and including marker.
+func readUntil(reader *bufio.Reader, marker string) error {
+	var seen strings.Builder
+	for !strings.HasSuffix(seen.String(), marker) {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		seen.WriteByte(b)
+	}
+	return nil
+}
+
+// serveLink registers a link to peer, sends it our local presence and
+// relays its frames until the connection drops.
+func (s *Server) serveLink(conn net.Conn, reader *bufio.Reader, peer string) {
+	l := &link{peer: peer, conn: conn}
+	defer conn.Close()
+
+	s.mu.Lock()
+	s.links = append(s.links, l)
+	s.mu.Unlock()
+	var names []string
+	for _, c := range s.clients.All() {
+		names = append(names, c.name.get())
+	}
+	fmt.Println("linked to server", peer)
+
+	for _, name := range names {
+		l.send(s.newFrame(linkFrame{Type: framePresence, Name: name, Online: true}))
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+
+		var f linkFrame
+		if err := json.Unmarshal([]byte(line), &f); err != nil {
+			fmt.Println("bad frame from", peer+":", err)
+			continue
+		}
+		s.receiveFrame(l, f)
+	}
+
+	s.mu.Lock()
+	for i, other := range s.links {
+		if other == l {
+			s.links = append(s.links[:i], s.links[i+1:]...)
+			break
+		}
+	}
+	delete(s.remote, peer)
+	s.mu.Unlock()
+	fmt.Println("link to server", peer, "closed")
+}
+
+// newFrame stamps f with a fresh ID from this server and remembers it.
+func (s *Server) newFrame(f linkFrame) linkFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.frameSeq++
+	f.Origin = s.config.ServerName
+	f.ID = f.Origin + "-" + strconv.FormatUint(s.frameSeq, 10)
+	s.markSeen(f.ID)
+	return f
+}
+
+// markSeen records id and reports whether it was new. The caller must hold
+// s.mu.
+func (s *Server) markSeen(id string) bool {
+	if _, ok := s.seen[id]; ok {
+		return false
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]struct{})
+	}
+	s.seen[id] = struct{}{}
+	s.seenOrder = append(s.seenOrder, id)
+	if len(s.seenOrder) > seenLimit {
+		delete(s.seen, s.seenOrder[0])
+		s.seenOrder = s.seenOrder[1:]
+	}
+	return true
+}
+
+// receiveFrame applies a frame from a link locally and forwards it to the
+// other links, unless it has been seen before.
+func (s *Server) receiveFrame(from *link, f linkFrame) {
+	s.mu.Lock()
+	fresh := f.Origin != s.config.ServerName && s.markSeen(f.ID)
+	if fresh && f.Type == framePresence {
+		if s.remote == nil {
+			s.remote = make(map[string]map[string]bool)
+		}
+		// Presence is kept per link so a dropped link removes everyone
+		// reachable through it.
+		if s.remote[from.peer] == nil {
+			s.remote[from.peer] = make(map[string]bool)
+		}
+		if f.Online {
+			s.remote[from.peer][f.Name] = true
+		} else {
+			delete(s.remote[from.peer], f.Name)
+		}
+	}
+	s.mu.Unlock()
+	if !fresh {
+		return
+	}
+
+	// After a netsplit heals the same name can be in use on both sides.
+	// Tell the local user; /who shows both qualified with their server.
+	if f.Type == framePresence && f.Online {
+		if c := s.findClient(f.Name); c != nil {
+			s.reply(c, "Another "+f.Name+" is on server "+f.Origin+"; /who shows you as "+f.Name+"@"+s.config.ServerName+".")
+		}
+	}
+
+	if f.Type == frameMessage {
+		tf := "[" + time.Now().Format("02-01-2006 15:04:05") + "]"
+		s.broadcastLocal(Client{name: newClientName(f.Name), ipAdd: "link:" + f.Origin, ephemeral: f.Ephemeral}, f.Text, tf)
+	}
+	s.sendToLinks(f, from)
+}
+
+// sendToLinks writes f to every link except skip.
+func (s *Server) sendToLinks(f linkFrame, skip *link) {
+	s.mu.Lock()
+	links := append([]*link(nil), s.links...)
+	s.mu.Unlock()
+
+	for _, l := range links {
+		if l != skip {
+			if err := l.send(f); err != nil {
+				l.conn.Close()
+			}
+		}
+	}
+}
+
+// linkMessage relays a message broadcast on this server to linked servers.
+func (s *Server) linkMessage(client Client, message string) {
+	if !s.hasLinks() {
+		return
+	}
+	s.sendToLinks(s.newFrame(linkFrame{Type: frameMessage, Name: client.name.get(), Text: message, Ephemeral: client.ephemeral}), nil)
+}
+
+// linkPresence tells linked servers that name joined or left.
+func (s *Server) linkPresence(name string, online bool) {
+	if !s.hasLinks() {
+		return
+	}
+	s.sendToLinks(s.newFrame(linkFrame{Type: framePresence, Name: name, Online: online}), nil)
+}
+
+func (s *Server) hasLinks() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.links) > 0
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// linkedServer returns a server named name that logs to a temporary file.
+func linkedServer(t *testing.T, name string) *Server {
+	cfg := DefaultConfig()
+	cfg.ServerName = name
+	return newTestServer(t, cfg)
+}
+
+// connect links a and b over an in-memory connection.
+func connect(a, b *Server) {
+	ca, cb := net.Pipe()
+	go a.serveLink(ca, bufio.NewReader(ca), b.config.ServerName)
+	go b.serveLink(cb, bufio.NewReader(cb), a.config.ServerName)
+	time.Sleep(20 * time.Millisecond)
+}
+
+// Test that messages and presence cross a ring of links exactly once
+func TestLinkedServersRelayOnce(t *testing.T) {
+	a := linkedServer(t, "a")
+	b := linkedServer(t, "b")
+	c := linkedServer(t, "c")
+	connect(a, b)
+	connect(b, c)
+	connect(c, a)
+
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	c.addClient(bob)
+
+	alice := mockClient("Alice", "192.168.1.1", nil)
+	a.messageClients(alice, "\n[01-01-2025 10:00:00][Alice]:hello from a", "")
+	a.linkPresence("Alice", true)
+	time.Sleep(50 * time.Millisecond)
+
+	for _, s := range []*Server{b, c} {
+		if n := len(s.history.Messages()); n != 1 {
+			t.Errorf("Expected server %s to store the message once, got %d", s.config.ServerName, n)
+		}
+	}
+
+	if !containsSubstring(bobOutput(), "hello from a") {
+		t.Errorf("Expected Bob on server c to receive the message, got %q", bobOutput())
+	}
+
+	c.mu.Lock()
+	online := false
+	for _, names := range c.remote {
+		online = online || names["Alice"]
+	}
+	c.mu.Unlock()
+	if !online {
+		t.Errorf("Expected server c to see Alice as present.")
+	}
+}
+
+// Test that a link handshake with the wrong password is refused
+func TestLinkHandshakeRefused(t *testing.T) {
+	server := linkedServer(t, "a")
+	server.config.LinkPassword = "secret"
+
+	conn, peer := net.Pipe()
+	go server.acceptLink(conn, bufio.NewReader(conn), "/link b wrong")
+
+	reply, _ := bufio.NewReader(peer).ReadString('\n')
+	if reply != "ERR_PERMISSION_DENIED: link refused\n" {
+		t.Errorf("Expected link to be refused, got %q", reply)
+	}
+}
+
+// Test that names are unique across links and /who qualifies conflicts
+func TestLinkedNamesAndWho(t *testing.T) {
+	a := linkedServer(t, "a")
+	b := linkedServer(t, "b")
+
+	alice, _ := pipeClient(t, "Alice", "10.0.0.1")
+	a.addClient(alice)
+	connect(a, b)
+
+	if problem := b.checkName("Alice"); problem == nil {
+		t.Errorf("Expected Alice to be taken on the linked server.")
+	}
+
+	if problem := b.checkName(""); problem == nil {
+		t.Errorf("Expected an empty name to be refused.")
+	}
+
+	// Simulate a healed netsplit where Alice also joined server b.
+	other, _ := pipeClient(t, "Alice", "10.0.0.2")
+	b.addClient(other)
+
+	if who := b.whoList(false); !containsSubstring(who, "here: Alice@b") || !containsSubstring(who, "via a: Alice@a") {
+		t.Errorf("Expected conflicting names to be qualified, got %q", who)
+	}
+}
+
+// Test that the join notice counts and lists users on every linked server
+func TestOnlineUsers(t *testing.T) {
+	a := linkedServer(t, "a")
+	b := linkedServer(t, "b")
+
+	bob, _ := pipeClient(t, "Bob", "10.0.0.2")
+	carol, _ := pipeClient(t, "Carol", "10.0.0.3")
+	b.addClient(bob)
+	b.addClient(carol)
+	connect(a, b)
+
+	alice, _ := pipeClient(t, "Alice", "10.0.0.1")
+	a.addClient(alice)
+
+	if count := a.onlineCount(); count != "(3 users online)" {
+		t.Errorf("Expected 3 users online, got %q", count)
+	}
+	if here := a.currentlyHere("Alice"); here != "Currently here: Bob, Carol" {
+		t.Errorf("Expected Bob and Carol to be listed, got %q", here)
+	}
+	if here := linkedServer(t, "c").currentlyHere("Alice"); here != "Currently here: nobody else" {
+		t.Errorf("Expected nobody else to be listed, got %q", here)
+	}
+}
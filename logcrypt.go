@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// newLogCipher derives an AES-256-GCM cipher from secret.
+func newLogCipher(secret string) cipher.AEAD {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		// A 32 byte key is always valid for AES.
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// sealRecord encrypts plaintext into a single base64 line. An encrypted log
+// is a sequence of such lines whose plaintexts are concatenated.
+func sealRecord(aead cipher.AEAD, plaintext []byte) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)), base64.StdEncoding.EncodedLen(len(sealed))+1)
+	base64.StdEncoding.Encode(line, sealed)
+	return append(line, '\n')
+}
+
+// openRecords decrypts a file written with sealRecord.
+func openRecords(aead cipher.AEAD, data []byte) ([]byte, error) {
+	var out []byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+		n, err := base64.StdEncoding.Decode(sealed, line)
+		if err != nil {
+			return nil, err
+		}
+		sealed = sealed[:n]
+
+		if len(sealed) < aead.NonceSize() {
+			return nil, errors.New("encrypted record too short")
+		}
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, plain...)
+	}
+	return out, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Appending to the log file only hands the data to the operating system,
+// so a power loss can leave its last record half written or padded with
+// zeros, and an encrypted log with such a record could not be read at all.
+// The log is therefore synced to disk every Config.LogSyncInterval, and each
+// sync writes a checkpoint beside it recording how many bytes are known to
+// be complete. On startup recoverLog checks what follows the checkpoint and
+// cuts off a torn final record.
+
+// logCheckpoint is the record written to checkpointPath after each sync.
+type logCheckpoint struct {
+	Size   int64     `json:"size"`
+	Synced time.Time `json:"synced"`
+}
+
+// checkpointPath is where the log file's checkpoint is kept.
+func (s *Server) checkpointPath() string {
+	return s.logPath + ".checkpoint"
+}
+
+// syncLog syncs the log file to disk and writes a checkpoint of its size.
+// A missing log file is not an error. The caller must hold logMu.
+func (s *Server) syncLog() error {
+	f, err := os.OpenFile(s.logPath, os.O_WRONLY|os.O_APPEND, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(logCheckpoint{Size: info.Size(), Synced: time.Now()})
+	if err != nil {
+		return err
+	}
+	tmp := s.checkpointPath() + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.checkpointPath()); err != nil {
+		return err
+	}
+	s.logDirty = false
+	return nil
+}
+
+// flushLog syncs the log file if it was written since the last sync.
+func (s *Server) flushLog() error {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	if !s.logDirty {
+		return nil
+	}
+	return s.syncLog()
+}
+
+// logSyncLoop syncs the log file every Config.LogSyncInterval until the
+// server stops.
+func (s *Server) logSyncLoop() {
+	if s.config.LogSyncInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(s.config.LogSyncInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flushLog(); err != nil {
+				fmt.Println("Error syncing log file:", err)
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+// recoverLog truncates the log file after its last complete record, then
+// writes a fresh checkpoint. Only what follows the checkpoint is checked;
+// without one the whole file is.
+func (s *Server) recoverLog() error {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	data, err := os.ReadFile(s.logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var cp logCheckpoint
+	if saved, err := os.ReadFile(s.checkpointPath()); err == nil {
+		json.Unmarshal(saved, &cp)
+	}
+	from := int(cp.Size)
+	if from < 0 || from > len(data) {
+		// The file is shorter than when it was synced, so the checkpoint
+		// says nothing about it.
+		from = 0
+	}
+
+	keep := from + s.completeRecords(data[from:])
+	if keep < len(data) {
+		if err := os.Truncate(s.logPath, int64(keep)); err != nil {
+			return err
+		}
+		fmt.Printf("Recovered log file: dropped %d bytes of a torn final record\n", len(data)-keep)
+	}
+	return s.syncLog()
+}
+
+// completeRecords returns how many leading bytes of tail, the part of the
+// log file after its checkpoint, hold whole records. Encrypted records are
+// lines that must decrypt. Plain records are free text starting with a
+// newline, so one cut short cannot be told from a short message; only one
+// padded with the zeros a crash can leave is dropped.
+func (s *Server) completeRecords(tail []byte) int {
+	if s.logCipher != nil {
+		n := 0
+		for {
+			end := bytes.IndexByte(tail[n:], '\n')
+			if end < 0 {
+				return n
+			}
+			if _, err := openRecords(s.logCipher, tail[n:n+end+1]); err != nil {
+				return n
+			}
+			n += end + 1
+		}
+	}
+
+	trimmed := bytes.TrimRight(tail, "\x00")
+	if len(trimmed) == len(tail) {
+		return len(tail)
+	}
+	return max(bytes.LastIndexByte(trimmed, '\n'), 0)
+}
+
+// writeSynced writes data to the file at path and syncs it to disk, so it
+// can safely be renamed over another.
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that syncing the log file records a checkpoint of its size
+func TestLogCheckpoint(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LogFile = filepath.Join(t.TempDir(), "server_log.txt")
+	cfg.LogSyncInterval = 0
+	server := newTestServer(t, cfg)
+
+	alice := mockClient("Alice", "192.168.1.1", nil)
+	server.messageClients(alice, "\n[01-01-2025 10:00:00][Alice]:hello", "")
+
+	data, err := os.ReadFile(server.checkpointPath())
+	if err != nil {
+		t.Fatalf("Reading checkpoint: %v", err)
+	}
+	var cp logCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		t.Fatalf("Decoding checkpoint: %v", err)
+	}
+	if want := int64(len("\n[01-01-2025 10:00:00][Alice]:hello")); cp.Size != want {
+		t.Errorf("Expected a checkpoint at %d bytes, got %d", want, cp.Size)
+	}
+	if server.logDirty {
+		t.Error("Expected the log to be clean after syncing")
+	}
+}
+
+// Test that recovery cuts off a torn final record and keeps the rest
+func TestRecoverTornLog(t *testing.T) {
+	for _, key := range []string{"", "secret"} {
+		cfg := DefaultConfig()
+		cfg.LogFile = filepath.Join(t.TempDir(), "server_log.txt")
+		cfg.LogKey = key
+		server := newTestServer(t, cfg)
+
+		alice := mockClient("Alice", "192.168.1.1", nil)
+		server.messageClients(alice, "\n[01-01-2025 10:00:00][Alice]:hello", "")
+		server.messageClients(alice, "\n[01-01-2025 10:00:05][Alice]:still here", "")
+
+		// Simulate a crash part way through the next record, after the
+		// first two were synced.
+		server.logMu.Lock()
+		if err := server.syncLog(); err != nil {
+			t.Fatalf("syncLog failed: %v", err)
+		}
+		server.logMu.Unlock()
+		intact, _ := os.ReadFile(cfg.LogFile)
+		torn := []byte("\n[01-01-2025 10:00:09][Alice]:cut of\x00\x00\x00\x00")
+		if key != "" {
+			torn = sealRecord(server.logCipher, []byte("\n[01-01-2025 10:00:09][Alice]:cut off"))
+			torn = torn[:len(torn)/2]
+		}
+		f, err := os.OpenFile(cfg.LogFile, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatalf("Opening log file: %v", err)
+		}
+		f.Write(torn)
+		f.Close()
+
+		if err := server.recoverLog(); err != nil {
+			t.Fatalf("recoverLog failed with key %q: %v", key, err)
+		}
+		data, _ := os.ReadFile(cfg.LogFile)
+		if string(data) != string(intact) {
+			t.Errorf("Expected the torn record to be dropped with key %q, got %q", key, data)
+		}
+		plain, err := server.readLog()
+		if err != nil {
+			t.Fatalf("Reading recovered log with key %q: %v", key, err)
+		}
+		if want := "\n[01-01-2025 10:00:00][Alice]:hello\n[01-01-2025 10:00:05][Alice]:still here"; string(plain) != want {
+			t.Errorf("Expected %q after recovery with key %q, got %q", want, key, plain)
+		}
+	}
+}
+
+// Test that a log whose last record is complete is left alone, even when
+// it was written after the checkpoint
+func TestRecoverIntactLog(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LogFile = filepath.Join(t.TempDir(), "server_log.txt")
+	server := newTestServer(t, cfg)
+
+	alice := mockClient("Alice", "192.168.1.1", nil)
+	server.messageClients(alice, "\n[01-01-2025 10:00:00][Alice]:hello", "")
+	before, _ := os.ReadFile(cfg.LogFile)
+
+	if err := server.recoverLog(); err != nil {
+		t.Fatalf("recoverLog failed: %v", err)
+	}
+	after, _ := os.ReadFile(cfg.LogFile)
+	if string(after) != string(before) {
+		t.Errorf("Expected the log to be unchanged, got %q", after)
+	}
+}
@@ -2,191 +2,897 @@ package main
 
 import (
 	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
 )
 
+// welcomeBanner greets new connections unless Config.ShowBanner is off or
+// the client asked to skip it.
+const welcomeBanner = "Welcome to TCP-Chat!\n         _nnnn_\n        dGGGGMMb\n       @p~qp~~qMb\n       M|@||@) M|\n       @,----.JM|\n      JS^\\__/  qKL\n     dZP        qKRb\n    dZP          qKKb\n   fZP            SMMb\n   HZM            MMMM\n   FqM            MMMM\n __| \".        |\\dS\"qML\n |    `.       | `' \\Zq\n_)      \\.___.,|     .'\n\\____   )MMMMMP|   .'\n     `-'       `--'\n"
+
+// namePrompt ends the welcome banner and asks a new connection for a name.
+const namePrompt = "[ENTER YOUR NAME]:"
+
+// defaultMaxMessageSize is the most read from a client as one message
+// unless Config.MaxMessageSize says otherwise.
+const defaultMaxMessageSize = 2048
+
+// maxMaxMessageSize bounds Config.MaxMessageSize, as every client's reads
+// use a buffer that large.
+const maxMaxMessageSize = 64 << 10
+
 type Message struct {
+	id      uint64
 	from    string
 	name    string
 	payload []byte
+	sent    time.Time
+
+	// to lists the recipients of a direct message, and conns the
+	// connections of its sender and recipients, the only ones that may
+	// read it back with /dms.
+	to    []string
+	conns []string
 }
 
 type Client struct {
+	// connID is a short random ID for the connection, included in every
+	// event and server log line about it so one session can be picked out
+	// of a busy log.
+	connID string
+
 	conn  net.Conn
 	ipAdd string
-	name  string
+	// name is shared by copies of the client, as /nick changes it while
+	// other goroutines format messages from and to it.
+	name        *clientName
+	connectedAt time.Time
+
+	// lastActivity is when the client last sent anything, for the idle
+	// time /who and /whois show.
+	lastActivity time.Time
+
+	// ephemeral messages are broadcast but never stored in the history or
+	// the log file.
+	ephemeral bool
+
+	// operator clients have authenticated with /oper and may run
+	// operator-only commands.
+	operator bool
+
+	// replyTo is who /r answers: the sender of the last direct message
+	// this client received and anyone else it was sent to.
+	replyTo []string
+
+	// session is the token this client can resume with, if it asked for
+	// one.
+	session string
+
+	// network is how the client connected, such as "tcp" or "unix", and
+	// compression the method it negotiated, if any.
+	network     string
+	compression string
+
+	// messages counts the chat messages the client has sent, and
+	// lastHour those sent in the last hour.
+	messages int
+	lastHour hourCounter
+
+	// host is the name the client's address resolves to, when
+	// Config.ReverseDNS is set, and country the country code it is in,
+	// when Config.GeoIPDatabase is.
+	host    string
+	country string
+
+	// dnd clients are in do-not-disturb mode: mentions do not ring their
+	// bell, and direct messages are held until they turn it off. Senders
+	// are told, with away if it is set.
+	dnd  bool
+	away string
+	held []string
+
+	// color clients announced an ANSI terminal, so markdown-lite in
+	// messages is rendered for them.
+	color bool
+
+	// width, when set with /width or reported over telnet, is the column
+	// messages are wrapped at.
+	width int
+
+	// accessible clients use a screen reader, so output is kept free of
+	// bells, decorations and timestamps.
+	accessible bool
+
+	// closedAt is when the server closed the client's connection, which
+	// its readLoop should notice and leave; left is set once it has. See
+	// reapClosed for clients that never do.
+	closedAt time.Time
+	left     bool
+
+	// latency is how long recent deliveries to the client took.
+	latency latencies
+
+	// gate orders deliveries waiting to write to the client.
+	gate *writeGate
+
+	// share collects the text the client is sharing with /share.
+	share *shareDraft
+
+	// receipts clients announced the capability for read receipts, and
+	// peers are those they exchanged direct messages with, who are sent
+	// their receipts.
+	receipts bool
+	peers    []string
+
+	// room is the room the client is in, or "" for the main chat, and
+	// mainSeen the newest main chat message when it went into a room, see
+	// rooms.go.
+	room     string
+	mainSeen uint64
 }
 
 type Server struct {
 	listenAddr string
+	startedAt  time.Time
 	ln         net.Listener
 	quitch     chan struct{}
-	clients    []Client
-	messages   string
+	stopOnce   sync.Once
+	config     Config
+	logPath    string
+	eventLog   io.Writer
+
+	// logCipher encrypts the log file when Config.LogKey is set.
+	logCipher cipher.AEAD
+
+	// redactKey keys the address hashes used when Config.RedactIPs is set.
+	redactKey []byte
+
+	// clients and history are in memory by default; a clustering backend
+	// can swap in its own implementations before Start.
+	clients ClientRegistry
+	history HistoryStore
+
+	// joinMu serialises joining, see handleConn.
+	joinMu sync.Mutex
+
+	// mu guards per-client state, directs, sessions, poll, transfers,
+	// snippets, readMarkers, kicked, rooms and state.
+	mu       sync.Mutex
+	state    serverState
+	sessions map[string]*chatSession
+	poll     *poll
+
+	// transfers are the files being offered or sent, see transfer.go.
+	transfers   map[uint64]*transfer
+	transferSeq uint64
+
+	// snippets are the texts shared with /share, by ID.
+	snippets map[string]*snippet
+
+	// readMarkers is the ID of the last message each user marked read
+	// with /read.
+	readMarkers map[string]uint64
+
+	// kicked holds the names and addresses kicked with a cooldown, and
+	// when they may rejoin, see kick.go.
+	kicked map[string]time.Time
+
+	// rooms are the rooms clients have joined, by name.
+	rooms map[string]*room
+
+	// lastHour counts the chat messages sent by this server's clients,
+	// and latency how long recent deliveries to them took.
+	lastHour hourCounter
+	latency  latencies
+
+	// directs holds direct messages apart from history so they can never
+	// be replayed, exported or snapshotted.
+	directs []Message
+
+	// links are the connected servers, and remote the users present on
+	// the servers reachable through each link, keyed by link peer.
+	links     []*link
+	remote    map[string]map[string]bool
+	seen      map[string]struct{}
+	seenOrder []string
+	frameSeq  uint64
+
+	// upstream is the connection to Config.Upstream in relay mode.
+	upstream *upstreamConn
+
+	// logMu serialises writes and rewrites of the log file, and guards
+	// logDirty, set when it was written since it was last synced to disk.
+	logMu    sync.Mutex
+	logDirty bool
+
+	// handshakes, deliveries and logWriters count the work in progress
+	// against Config.MaxHandshakes, MaxDeliveries and MaxLogWriters.
+	handshakes budget
+	deliveries budget
+	logWriters budget
+
+	// hosts caches reverse lookups made with resolver for
+	// Config.ReverseDNS.
+	hosts    hostCache
+	resolver *net.Resolver
+
+	// geoip is the database opened from Config.GeoIPDatabase.
+	geoip *geoDB
+
+	// templates are the output templates, see outputTemplates.
+	templates map[string]*template.Template
+
+	// watch tracks deliveries for the watchdog.
+	watch watchdog
+
+	// churn holds back join and leave notices, see presenceNotice, and
+	// dedupe repeated notices, see broadcastNotice.
+	churn  churn
+	dedupe dedupe
+
+	// historyShed counts the messages dropped from the history to stay
+	// within Config.MemoryBudget.
+	historyShed atomic.Uint64
+
+	// droppedMessages counts chat messages dropped by the "drop" delivery
+	// overflow policy.
+	droppedMessages atomic.Uint64
+
+	// roomMessages, disconnects and authFailures are labelled metrics, see
+	// writeMetrics.
+	roomMessages, disconnects, authFailures counterVec
+
+	// maxClients, maxMessageSize and messageRateLimit start out as their
+	// Config values and can be changed while running with /set.
+	maxClients, maxMessageSize, messageRateLimit atomic.Int64
+
+	// announceOnly starts out as Config.AnnounceOnly and can be changed
+	// with /readonly.
+	announceOnly atomic.Bool
+}
+
+// addClient registers client and returns the copy the server keeps, which
+// is where any per-client state should be changed.
+func (s *Server) addClient(client Client) *Client {
+	c := &client
+	if c.gate == nil {
+		c.gate = &writeGate{}
+	}
+	if c.lastActivity.IsZero() {
+		c.lastActivity = c.connectedAt
+	}
+	s.clients.Add(c)
+	return c
+}
+
+// clientName is a client's name, with its own lock so it can be read from
+// any goroutine while /nick changes it.
+type clientName struct {
+	mu   sync.RWMutex
+	name string
 }
 
-func (s *Server) addClient(Client Client) {
-	s.clients = append(s.clients, Client)
+func newClientName(name string) *clientName {
+	return &clientName{name: name}
+}
+
+// get returns the name, or "" for a sender that has none, such as the
+// server itself.
+func (n *clientName) get() string {
+	if n == nil {
+		return ""
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.name
+}
+
+func (n *clientName) set(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.name = name
 }
 
 func (s *Server) removeClient(client Client) {
-	for i, c := range s.clients {
-		if c.ipAdd == client.ipAdd {
-			s.clients = append(s.clients[:i], s.clients[i+1:]...)
+	s.clients.Remove(client.ipAdd)
+}
+
+func (s *Server) messageClients(client Client, message string, tf string) {
+	if client.room != "" {
+		s.broadcastRoom(client, message, tf)
+		return
+	}
+	s.broadcastLocal(client, message, tf)
+	s.linkMessage(client, message)
+	s.upstreamMessage(message)
+}
+
+// broadcastLocal stores message and sends it to the clients connected to
+// this server that are in the main chat.
+func (s *Server) broadcastLocal(client Client, message string, tf string) {
+	if !client.ephemeral {
+		s.history.Append(Message{from: client.ipAdd, name: client.name.get(), payload: []byte(message), sent: time.Now()})
+	}
+
+	var here []*Client
+	s.mu.Lock()
+	for _, c := range s.clients.All() {
+		if c.room == "" {
+			here = append(here, c)
 		}
 	}
+	s.mu.Unlock()
+	s.deliverAll(client, here, message, tf)
+
+	if !client.ephemeral {
+		s.appendLog(message)
+	}
 }
 
-func (s *Server) messageClients(client Client, message string, tf string) {
-	s.messages += message
-	for _, c := range s.clients {
-		if c.ipAdd != client.ipAdd {
-			c.conn.Write([]byte(message))
-			c.conn.Write([]byte("\n" + tf + "[" + c.name + "]:"))
+// deliverAll sends message from client to each of clients, except the
+// sender and those ignoring it or, for a join or leave notice, those who
+// turned them off.
+func (s *Server) deliverAll(client Client, clients []*Client, message string, tf string) {
+	// Join and leave notices are only picked out when someone has turned
+	// them off, as matching them against the templates is not free.
+	presence := s.anyoneMuted(notifyJoins) && s.isPresenceNotice(strings.TrimPrefix(message, "\n"), client.name.get())
+
+	var recipients []*Client
+	ring := make(map[*Client]bool)
+	s.mu.Lock()
+	for _, c := range clients {
+		if s.ignoring(c.name.get(), client.name.get()) || (presence && !s.notifies(c.name.get(), notifyJoins)) {
+			continue
 		}
+		recipients = append(recipients, c)
+		ring[c] = !c.dnd && s.notifies(c.name.get(), notifyBells) && mentions(message, c.name.get())
 	}
+	s.mu.Unlock()
 
-	// Create or open the log file
-	logFile, err := os.OpenFile("server_log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o666)
-	if err != nil {
-		fmt.Println("Error opening log file:", err)
-		return
+	for _, c := range recipients {
+		if c.ipAdd != client.ipAdd {
+			if ring[c] {
+				s.deliver(c, bell+message, tf, senderPriority(client))
+			} else {
+				s.deliver(c, message, tf, senderPriority(client))
+			}
+		}
 	}
-	defer logFile.Close()
+}
 
-	// Create a bufio.Writer
-	writer := bufio.NewWriter(logFile)
+// deliver writes message to c followed by a fresh prompt, after any
+// deliveries to c already waiting with at least prio.
+func (s *Server) deliver(c *Client, message string, tf string, prio priority) {
+	s.write(c, s.render(c, message), tf, prio)
+}
 
-	// Write the message to the log file
-	_, err = writer.WriteString(message)
-	if err != nil {
-		fmt.Println("Error writing to log file:", err)
+// write sends text to c as it is, followed by its prompt.
+func (s *Server) write(c *Client, text string, tf string, prio priority) {
+	// Delivery time includes waiting behind deliveries already queued for
+	// c, as a slow link holds those up too.
+	started := time.Now()
+	c.gate.lock(prio)
+	defer c.gate.unlock()
+	defer s.watch.end(s.watch.begin(c))
+	defer s.recoverPanic(c.connID, func(err error) {
+		s.emitEvent(clientEvent(EventSendFailure, *c, err.Error()))
+		s.closeClient(c)
+	})
+	if _, err := c.conn.Write([]byte(text + "\n" + s.prompt(c, tf))); err != nil {
+		s.emitEvent(clientEvent(EventSendFailure, *c, err.Error()))
+		s.closeClient(c)
 		return
 	}
+	s.recordLatency(c, time.Since(started))
+}
 
-	// Flush the writer to ensure the data is written to the file immediately
-	err = writer.Flush()
-	if err != nil {
-		fmt.Println("Error flushing writer:", err)
+// closeClient closes c's connection, which makes its readLoop notice and
+// clean up.
+func (s *Server) closeClient(c *Client) {
+	s.mu.Lock()
+	if c.closedAt.IsZero() {
+		c.closedAt = time.Now()
+	}
+	s.mu.Unlock()
+	c.conn.Close()
+}
+
+// isOperator reports whether client has authenticated with /oper.
+func (s *Server) isOperator(client *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return client.operator
+}
+
+// isFull reports whether max_clients clients are already connected.
+func (s *Server) isFull() bool {
+	limit := int(s.maxClients.Load())
+	return limit > 0 && s.clients.Count() >= limit
+}
+
+// findClient returns the connected client called name, or nil.
+func (s *Server) findClient(name string) *Client {
+	return s.clients.Find(name)
+}
+
+// announce broadcasts a system notice to every client. When the notice is
+// caused by a command, the client that ran it is passed as except and sent
+// the notice with reply instead, so it does not get a second prompt.
+func (s *Server) announce(text string, except *Client) {
+	sender := Client{}
+	if except != nil {
+		sender.ipAdd = except.ipAdd
+		s.reply(except, text)
 	}
+	s.broadcastNotice(sender, text)
+}
+
+// reply sends text to client alone, without storing or broadcasting it.
+func (s *Server) reply(client *Client, text string) {
+	client.conn.Write([]byte(s.render(client, text) + "\n"))
 }
 
 func NewServer(listenAddr string) *Server {
-	return &Server{
+	return NewServerWithConfig(listenAddr, DefaultConfig())
+}
+
+func NewServerWithConfig(listenAddr string, cfg Config) *Server {
+	s := &Server{
 		listenAddr: listenAddr,
+		startedAt:  time.Now(),
 		quitch:     make(chan struct{}),
-		messages:   "",
+		config:     cfg,
+		clients:    newMemoryRegistry(),
+		history:    newMemoryHistory(),
+		logPath:    cfg.LogFile,
+		eventLog:   os.Stderr,
+		resolver:   net.DefaultResolver,
+	}
+	// Validate has already checked the templates, so any error here comes
+	// from a config that was never validated; fall back to the defaults.
+	templates, err := parseTemplates(cfg.templateOverrides())
+	if err != nil {
+		templates, _ = parseTemplates(nil)
+	}
+	s.templates = templates
+	s.initTunables()
+	if cfg.LogKey != "" {
+		s.logCipher = newLogCipher(cfg.LogKey)
+	}
+	if cfg.RedactIPs {
+		s.redactKey = make([]byte, 32)
+		rand.Read(s.redactKey)
 	}
+	return s
 }
 
 func (s *Server) Start() error {
-	ln, err := net.Listen("tcp", s.listenAddr)
+	if err := s.recoverLog(); err != nil {
+		fmt.Println("Error recovering log file:", err)
+	}
+	if err := s.loadSnapshot(); err != nil {
+		fmt.Println("Error loading history snapshot:", err)
+	}
+	if err := s.loadState(); err != nil {
+		fmt.Println("Error loading server state:", err)
+	}
+	if s.config.GeoIPDatabase != "" {
+		db, err := openGeoDB(s.config.GeoIPDatabase)
+		if err != nil {
+			return err
+		}
+		s.geoip = db
+	}
+
+	ln, err := s.listen(s.listenAddr)
 	if err != nil {
 		return err
 	}
 
 	defer ln.Close()
 
-	go s.acceptLoop()
-
+	s.mu.Lock()
 	s.ln = ln
+	s.mu.Unlock()
+	fmt.Printf("Listening on %s\n", ln.Addr())
+
+	go s.acceptLoop()
+	go s.snapshotLoop()
+	go s.logSyncLoop()
+	go s.janitorLoop()
+	go s.scheduleLoop()
+	go s.watchdogLoop()
+	go s.reaperLoop()
+	if s.config.MetricsListen != "" {
+		go s.serveMetrics()
+	}
+	s.dialLinks()
+	s.dialUpstream()
 
 	<-s.quitch
-	// close(s.msgch)
+	if err := s.saveSnapshot(); err != nil {
+		fmt.Println("Error saving history snapshot:", err)
+	}
+	if err := s.flushLog(); err != nil {
+		fmt.Println("Error syncing log file:", err)
+	}
 	return nil
 }
 
+// Addr returns the address the server is listening on, including the real
+// port when it was started on port 0, or nil if it is not listening yet.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Stop makes Start save the history snapshot, sync the log file and
+// return.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() { close(s.quitch) })
+}
+
 func (s *Server) acceptLoop() {
+	var queue chan net.Conn
+	if s.config.AcceptRate > 0 {
+		queue = make(chan net.Conn, s.config.AcceptQueue)
+		go s.admitLoop(queue, s.config.AcceptRate)
+	}
+
 	for {
 		conn, err := s.ln.Accept()
 		if err != nil {
+			select {
+			case <-s.quitch:
+				return
+			default:
+			}
 			fmt.Println("accept err:", err)
 			continue
 		}
+		if queue == nil {
+			go s.handleConn(conn)
+			continue
+		}
+		select {
+		case queue <- conn:
+		default:
+			s.refuseConn(conn)
+		}
+	}
+}
+
+// handleConn greets a new connection, asks for its name and, once it has
+// joined, hands it to readLoop. It runs in its own goroutine so a slow
+// client cannot hold up the others.
+func (s *Server) handleConn(conn net.Conn) {
+	connectedAt := time.Now()
+	id := newConnID()
+	defer s.recoverPanic(id, func(error) { conn.Close() })
+	s.tuneConn(conn)
+	conn = s.throttle(conn)
+	addr := remoteAddr(conn)
+	country := s.countryOf(addr)
+	s.emitEvent(Event{Type: EventConnect, Conn: id, Addr: addr, Country: country})
+
+	if !s.countryAllowed(country) {
+		conn.Write([]byte(s.errorText(newClientError(ErrCodePermissionDenied, "Connections from your country are not accepted.")) + "\n"))
+		s.emitEvent(Event{Type: EventAuthFailure, Conn: id, Addr: addr, Country: country, Reason: "country not allowed", Code: ErrCodePermissionDenied})
+		conn.Close()
+		return
+	}
 
-		conn.Write([]byte("Welcome to TCP-Chat!\n         _nnnn_\n        dGGGGMMb\n       @p~qp~~qMb\n       M|@||@) M|\n       @,----.JM|\n      JS^\\__/  qKL\n     dZP        qKRb\n    dZP          qKKb\n   fZP            SMMb\n   HZM            MMMM\n   FqM            MMMM\n __| \".        |\\dS\"qML\n |    `.       | `' \\Zq\n_)      \\.___.,|     .'\n\\____   )MMMMMP|   .'\n     `-'       `--'\n[ENTER YOUR NAME]:"))
-		// buf := make([]byte, 2048)
-		// n, err := conn.Read(buf)
+	if wait := s.kickedFor(kickAddrKey(addr)); wait > 0 {
+		conn.Write([]byte(s.errorText(kickedError(wait)) + "\n"))
+		s.emitEvent(Event{Type: EventAuthFailure, Conn: id, Addr: addr, Country: country, Reason: "kicked", Code: ErrCodePermissionDenied})
+		conn.Close()
+		return
+	}
+
+	if !s.handshakes.acquire(s.config.MaxHandshakes) {
+		conn.Write([]byte(s.errorText(newClientError(ErrCodeBusy, "Server is busy, please try again later.")) + "\n"))
+		s.emitEvent(Event{Type: EventAuthFailure, Conn: id, Addr: addr, Reason: "too many handshakes", Code: ErrCodeBusy})
+		conn.Close()
+		return
+	}
+	handshakeDone := sync.OnceFunc(s.handshakes.release)
+	defer handshakeDone()
+	// The lookup runs while the client picks a name.
+	host := s.resolveHost(addr)
+
+	if s.isFull() {
+		conn.Write([]byte(s.errorText(newClientError(ErrCodeServerFull, fmt.Sprintf("Chat is full (%d users), please try again later.", s.maxClients.Load()))) + "\n"))
+		s.emitEvent(Event{Type: EventAuthFailure, Conn: id, Addr: addr, Reason: "chat is full", Code: ErrCodeServerFull})
+		conn.Close()
+		return
+	}
 
-		reader := bufio.NewReader(conn)
-		Name, err := reader.ReadString('\n')
+	if s.config.TelnetNAWS {
+		conn.Write(askWindowSize)
+	}
+	reader := bufio.NewReader(conn)
+	caps, early := s.earlyCapabilities(conn, reader)
+	color, accessible, receipts := slices.Contains(caps, "color"), slices.Contains(caps, "accessible"), slices.Contains(caps, "receipts")
+	if early {
+		conn.Write([]byte("caps: " + strings.Join(caps, " ") + "\n"))
+	}
+	if timeout := time.Duration(s.config.HandshakeTimeout); timeout > 0 {
+		conn.SetReadDeadline(connectedAt.Add(timeout))
+	}
+	if s.config.ShowBanner && !slices.Contains(caps, "nobanner") && !accessible {
+		conn.Write([]byte(s.mustText("banner", nil)))
+	}
+	conn.Write([]byte(namePrompt))
 
-		// Name := string(buf[:n])
+	var Name, session string
+	var resumeAfter uint64
+	var width int
+	var err error
+	for {
+		Name, err = reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line, reported := stripTelnet([]byte(Name))
+		Name = string(line)
+		if reported > 0 {
+			width = reported
+		}
 		Name = strings.Replace(Name, "\r", "", -1)
 		Name = strings.Replace(Name, "\n", "", -1)
-		// fmt.Println()
-		// fmt.Print(Name[len(Name)-2])
 
-		client := Client{name: Name, conn: conn, ipAdd: conn.RemoteAddr().String()}
-		s.addClient(client)
+		if caps, ok := capabilityRequest(Name); ok {
+			color = slices.Contains(caps, "color")
+			accessible = slices.Contains(caps, "accessible")
+			receipts = slices.Contains(caps, "receipts")
+			conn.Write([]byte("caps: " + strings.Join(caps, " ") + "\n" + namePrompt))
+			continue
+		}
 
-		conn.Write([]byte(s.messages + "\n"))
+		if method, ok := compressionRequest(Name); ok {
+			if _, done := conn.(*compressedConn); done {
+				conn.Write([]byte(s.errorText(newClientError(ErrCodeConflict, "Compression is already on.")) + "\n" + namePrompt))
+				continue
+			}
+			compressed, problem := newCompressedConn(conn, reader, method)
+			if problem != nil {
+				conn.Write([]byte(s.errorText(problem) + "\n" + namePrompt))
+				continue
+			}
+			conn.Write([]byte("compression: " + method + "\n"))
+			conn, reader = compressed, bufio.NewReader(compressed)
+			conn.Write([]byte(namePrompt))
+			continue
+		}
 
-		// notify all clients that there is a new client
-		t := time.Now()
-		tf := "[" + t.Format("02-01-2006 15:04:05") + "]"
+		// joinMu is held from checking the name until the client is added,
+		// so two connections cannot claim the same name at once. The loop
+		// exits with it held unless reading failed.
+		s.joinMu.Lock()
+		if token, ok := resumeToken(Name); ok {
+			if name, lastID, ok := s.resumeSession(token); ok {
+				Name, session, resumeAfter = name, token, lastID
+				break
+			}
+			s.joinMu.Unlock()
+			conn.Write([]byte(s.errorText(newClientError(ErrCodeSessionInvalid, "Session cannot be resumed.")) + "\n" + namePrompt))
+			continue
+		}
+
+		problem := s.checkName(Name)
+		if problem == nil || isLinkHandshake(Name) {
+			break
+		}
+		s.joinMu.Unlock()
+		s.emitEvent(Event{Type: EventAuthFailure, Conn: id, Addr: addr, Name: Name, Reason: problem.Error(), Code: asClientError(problem).Code})
+		conn.Write([]byte(s.errorText(problem) + "\n" + namePrompt))
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		conn.Write([]byte(s.errorText(newClientError(ErrCodeTimeout, "Took too long to pick a name.")) + "\n"))
+		s.emitEvent(Event{Type: EventAuthFailure, Conn: id, Addr: addr, Reason: "handshake timed out", Code: ErrCodeTimeout})
+		conn.Close()
+		return
+	}
+	if err != nil {
+		s.emitEvent(Event{Type: EventAuthFailure, Conn: id, Addr: addr, Reason: err.Error()})
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
 
-		s.messageClients(client, "\n"+client.name+" has joined our chat...", tf)
+	if isLinkHandshake(Name) {
+		s.joinMu.Unlock()
+		handshakeDone()
+		s.acceptLink(conn, reader, Name)
+		return
+	}
 
-		go s.readLoop(conn, client)
+	client := s.addClient(Client{connID: id, name: newClientName(Name), conn: conn, ipAdd: addr, connectedAt: connectedAt, session: session,
+		network: conn.RemoteAddr().Network(), compression: compressionMethod(conn), host: host(), country: country, color: color, accessible: accessible, receipts: receipts})
+	if width > 0 {
+		s.setWidth(client, width)
+	}
+	s.applyPrefs(client)
+	s.joinMu.Unlock()
+	s.emitEvent(clientEvent(EventAuthSuccess, *client, ""))
+
+	// Say who can see the client's messages before replaying what they
+	// said. A resumed session is only sent what it missed.
+	conn.Write([]byte(s.currentlyHere(client.name.get()) + "\n"))
+	if session != "" {
+		if marker := s.unreadMarker(resumeAfter); marker != "" {
+			conn.Write([]byte(s.render(client, marker) + "\n"))
+		}
+	}
+	conn.Write([]byte(s.render(client, s.replayAfter(resumeAfter)) + "\n"))
+	if topic := s.topic(); topic != "" {
+		conn.Write([]byte(s.mustText("topic", map[string]string{"Topic": topic}) + "\n"))
 	}
+	if pins := s.pinList(); pins != "" {
+		conn.Write([]byte(s.render(client, pins) + "\n"))
+	}
+
+	// notify all clients that there is a new client
+	s.presenceNotice("join", client)
+	s.linkPresence(client.name.get(), true)
+	s.emitEvent(clientEvent(EventJoin, *client, ""))
+
+	handshakeDone()
+	s.readLoop(conn, client)
 }
 
-func (s *Server) readLoop(conn net.Conn, client Client) {
+func (s *Server) readLoop(conn net.Conn, client *Client) {
 	defer conn.Close()
+	defer s.recoverPanic(client.connID, func(err error) { s.leave(client, err) })
 
-	buf := make([]byte, 2048)
+	var buf []byte
+	// overflow is set while the rest of a line that was too long is still
+	// arriving, to be dropped.
+	overflow := false
 
 	for {
+		if size := int(s.maxMessageSize.Load()); len(buf) != size {
+			buf = make([]byte, size)
+		}
 		t := time.Now()
 
 		tf := "[" + t.Format("02-01-2006 15:04:05") + "]"
 
-		conn.Write([]byte(tf + "[" + client.name + "]:"))
+		conn.Write([]byte(s.prompt(client, tf)))
 		n, err := conn.Read(buf)
 		if err != nil {
-			s.messageClients(client, "\n"+client.name+" has left our chat...", tf)
-			s.removeClient(client)
+			s.leave(client, err)
 			return
 		}
 
-		payload := string(buf[:n])
+		data, width := stripTelnet(buf[:n])
+		if width > 0 {
+			s.setWidth(client, width)
+		}
+		if len(data) > 0 {
+			s.mu.Lock()
+			client.lastActivity = time.Now()
+			s.mu.Unlock()
+		}
+		if s.sharing(client) {
+			s.collectShare(client, string(data))
+			continue
+		}
+		// A read that fills buf without ending the line leaves the rest of
+		// the line for the next reads.
+		unfinished := n == len(buf) && buf[n-1] != '\n'
+		if overflow {
+			overflow = unfinished
+			continue
+		}
+		if unfinished {
+			overflow = true
+			s.replyError(client, newClientError(ErrCodeMsgTooLong, fmt.Sprintf("Messages can be at most %d bytes, message not sent.", len(buf)-1)))
+			continue
+		}
+		payload := string(data)
 		payload = strings.Replace(payload, "\r", "", -1)
 		payload = strings.Replace(payload, "\n", "", -1)
 
-		message := "\n" + tf + "[" + client.name + "]:" + payload
-		fmt.Print(message)
+		if s.handleCommand(client, payload) {
+			continue
+		}
+
+		message := "\n" + tf + "[" + client.name.get() + "]:" + payload
+		if !client.ephemeral {
+			// Off the record means off the server's output too.
+			fmt.Print("\n" + client.connID + " " + message[1:])
+		}
 
 		if len(payload) > 1 {
-			s.messageClients(client, message, tf)
+			if !s.mayPost(client) {
+				continue
+			}
+			rate, size := s.roomLimits(client)
+			if int64(len(payload)) >= size {
+				s.replyError(client, newClientError(ErrCodeMsgTooLong, fmt.Sprintf("Messages can be at most %d bytes here, message not sent.", size-1)))
+				continue
+			}
+			if !s.allowMessage(client, rate) {
+				s.replyError(client, newClientError(ErrCodeRateLimit, fmt.Sprintf("You can send %d messages a minute here, message not sent.", rate)))
+				continue
+			}
+			if !s.enforceMemoryBudget() {
+				s.replyError(client, newClientError(ErrCodeBusy, "Server is low on memory, message not sent."))
+				continue
+			}
+			if !s.admitDelivery(client) {
+				continue
+			}
+			s.messageClients(*client, message, tf)
+			s.deliveries.release()
+			s.countMessage(client)
 		}
 
 	}
 }
 
-func main() {
-	if len(os.Args) > 2 {
-		fmt.Println("[USAGE]: ./TCPChat $port")
+// leave removes client from the chat after its connection ended with err,
+// and tells everyone.
+func (s *Server) leave(client *Client, err error) {
+	s.mu.Lock()
+	left := client.left
+	client.left = true
+	s.mu.Unlock()
+	if left {
 		return
 	}
-	port := "8989"
 
-	if len(os.Args) > 1 {
-		port = os.Args[1]
+	s.removeClient(*client)
+	s.cancelTransfers(client)
+	s.presenceNotice("leave", client)
+	s.linkPresence(client.name.get(), false)
+	s.suspendSession(client)
+	s.moveTo(client, "")
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		s.emitEvent(clientEvent(EventTimeout, *client, err.Error()))
+	} else {
+		s.emitEvent(clientEvent(EventLeave, *client, err.Error()))
 	}
+}
 
-	server := NewServer(":" + port)
+func main() {
+	os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-	if err := server.Start(); err != nil {
-		// fmt.Println("err:", err)
-		port = "8989"
-		server = NewServer(":" + port)
-		log.Fatal(server.Start())
+// listenArg turns the command-line argument into a listen address. A bare
+// port listens on every interface; anything else is a full address such as
+// "127.0.0.1:2525", "[::1]:8989" or "unix:/run/netcat.sock".
+func listenArg(arg string) string {
+	if strings.Contains(arg, ":") {
+		return arg
 	}
-	fmt.Printf("Listening on the port :%s\n", port)
+	return ":" + arg
+}
+
+// stopOnSignal stops server cleanly on SIGINT or SIGTERM so its history
+// snapshot is saved.
+func stopOnSignal(server *Server) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		server.Stop()
+	}()
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -13,13 +16,62 @@ func mockClient(name string, ip string, conn net.Conn) Client {
 	return Client{
 		conn:  conn,
 		ipAdd: ip,
-		name:  name,
+		name:  newClientName(name),
+		gate:  &writeGate{},
 	}
 }
 
+// newTestServer returns a server for cfg whose files are kept in a
+// temporary directory, so tests never write into the repository. Paths the
+// test has already made absolute are left alone.
+func newTestServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, path := range []*string{&cfg.LogFile, &cfg.StateFile, &cfg.SnapshotFile, &cfg.ExportDir} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	return NewServerWithConfig(":8989", cfg)
+}
+
+// pipeClient returns a client backed by an in-memory connection, and a
+// function returning everything the server has written to it so far.
+func pipeClient(t *testing.T, name string, ip string) (Client, func() string) {
+	t.Helper()
+
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+
+	var mu sync.Mutex
+	var received strings.Builder
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, err := peer.Read(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			received.Write(buf[:n])
+			mu.Unlock()
+		}
+	}()
+
+	output := func() string {
+		// Give the reader goroutine a moment to drain the pipe.
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		return received.String()
+	}
+	return mockClient(name, ip, conn), output
+}
+
 // Test the addClient method
 func TestAddClient(t *testing.T) {
-	server := NewServer(":8989")
+	server := newTestServer(t, DefaultConfig())
 
 	client1 := mockClient("Alice", "192.168.1.1", nil)
 	client2 := mockClient("Bob", "192.168.1.2", nil)
@@ -27,18 +79,19 @@ func TestAddClient(t *testing.T) {
 	server.addClient(client1)
 	server.addClient(client2)
 
-	if len(server.clients) != 2 {
-		t.Errorf("Expected 2 clients, got %d", len(server.clients))
+	clients := server.clients.All()
+	if len(clients) != 2 {
+		t.Errorf("Expected 2 clients, got %d", len(clients))
 	}
 
-	if server.clients[0].name != "Alice" || server.clients[1].name != "Bob" {
+	if clients[0].name.get() != "Alice" || clients[1].name.get() != "Bob" {
 		t.Errorf("Client names do not match the expected values.")
 	}
 }
 
 // Test the removeClient method
 func TestRemoveClient(t *testing.T) {
-	server := NewServer(":8989")
+	server := newTestServer(t, DefaultConfig())
 
 	client1 := mockClient("Alice", "192.168.1.1", nil)
 	client2 := mockClient("Bob", "192.168.1.2", nil)
@@ -50,11 +103,12 @@ func TestRemoveClient(t *testing.T) {
 	// Remove client1
 	server.removeClient(client1)
 
-	if len(server.clients) != 1 {
-		t.Errorf("Expected 1 client, got %d", len(server.clients))
+	clients := server.clients.All()
+	if len(clients) != 1 {
+		t.Errorf("Expected 1 client, got %d", len(clients))
 	}
 
-	if server.clients[0].name != "Bob" {
+	if clients[0].name.get() != "Bob" {
 		t.Errorf("Expected Bob to be the only remaining client.")
 	}
 }
@@ -80,14 +134,14 @@ func TestNewServer(t *testing.T) {
 		t.Errorf("Expected quitch channel to be initialized.")
 	}
 
-	if server.messages != "" {
-		t.Errorf("Expected messages to be empty, got %s", server.messages)
+	if n := len(server.history.Messages()); n != 0 {
+		t.Errorf("Expected history to be empty, got %d messages", n)
 	}
 }
 
 // Test the Start method for a successful server start
 func TestServerStart(t *testing.T) {
-	server := NewServer(":8989")
+	server := newTestServer(t, DefaultConfig())
 
 	go func() {
 		if err := server.Start(); err != nil {
@@ -98,7 +152,7 @@ func TestServerStart(t *testing.T) {
 	// Allow time for the server to start up
 	time.Sleep(1 * time.Second)
 
-	if server.ln == nil {
+	if server.Addr() == nil {
 		t.Errorf("Expected listener to be initialized, but it was nil.")
 	}
 }
@@ -110,10 +164,61 @@ func TestMainInvalidPort(t *testing.T) {
 	defer func() { os.Args = originalArgs }() // Restore original os.Args
 
 	os.Args = []string{"./TCPChat", "invalidPort"}
-	server := NewServer(":8989")
+	server := newTestServer(t, DefaultConfig())
 	err := server.Start()
 
 	if err == nil {
 		t.Errorf("Expected error when starting server with invalid port.")
 	}
 }
+
+// Test that the server reports full once MaxClients is reached
+func TestIsFull(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxClients = 2
+	server := newTestServer(t, cfg)
+
+	server.addClient(mockClient("Alice", "192.168.1.1", nil))
+	if server.isFull() {
+		t.Errorf("Expected room for another client.")
+	}
+
+	server.addClient(mockClient("Bob", "192.168.1.2", nil))
+	if !server.isFull() {
+		t.Errorf("Expected server to be full with %d clients.", cfg.MaxClients)
+	}
+}
+
+// Test that a connection that does not pick a name in time is dropped and
+// frees its handshake slot, while one that does may then stay quiet
+func TestHandshakeTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HandshakeTimeout = Duration(300 * time.Millisecond)
+	server := newTestServer(t, cfg)
+
+	idle, peer := net.Pipe()
+	go server.handleConn(idle)
+	reader := bufio.NewReader(peer)
+	if err := readUntil(reader, namePrompt); err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := reader.ReadString('\n'); !strings.Contains(line, ErrCodeTimeout) {
+		t.Errorf("Expected the idle connection to time out, got %q", line)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := server.handshakes.inUse.Load(); n != 0 {
+		t.Errorf("Expected the handshake slot to be freed, %d in use", n)
+	}
+
+	conn, peer := net.Pipe()
+	go server.handleConn(conn)
+	reader = bufio.NewReader(peer)
+	readUntil(reader, namePrompt)
+	peer.Write([]byte("Alice\n"))
+	readUntil(reader, "[Alice]:")
+	time.Sleep(400 * time.Millisecond)
+	if server.findClient("Alice") == nil {
+		t.Errorf("Expected Alice to stay connected after joining.")
+	}
+	peer.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import "fmt"
+
+// messageOverhead approximates what a stored message costs beyond its
+// text: the Message struct, slice headers and the history's backing array.
+const messageOverhead = 96
+
+// messageCost approximates the memory m takes up in the history.
+func messageCost(m Message) int {
+	cost := messageOverhead + len(m.payload) + len(m.from) + len(m.name)
+	for _, to := range m.to {
+		cost += len(to)
+	}
+	return cost
+}
+
+// memoryUsage approximates the bytes held by the in-memory history and by
+// the direct messages queued for clients in do-not-disturb mode.
+func (s *Server) memoryUsage() (history, queues int) {
+	for _, m := range s.history.Messages() {
+		history += messageCost(m)
+	}
+	clients := s.clients.All()
+	s.mu.Lock()
+	for _, c := range clients {
+		for _, held := range c.held {
+			queues += messageOverhead + len(held)
+		}
+	}
+	s.mu.Unlock()
+	return history, queues
+}
+
+// enforceMemoryBudget drops the oldest history messages until the history
+// and queues fit in Config.MemoryBudget, logging a warning when it does.
+// It reports whether they fit; queues alone can still exceed the budget,
+// in which case new messages are refused until they drain.
+func (s *Server) enforceMemoryBudget() bool {
+	budget := s.config.MemoryBudget
+	if budget <= 0 {
+		return true
+	}
+	history, queues := s.memoryUsage()
+	over := history + queues - budget
+	if over <= 0 {
+		return true
+	}
+
+	var cutoff uint64
+	freed := 0
+	for _, m := range s.history.Messages() {
+		if freed >= over {
+			break
+		}
+		freed += messageCost(m)
+		cutoff = m.id
+	}
+	shed := s.history.Remove(func(m Message) bool { return m.id <= cutoff })
+	if shed == 0 {
+		return false
+	}
+	s.historyShed.Add(uint64(shed))
+	fmt.Printf("Warning: memory budget of %d bytes exceeded (history %d, queues %d), dropped the %d oldest messages from the history\n",
+		budget, history, queues, shed)
+	return freed >= over
+}
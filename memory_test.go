@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Test that the oldest history is dropped to stay within the memory
+// budget, and messages are refused once held messages alone exceed it
+func TestMemoryBudget(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.eventLog = nil
+
+	for i := range 10 {
+		server.history.Append(Message{name: "Alice", payload: []byte(fmt.Sprintf("\nmessage %d", i)), sent: time.Now()})
+	}
+	perMessage := messageCost(server.history.Messages()[0])
+	server.config.MemoryBudget = 4 * perMessage
+
+	if !server.enforceMemoryBudget() {
+		t.Fatal("Expected the history to be shed to fit the budget.")
+	}
+	msgs := server.history.Messages()
+	if len(msgs) != 4 || string(msgs[0].payload) != "\nmessage 6" {
+		t.Errorf("Expected the 4 newest messages to be kept, got %d starting with %q", len(msgs), msgs[0].payload)
+	}
+	if server.historyShed.Load() != 6 {
+		t.Errorf("Expected 6 messages shed, got %d", server.historyShed.Load())
+	}
+
+	bob, _ := pipeClient(t, "Bob", "192.168.1.2")
+	b := server.addClient(bob)
+	server.setDND(b, true, "")
+	server.history.Remove(func(Message) bool { return true })
+	for range 5 {
+		server.holdDirect(b, "a direct message held for Bob")
+	}
+	if server.enforceMemoryBudget() {
+		t.Errorf("Expected held messages over the budget to refuse new messages.")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// counterVec counts things by the value of one label, such as disconnects
+// by reason.
+type counterVec struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (v *counterVec) inc(label string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.counts == nil {
+		v.counts = make(map[string]uint64)
+	}
+	v.counts[label]++
+}
+
+// snapshot returns a copy of the counts.
+func (v *counterVec) snapshot() map[string]uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return maps.Clone(v.counts)
+}
+
+// countEvent updates the metrics a lifecycle event feeds: disconnects by
+// reason and refused connections by error code.
+func (s *Server) countEvent(ev Event) {
+	switch ev.Type {
+	case EventLeave, EventTimeout, EventSendFailure:
+		s.disconnects.inc(ev.Type)
+	case EventAuthFailure:
+		cause := ev.Code
+		if cause == "" {
+			// The connection ended before it chose a name.
+			cause = "disconnected"
+		}
+		s.authFailures.inc(cause)
+	}
+}
+
+// writeMetrics writes the server's metrics in the Prometheus text format.
+// Messages in the main chat are labelled as roomLabel names it, and the
+// per-client queue is the direct messages held while in do-not-disturb
+// mode.
+func (s *Server) writeMetrics(w io.Writer) {
+	clients := s.clients.All()
+
+	fmt.Fprint(w, "# HELP netcat_clients Clients in the chat.\n# TYPE netcat_clients gauge\n")
+	fmt.Fprintf(w, "netcat_clients %d\n", len(clients))
+	fmt.Fprint(w, "# HELP netcat_handshakes Connections still picking a name.\n# TYPE netcat_handshakes gauge\n")
+	fmt.Fprintf(w, "netcat_handshakes %d\n", s.handshakes.inUse.Load())
+
+	writeCounterVec(w, "netcat_messages_total", "Chat messages sent, by room.", "room", s.roomMessages.snapshot())
+	writeCounterVec(w, "netcat_disconnects_total", "Clients that left, by reason.", "reason", s.disconnects.snapshot())
+	writeCounterVec(w, "netcat_auth_failures_total", "Connections refused before joining, by error code.", "cause", s.authFailures.snapshot())
+
+	history, queues := s.memoryUsage()
+	fmt.Fprint(w, "# HELP netcat_memory_bytes Approximate memory held, by what holds it.\n# TYPE netcat_memory_bytes gauge\n")
+	fmt.Fprintf(w, "netcat_memory_bytes{kind=\"history\"} %d\nnetcat_memory_bytes{kind=\"queues\"} %d\n", history, queues)
+	fmt.Fprint(w, "# HELP netcat_history_shed_total Messages dropped from the history to stay within the memory budget.\n# TYPE netcat_history_shed_total counter\n")
+	fmt.Fprintf(w, "netcat_history_shed_total %d\n", s.historyShed.Load())
+	fmt.Fprint(w, "# HELP netcat_dropped_messages_total Chat messages dropped because too many were being broadcast.\n# TYPE netcat_dropped_messages_total counter\n")
+	fmt.Fprintf(w, "netcat_dropped_messages_total %d\n", s.droppedMessages.Load())
+
+	sent := make(map[string]uint64, len(clients))
+	held := make(map[string]uint64, len(clients))
+	s.mu.Lock()
+	for _, c := range clients {
+		sent[c.name.get()] = uint64(c.messages)
+		held[c.name.get()] = uint64(len(c.held))
+	}
+	s.mu.Unlock()
+	writeCounterVec(w, "netcat_client_messages_total", "Chat messages sent by each connected client.", "client", sent)
+	fmt.Fprint(w, "# HELP netcat_client_queued_messages Direct messages held for each connected client.\n# TYPE netcat_client_queued_messages gauge\n")
+	for _, name := range slices.Sorted(maps.Keys(held)) {
+		fmt.Fprintf(w, "netcat_client_queued_messages{client=%s} %d\n", quoteLabel(name), held[name])
+	}
+	s.writeLatencyMetrics(w, clients)
+}
+
+func writeCounterVec(w io.Writer, name, help, label string, counts map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, value := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Fprintf(w, "%s{%s=%s} %d\n", name, label, quoteLabel(value), counts[value])
+	}
+}
+
+// quoteLabel quotes a label value as the Prometheus text format wants.
+func quoteLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// serveMetrics serves metricsHandler on Config.MetricsListen until the
+// server stops.
+func (s *Server) serveMetrics() {
+	srv := &http.Server{Addr: s.config.MetricsListen, Handler: s.metricsHandler()}
+	go func() {
+		<-s.quitch
+		srv.Close()
+	}()
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Println("Error serving metrics:", err)
+	}
+}
+
+// metricsHandler serves the metrics at /metrics, a debug dump at /debug
+// and, for privacy requests, POST /forget?name=<user>. The last two are
+// for operators and need the operator password as a bearer token.
+func (s *Server) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.writeMetrics(w)
+	})
+	mux.HandleFunc("/debug", s.operatorOnly(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		s.writeDebugDump(w, true)
+	}))
+	mux.HandleFunc("/forget", s.operatorOnly(s.handleForget))
+	return mux
+}
+
+// operatorOnly refuses requests to next that do not carry the operator
+// password as "Authorization: Bearer <password>". Like /oper, it refuses
+// everyone while no password is set.
+func (s *Server) operatorOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		password := s.config.OperatorPassword
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if password == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "the operator password is needed as a bearer token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleForget is the admin API for Forget: POST /forget?name=<user>
+// removes everything name has ever said.
+func (s *Server) handleForget(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	switch {
+	case r.Method != http.MethodPost:
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	case name == "":
+		http.Error(w, "usage: POST /forget?name=<user>", http.StatusBadRequest)
+		return
+	}
+	removed, err := s.Forget(name, time.Time{})
+	if err != nil {
+		fmt.Println("Error forgetting messages:", err)
+		http.Error(w, fmt.Sprintf("removed %d messages from history, but the files could not all be cleaned: %v", removed, err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "removed %d messages from history and the log file\n", removed)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test that events and messages feed the labelled metrics
+func TestMetrics(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.eventLog = nil
+	server.config.ServerName = "chat1"
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+	server.countMessage(a)
+	server.countMessage(a)
+	server.setDND(a, true, "")
+	server.holdDirect(a, "psst")
+
+	server.emitEvent(Event{Type: EventAuthFailure, Reason: "chat is full", Code: ErrCodeServerFull})
+	server.emitEvent(Event{Type: EventAuthFailure, Reason: "EOF"})
+	server.emitEvent(clientEvent(EventTimeout, alice, "i/o timeout"))
+	server.emitEvent(clientEvent(EventLeave, alice, "EOF"))
+	server.emitEvent(clientEvent(EventLeave, alice, "EOF"))
+
+	var out strings.Builder
+	server.writeMetrics(&out)
+	for _, want := range []string{
+		"netcat_clients 1\n",
+		`netcat_messages_total{room="chat1"} 2`,
+		`netcat_disconnects_total{reason="leave"} 2`,
+		`netcat_disconnects_total{reason="timeout"} 1`,
+		`netcat_auth_failures_total{cause="ERR_SERVER_FULL"} 1`,
+		`netcat_auth_failures_total{cause="disconnected"} 1`,
+		`netcat_client_messages_total{client="Alice"} 2`,
+		`netcat_client_queued_messages{client="Alice"} 1`,
+	} {
+		if !containsSubstring(out.String(), want) {
+			t.Errorf("Expected %q in %q", want, out.String())
+		}
+	}
+
+	if got := quoteLabel("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Errorf("quoteLabel = %s", got)
+	}
+}
+
+// Test the admin API for forgetting a name, and that it and the debug dump
+// need the operator password
+func TestForgetAPI(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	handler := server.metricsHandler()
+
+	alice := mockClient("Alice", "192.168.1.1", nil)
+	server.messageClients(alice, "\n[01-01-2025 10:00:00][Alice]:hello", "")
+
+	for _, tc := range []struct {
+		password, token string
+		method, target  string
+		code            int
+		want            string
+	}{
+		{"", "", http.MethodPost, "/forget?name=Alice", http.StatusUnauthorized, "operator password"},
+		{"secret", "", http.MethodPost, "/forget?name=Alice", http.StatusUnauthorized, "operator password"},
+		{"secret", "wrong", http.MethodPost, "/forget?name=Alice", http.StatusUnauthorized, "operator password"},
+		{"secret", "wrong", http.MethodGet, "/debug", http.StatusUnauthorized, "operator password"},
+		{"secret", "", http.MethodGet, "/metrics", http.StatusOK, "netcat_clients"},
+		{"secret", "secret", http.MethodGet, "/debug", http.StatusOK, "goroutines"},
+		{"secret", "secret", http.MethodGet, "/forget?name=Alice", http.StatusMethodNotAllowed, "use POST"},
+		{"secret", "secret", http.MethodPost, "/forget", http.StatusBadRequest, "usage"},
+		{"secret", "secret", http.MethodPost, "/forget?name=Alice", http.StatusOK, "removed 1 messages"},
+	} {
+		server.config.OperatorPassword = tc.password
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.code || !containsSubstring(rec.Body.String(), tc.want) {
+			t.Errorf("%s %s with %q: got %d %q, want %d %q", tc.method, tc.target, tc.token, rec.Code, rec.Body.String(), tc.code, tc.want)
+		}
+		if tc.code == http.StatusUnauthorized && server.replayHistory() == "" {
+			t.Fatalf("Expected a refused request to leave the history alone")
+		}
+	}
+	if history := server.replayHistory(); history != "" {
+		t.Errorf("Expected Alice to be forgotten, got %q", history)
+	}
+}
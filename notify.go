@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Notification settings a user can turn off with /notify. They are kept
+// in the server state by name, so they survive reconnects and restarts.
+const (
+	// notifyJoins is the join and leave notices.
+	notifyJoins = "joins"
+	// notifyBells is the bell rung when a public message mentions you.
+	notifyBells = "bells"
+	// notifyPreviews is the text of direct messages as they arrive; with
+	// it off only the sender is shown until /notify read.
+	notifyPreviews = "previews"
+)
+
+var notifySettings = []string{notifyJoins, notifyBells, notifyPreviews}
+
+// notifies reports whether name wants the notification setting. The caller
+// must hold s.mu.
+func (s *Server) notifies(name, setting string) bool {
+	return !slices.Contains(s.state.Muted[name], setting)
+}
+
+// anyoneMuted reports whether any user has turned setting off.
+func (s *Server) anyoneMuted(setting string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, muted := range s.state.Muted {
+		if slices.Contains(muted, setting) {
+			return true
+		}
+	}
+	return false
+}
+
+// setNotify turns the notification setting on or off for name and saves
+// it.
+func (s *Server) setNotify(name, setting string, on bool) error {
+	s.mu.Lock()
+	muted := slices.DeleteFunc(s.state.Muted[name], func(m string) bool { return m == setting })
+	if !on {
+		muted = append(muted, setting)
+	}
+	if s.state.Muted == nil {
+		s.state.Muted = make(map[string][]string)
+	}
+	if len(muted) == 0 {
+		delete(s.state.Muted, name)
+	} else {
+		s.state.Muted[name] = muted
+	}
+	s.mu.Unlock()
+
+	return s.saveState()
+}
+
+// holdPreview keeps a direct message for c if it has previews turned off,
+// and reports whether it did.
+func (s *Server) holdPreview(c *Client, message string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.notifies(c.name.get(), notifyPreviews) {
+		return false
+	}
+	c.held = append(c.held, message)
+	return true
+}
+
+// takeHeld returns the direct messages held for client and forgets them.
+func (s *Server) takeHeld(client *Client) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	held := client.held
+	client.held = nil
+	return held
+}
+
+func cmdNotify(s *Server, client *Client, args []string) {
+	switch {
+	case len(args) == 0:
+		var lines []string
+		s.mu.Lock()
+		for _, setting := range notifySettings {
+			state := "on"
+			if !s.notifies(client.name.get(), setting) {
+				state = "off"
+			}
+			lines = append(lines, setting+": "+state)
+		}
+		s.mu.Unlock()
+		s.reply(client, strings.Join(lines, "\n"))
+
+	case len(args) == 1 && args[0] == "read":
+		held := s.takeHeld(client)
+		if len(held) == 0 {
+			s.reply(client, "no direct messages are waiting")
+		}
+		for _, message := range held {
+			s.reply(client, message)
+		}
+
+	case len(args) == 2 && slices.Contains(notifySettings, args[0]) && (args[1] == "on" || args[1] == "off"):
+		if err := s.setNotify(client.name.get(), args[0], args[1] == "on"); err != nil {
+			fmt.Println("Error saving server state:", err)
+		}
+		s.reply(client, args[0]+" "+args[1])
+
+	default:
+		s.replyUsage(client, "/notify")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Test that /notify turns off join notices, mention bells and direct
+// message previews for one user only, and that the settings are saved
+func TestNotifySettings(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	server := newTestServer(t, cfg)
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	carol, carolOutput := pipeClient(t, "Carol", "192.168.1.3")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+	server.addClient(carol)
+
+	server.handleCommand(b, "/notify joins off")
+	server.handleCommand(b, "/notify bells off")
+	server.handleCommand(b, "/notify previews off")
+
+	dave := mockClient("Dave", "192.168.1.4", nil)
+	text, _ := server.notice("join", &dave)
+	server.messageClients(dave, "\n"+text, "")
+	if containsSubstring(bobOutput(), "Dave") {
+		t.Errorf("Expected Bob not to see the join notice, got %q", bobOutput())
+	}
+	if !containsSubstring(carolOutput(), text) {
+		t.Errorf("Expected Carol to see the join notice, got %q", carolOutput())
+	}
+
+	server.messageClients(*a, "\n[01-01-2025 10:00:00][Alice]:hi @Bob", "")
+	if containsSubstring(bobOutput(), bell) || !containsSubstring(bobOutput(), "hi @Bob") {
+		t.Errorf("Expected the mention without a bell, got %q", bobOutput())
+	}
+
+	server.sendDirect(a, []string{"Bob"}, "the secret plan")
+	if containsSubstring(bobOutput(), "the secret plan") || !containsSubstring(bobOutput(), "[DM from Alice]: new message") {
+		t.Errorf("Expected only the sender of the direct message, got %q", bobOutput())
+	}
+	server.handleCommand(b, "/notify read")
+	if !containsSubstring(bobOutput(), "the secret plan") {
+		t.Errorf("Expected /notify read to show the held message, got %q", bobOutput())
+	}
+
+	restarted := newTestServer(t, cfg)
+	if err := restarted.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.notifies("Bob", notifyJoins) || !restarted.notifies("Carol", notifyJoins) {
+		t.Errorf("Expected Bob's settings to be restored, got %v", restarted.state.Muted)
+	}
+}
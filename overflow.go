@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Overflow policies for a chat message sent while Config.MaxDeliveries
+// messages are already being broadcast.
+const (
+	// overflowRefuse tells the sender the message was not sent.
+	overflowRefuse = "refuse"
+	// overflowWait waits up to Config.DeliveryWait for a slot before
+	// refusing.
+	overflowWait = "wait"
+	// overflowDrop drops the message without telling the sender, counting
+	// it in the metrics.
+	overflowDrop = "drop"
+	// overflowDisconnect disconnects the sender, on the grounds that
+	// whoever floods a busy server is the problem.
+	overflowDisconnect = "disconnect"
+)
+
+var overflowPolicies = []string{overflowRefuse, overflowWait, overflowDrop, overflowDisconnect}
+
+// admitDelivery takes a delivery slot for a chat message from client,
+// applying Config.DeliveryOverflow when there is none, and reports whether
+// the message may be broadcast. The caller must release the slot.
+func (s *Server) admitDelivery(client *Client) bool {
+	limit := s.config.MaxDeliveries
+	switch s.config.DeliveryOverflow {
+	case overflowWait:
+		if s.deliveries.wait(limit, time.Duration(s.config.DeliveryWait)) {
+			return true
+		}
+	case overflowDrop:
+		if s.deliveries.acquire(limit) {
+			return true
+		}
+		s.droppedMessages.Add(1)
+		return false
+	case overflowDisconnect:
+		if s.deliveries.acquire(limit) {
+			return true
+		}
+		fmt.Printf("%s Disconnecting %s for sending while the server is busy\n", client.connID, client.name.get())
+		s.replyError(client, newClientError(ErrCodeBusy, "Server is busy, disconnecting."))
+		s.closeClient(client)
+		return false
+	default:
+		if s.deliveries.acquire(limit) {
+			return true
+		}
+	}
+	s.replyError(client, newClientError(ErrCodeBusy, "Server is busy, message not sent."))
+	return false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test each policy for a message sent while every delivery slot is taken
+func TestDeliveryOverflow(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.config.MaxDeliveries = 1
+	server.config.DeliveryWait = Duration(time.Second)
+	server.deliveries.acquire(1)
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+
+	server.config.DeliveryOverflow = overflowRefuse
+	if server.admitDelivery(a) || !containsSubstring(output(), ErrCodeBusy+": Server is busy, message not sent.") {
+		t.Errorf("Expected the message to be refused, got %q", output())
+	}
+
+	server.config.DeliveryOverflow = overflowDrop
+	before := output()
+	if server.admitDelivery(a) || output() != before || server.droppedMessages.Load() != 1 {
+		t.Errorf("Expected the message to be dropped silently and counted, got %q", strings.TrimPrefix(output(), before))
+	}
+
+	server.config.DeliveryOverflow = overflowWait
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.deliveries.release()
+	}()
+	if !server.admitDelivery(a) {
+		t.Errorf("Expected the message to wait for the free slot.")
+	}
+
+	server.config.DeliveryOverflow = overflowDisconnect
+	if server.admitDelivery(a) || a.closedAt.IsZero() {
+		t.Errorf("Expected the sender to be disconnected.")
+	}
+
+	cfg := DefaultConfig()
+	cfg.DeliveryOverflow = "panic"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected an unknown policy to be refused.")
+	}
+}
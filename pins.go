@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPins is how many messages can be pinned at once.
+const maxPins = 20
+
+// Pin is a message an operator pinned with /pin. It keeps a copy of the
+// message, so it outlives the history.
+type Pin struct {
+	ID       uint64    `json:"id"`
+	Text     string    `json:"text"`
+	PinnedBy string    `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// pins returns the pinned messages, oldest pin first.
+func (s *Server) pins() []Pin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.state.Pins)
+}
+
+// pinList describes the pinned messages, one a line, or is empty if there
+// are none.
+func (s *Server) pinList() string {
+	pins := s.pins()
+	if len(pins) == 0 {
+		return ""
+	}
+	lines := []string{"Pinned:"}
+	for _, p := range pins {
+		lines = append(lines, fmt.Sprintf("#%d %s", p.ID, p.Text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// findMessage returns the stored message ref names: its ID, with or
+// without a "#", or "last" for the newest.
+func (s *Server) findMessage(ref string) (Message, error) {
+	history := s.history.Messages()
+	if ref == "last" {
+		if len(history) == 0 {
+			return Message{}, newClientError(ErrCodeNotFound, "no messages")
+		}
+		return history[len(history)-1], nil
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(ref, "#"), 10, 64)
+	if err != nil {
+		return Message{}, newClientError(ErrCodeInvalidArgument, "not a message ID: "+ref)
+	}
+	for _, m := range history {
+		if m.id == id {
+			return m, nil
+		}
+	}
+	return Message{}, newClientError(ErrCodeNotFound, fmt.Sprintf("no message #%d in the history", id))
+}
+
+// pin pins m, saves it and tells everyone. It returns a ClientError if m
+// cannot be pinned, and any other error if saving failed.
+func (s *Server) pin(by *Client, m Message) error {
+	p := Pin{ID: m.id, Text: strings.TrimPrefix(string(m.payload), "\n"), PinnedBy: by.name.get(), PinnedAt: time.Now()}
+
+	s.mu.Lock()
+	switch {
+	case slices.ContainsFunc(s.state.Pins, func(old Pin) bool { return old.ID == p.ID }):
+		s.mu.Unlock()
+		return newClientError(ErrCodeConflict, fmt.Sprintf("#%d is already pinned", p.ID))
+	case len(s.state.Pins) >= maxPins:
+		s.mu.Unlock()
+		return newClientError(ErrCodeConflict, fmt.Sprintf("at most %d messages can be pinned, /unpin one first", maxPins))
+	}
+	s.state.Pins = append(s.state.Pins, p)
+	s.mu.Unlock()
+
+	s.announce(fmt.Sprintf("%s pinned #%d %s", by.name.get(), p.ID, p.Text), by)
+	return s.saveState()
+}
+
+// unpin removes the pin for message id, saves it and tells everyone, with
+// errors as for pin.
+func (s *Server) unpin(by *Client, id uint64) error {
+	s.mu.Lock()
+	n := len(s.state.Pins)
+	s.state.Pins = slices.DeleteFunc(s.state.Pins, func(p Pin) bool { return p.ID == id })
+	found := len(s.state.Pins) < n
+	s.mu.Unlock()
+	if !found {
+		return newClientError(ErrCodeNotFound, fmt.Sprintf("#%d is not pinned", id))
+	}
+
+	s.announce(fmt.Sprintf("%s unpinned #%d", by.name.get(), id), by)
+	return s.saveState()
+}
+
+// pinResult tells client why pinning failed, or logs why saving it did.
+func (s *Server) pinResult(client *Client, err error) {
+	var problem *ClientError
+	switch {
+	case errors.As(err, &problem):
+		s.replyError(client, problem)
+	case err != nil:
+		fmt.Println("Error saving server state:", err)
+	}
+}
+
+func cmdPin(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/pin")
+		return
+	}
+	m, err := s.findMessage(args[0])
+	if err != nil {
+		s.replyError(client, err)
+		return
+	}
+	s.pinResult(client, s.pin(client, m))
+}
+
+func cmdUnpin(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/unpin")
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(args[0], "#"), 10, 64)
+	if err != nil {
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, "not a message ID: "+args[0]))
+		return
+	}
+	s.pinResult(client, s.unpin(client, id))
+}
+
+func cmdPins(s *Server, client *Client, args []string) {
+	if list := s.pinList(); list != "" {
+		s.reply(client, list)
+	} else {
+		s.reply(client, "no pinned messages")
+	}
+}
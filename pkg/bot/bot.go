@@ -0,0 +1,209 @@
+// Package bot is a small framework for chat bots built on pkg/client. A bot
+// registers handlers for "!command" messages or for regular expressions,
+// and Run keeps it connected, reconnecting with backoff, while pacing what
+// it sends so it never floods the chat.
+//
+//	b := bot.New("localhost:8989", "dicebot")
+//	b.Command("roll", "roll a six-sided die", func(ctx *bot.Context) {
+//		ctx.Reply(fmt.Sprint(rand.Intn(6) + 1))
+//	})
+//	log.Fatal(b.Run(context.Background()))
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"net-cat/pkg/client"
+)
+
+// CommandPrefix starts a bot command. Lines starting with "/" are taken by
+// the server, so bots answer "!" instead.
+const CommandPrefix = "!"
+
+// Handler responds to a message.
+type Handler func(ctx *Context)
+
+// Context is the message a handler is responding to.
+type Context struct {
+	Message client.Message
+
+	// Args holds the words after the command name for command handlers,
+	// and Match the submatches for pattern handlers.
+	Args  []string
+	Match []string
+
+	bot *Bot
+}
+
+// Reply sends text to the chat, addressed to the sender.
+func (ctx *Context) Reply(text string) error {
+	return ctx.bot.Send(ctx.Message.Name + ": " + text)
+}
+
+type command struct {
+	help    string
+	handler Handler
+}
+
+type pattern struct {
+	re      *regexp.Regexp
+	handler Handler
+}
+
+// Bot is a chat bot. Register its handlers before calling Run.
+type Bot struct {
+	addr string
+	name string
+
+	// Interval is the least time between two messages the bot sends
+	// (default one second); sends wait their turn.
+	Interval time.Duration
+	// MaxBackoff caps the delay between reconnection attempts (default 30
+	// seconds).
+	MaxBackoff time.Duration
+
+	commands map[string]command
+	patterns []pattern
+
+	mu       sync.Mutex
+	conn     *client.Client
+	lastSend time.Time
+}
+
+// New returns a bot that joins the server at addr as name. It answers
+// "!help" with its commands.
+func New(addr, name string) *Bot {
+	b := &Bot{
+		addr:       addr,
+		name:       name,
+		Interval:   time.Second,
+		MaxBackoff: 30 * time.Second,
+		commands:   make(map[string]command),
+	}
+	b.Command("help", "list the commands this bot knows", b.help)
+	return b
+}
+
+// Command registers handler for messages of the form "!name args...".
+func (b *Bot) Command(name, help string, handler Handler) {
+	b.commands[name] = command{help: help, handler: handler}
+}
+
+// Handle registers handler for messages whose text matches expr. It panics
+// if expr is not a valid regular expression.
+func (b *Bot) Handle(expr string, handler Handler) {
+	b.patterns = append(b.patterns, pattern{re: regexp.MustCompile(expr), handler: handler})
+}
+
+// Send posts text to the chat, waiting until Interval has passed since the
+// bot last sent anything.
+func (b *Bot) Send(text string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn == nil {
+		return client.ErrClosed
+	}
+	if wait := time.Until(b.lastSend.Add(b.Interval)); wait > 0 {
+		time.Sleep(wait)
+	}
+	b.lastSend = time.Now()
+	return b.conn.Send(text)
+}
+
+// Run connects the bot and handles messages until ctx is done. Dropped
+// connections are retried with exponential backoff; a refused name is
+// returned as an error.
+func (b *Bot) Run(ctx context.Context) error {
+	backoff := time.Second
+	for {
+		c, err := client.Dial(b.addr, b.name)
+		var nameErr *client.NameError
+		if errors.As(err, &nameErr) {
+			return err
+		}
+		if err == nil {
+			backoff = time.Second
+			b.serve(ctx, c)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, b.MaxBackoff)
+	}
+}
+
+// serve handles messages from c until it disconnects or ctx is done.
+func (b *Bot) serve(ctx context.Context, c *client.Client) {
+	b.mu.Lock()
+	b.conn = c
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.conn = nil
+		b.mu.Unlock()
+		c.Close()
+	}()
+
+	// Messages from before we joined are history being replayed.
+	joined := time.Now().Truncate(time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-c.Messages():
+			if !ok {
+				return
+			}
+			if m, isMessage := ev.(client.Message); isMessage && m.Name != b.name && !m.Time.Before(joined) {
+				b.dispatch(m)
+			}
+		}
+	}
+}
+
+// dispatch runs the handlers matching m. Each runs in its own goroutine so
+// a slow handler cannot hold up the connection.
+func (b *Bot) dispatch(m client.Message) {
+	if rest, ok := strings.CutPrefix(m.Text, CommandPrefix); ok {
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			if cmd, ok := b.commands[fields[0]]; ok {
+				go cmd.handler(&Context{Message: m, Args: fields[1:], bot: b})
+			}
+			return
+		}
+	}
+
+	for _, p := range b.patterns {
+		if match := p.re.FindStringSubmatch(m.Text); match != nil {
+			go p.handler(&Context{Message: m, Match: match, bot: b})
+		}
+	}
+}
+
+func (b *Bot) help(ctx *Context) {
+	names := make([]string, 0, len(b.commands))
+	for name := range b.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s%s - %s", CommandPrefix, name, b.commands[name].help))
+	}
+	ctx.Reply(strings.Join(lines, "; "))
+}
@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that a bot answers commands and patterns but not replayed history
+func TestBotHandlers(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	replies := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		stamp := func(t time.Time) string { return "[" + t.Format("02-01-2006 15:04:05") + "]" }
+		prompt := stamp(time.Now()) + "[bot]:"
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("[ENTER YOUR NAME]:"))
+		reader.ReadString('\n')
+		conn.Write([]byte(stamp(time.Now().Add(-time.Hour)) + "[Alice]:!ping\n\n" + prompt))
+
+		conn.Write([]byte("\n" + stamp(time.Now()) + "[Alice]:!ping\n" + prompt))
+		conn.Write([]byte("\n" + stamp(time.Now()) + "[Bob]:hello bot\n" + prompt))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			replies <- strings.TrimSpace(line)
+			conn.Write([]byte(prompt))
+		}
+	}()
+
+	b := New(ln.Addr().String(), "bot")
+	b.Interval = 10 * time.Millisecond
+	b.Command("ping", "answer pong", func(ctx *Context) { ctx.Reply("pong") })
+	b.Handle(`^hello (\w+)`, func(ctx *Context) { ctx.Reply("hi from " + ctx.Match[1]) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+
+	want := map[string]bool{"Alice: pong": true, "Bob: hi from bot": true}
+	for len(want) > 0 {
+		select {
+		case reply := <-replies:
+			if !want[reply] {
+				t.Errorf("Unexpected reply %q", reply)
+			}
+			delete(want, reply)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %v", want)
+		}
+	}
+
+	select {
+	case reply := <-replies:
+		t.Errorf("Expected the replayed command to be ignored, got %q", reply)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,387 @@
+// Package client connects to a TCP-Chat server on behalf of a program, so
+// Go services can post to and follow the chat without speaking its wire
+// protocol themselves.
+//
+//	c, err := client.Dial("localhost:8989", "deploybot")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer c.Close()
+//	c.Send("deploy finished")
+//	for ev := range c.Messages() {
+//		if m, ok := ev.(client.Message); ok {
+//			fmt.Println(m.Name, "said", m.Text)
+//		}
+//	}
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// namePrompt is what the server sends when it wants a name.
+const namePrompt = "[ENTER YOUR NAME]:"
+
+// timeLayout is how the server formats message times.
+const timeLayout = "02-01-2006 15:04:05"
+
+// handshakeTimeout bounds how long Dial waits to be accepted, so a server
+// that never shows the prompts it expects cannot hang the caller.
+var handshakeTimeout = 30 * time.Second
+
+// ErrClosed is returned by Send after the connection has ended, and by Err
+// after Close.
+var ErrClosed = errors.New("client: connection closed")
+
+var (
+	chatLine  = regexp.MustCompile(`^\[(\d\d-\d\d-\d{4} \d\d:\d\d:\d\d)\]\[([^\]]*)\]:(.*)$`)
+	joinLine  = regexp.MustCompile(`^(.+) has joined our chat\.\.\.(?: \(\d+ users? online\))?$`)
+	leaveLine = regexp.MustCompile(`^(.+) has left our chat\.\.\.$`)
+	errorLine = regexp.MustCompile(`^(ERR_[A-Z_]+): (.*)$`)
+)
+
+// Event is something that happened in the chat: a Message, Join, Leave,
+// ErrorReply, FileOffer, File or Notice.
+type Event interface {
+	event()
+}
+
+// Message is a chat message, including those replayed from the history
+// when joining.
+type Message struct {
+	Time time.Time
+	Name string
+	Text string
+}
+
+// Join reports that a user joined the chat.
+type Join struct {
+	Name string
+}
+
+// Leave reports that a user left the chat.
+type Leave struct {
+	Name string
+}
+
+// ErrorReply is an error the server reported, such as a refused command.
+// Code is one of the server's stable error codes, e.g. "ERR_NO_SUCH_USER".
+type ErrorReply struct {
+	Code string
+	Text string
+}
+
+// Notice is any other line from the server, such as a command reply or a
+// direct message.
+type Notice struct {
+	Text string
+}
+
+func (Message) event()    {}
+func (Join) event()       {}
+func (Leave) event()      {}
+func (ErrorReply) event() {}
+func (Notice) event()     {}
+
+// NameError is returned by Dial when the server refuses the name. Code is
+// the server's error code, such as "ERR_NAME_TAKEN".
+type NameError struct {
+	Name   string
+	Code   string
+	Reason string
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("client: name %q refused: %s", e.Name, e.Reason)
+}
+
+// RefusedError is returned by Dial when the server turns the connection
+// away instead of asking for another name, such as when the chat is full.
+// Code is the server's error code, such as "ERR_SERVER_FULL".
+type RefusedError struct {
+	Code   string
+	Reason string
+}
+
+func (e *RefusedError) Error() string {
+	return fmt.Sprintf("client: refused: %s: %s", e.Code, e.Reason)
+}
+
+// Temporary reports whether dialing again later may succeed: the chat was
+// full, the server was busy or the name was not picked in time. Other
+// refusals, such as ERR_PERMISSION_DENIED, are not worth retrying.
+func (e *RefusedError) Temporary() bool {
+	switch e.Code {
+	case "ERR_SERVER_FULL", "ERR_BUSY", "ERR_TIMEOUT":
+		return true
+	}
+	return false
+}
+
+// Client is a connection to a chat server.
+type Client struct {
+	conn   net.Conn
+	name   string
+	method string
+	prompt *regexp.Regexp
+	events chan Event
+
+	// ready is signalled whenever the server has written our prompt and
+	// is waiting for a line.
+	ready  chan struct{}
+	sendMu sync.Mutex
+
+	done    chan struct{}
+	errMu   sync.Mutex
+	err     error
+	closing sync.Once
+
+	files files
+}
+
+// Dial connects to the server at addr and joins as name. It returns once
+// the server has accepted the name, or with an error if that takes longer
+// than 30 seconds.
+func Dial(addr, name string) (*Client, error) {
+	return DialCompressed(addr, name, "")
+}
+
+// DialCompressed is like Dial, but asks the server to compress the
+// connection with method, "deflate" or "gzip", which saves bandwidth for
+// busy bots and bridges. An empty method leaves it uncompressed.
+func DialCompressed(addr, name, method string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:   conn,
+		name:   name,
+		method: method,
+		prompt: regexp.MustCompile(`\[\d\d-\d\d-\d{4} \d\d:\d\d:\d\d\]\[` + regexp.QuoteMeta(name) + `\]:`),
+		events: make(chan Event, 64),
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	joined := make(chan error, 1)
+	go c.receive(joined)
+	if err := <-joined; err != nil {
+		conn.Close()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = fmt.Errorf("client: not accepted within %s: %w", handshakeTimeout, err)
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// Name returns the name the client joined with.
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Messages returns the channel events are delivered on. It is closed when
+// the connection ends; Err then reports why. Events are buffered, but a
+// reader that falls far behind stalls the connection.
+func (c *Client) Messages() <-chan Event {
+	return c.events
+}
+
+// Send posts text to the chat. Lines starting with "/" are commands, whose
+// replies arrive as Notices. Text must be a single line.
+func (c *Client) Send(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("client: empty message")
+	}
+	if strings.ContainsAny(text, "\r\n") {
+		return errors.New("client: message contains a line break")
+	}
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	// The server reads whatever has arrived as one message, so wait until
+	// it is ready for the next one.
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+	select {
+	case <-c.ready:
+	case <-c.done:
+		return ErrClosed
+	}
+	_, err := fmt.Fprintf(c.conn, "%s\n", text)
+	return err
+}
+
+// Close disconnects from the server.
+func (c *Client) Close() error {
+	c.finish(ErrClosed)
+	return c.conn.Close()
+}
+
+// Err returns why the connection ended, or nil while it is open.
+func (c *Client) Err() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+func (c *Client) finish(err error) {
+	c.closing.Do(func() {
+		c.errMu.Lock()
+		c.err = err
+		c.errMu.Unlock()
+		close(c.done)
+	})
+}
+
+// receive reads from the server until the connection ends. It reports the
+// outcome of the name handshake on joined.
+func (c *Client) receive(joined chan<- error) {
+	defer close(c.events)
+
+	reader := bufio.NewReader(c.conn)
+	var pending strings.Builder
+	// early holds the lines between sending our name and being accepted:
+	// the history replay, or why the name was refused.
+	var early []string
+	named, accepted := false, false
+	// refused is the last error line before being accepted, which says
+	// why if the server then closes the connection.
+	var refused *RefusedError
+	// negotiating is set while waiting for the server to agree to
+	// compression, and compressed once it has.
+	negotiating, compressed := false, false
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if !accepted {
+				if refused != nil {
+					err = refused
+				}
+				joined <- err
+			}
+			c.finish(err)
+			return
+		}
+
+		if b == '\n' {
+			if negotiating {
+				if pending.String() != "compression: "+c.method {
+					err := fmt.Errorf("client: compression refused: %s", pending.String())
+					joined <- err
+					c.finish(err)
+					return
+				}
+				conn, err := newCompressedConn(c.conn, c.method)
+				if err != nil {
+					joined <- err
+					c.finish(err)
+					return
+				}
+				negotiating, compressed = false, true
+				c.conn = conn
+				reader = newDecompressingReader(reader, c.method)
+				pending.Reset()
+				continue
+			}
+			switch {
+			case accepted:
+				c.dispatch(pending.String())
+			case named:
+				early = append(early, pending.String())
+			}
+			if m := errorLine.FindStringSubmatch(pending.String()); m != nil && !accepted {
+				refused = &RefusedError{Code: m[1], Reason: m[2]}
+			}
+			pending.Reset()
+			continue
+		}
+
+		pending.WriteByte(b)
+		text := pending.String()
+		switch {
+		case strings.HasSuffix(text, namePrompt) && c.method != "" && !negotiating && !compressed:
+			negotiating = true
+			pending.Reset()
+			fmt.Fprintf(c.conn, "/compress %s\n", c.method)
+		case strings.HasSuffix(text, namePrompt):
+			if named {
+				reason := strings.TrimSuffix(text, namePrompt)
+				for i := len(early) - 1; i >= 0 && strings.TrimSpace(reason) == ""; i-- {
+					reason = early[i]
+				}
+				err := &NameError{Name: c.name, Reason: strings.TrimSpace(reason)}
+				if m := errorLine.FindStringSubmatch(err.Reason); m != nil {
+					err.Code, err.Reason = m[1], m[2]
+				}
+				joined <- err
+				c.finish(err)
+				return
+			}
+			named = true
+			pending.Reset()
+			fmt.Fprintf(c.conn, "%s\n", c.name)
+		case b == ':' && strings.HasSuffix(text, "]["+c.name+"]:") && c.prompt.MatchString(text):
+			if !accepted {
+				accepted = true
+				joined <- nil
+				for _, line := range append(early, text) {
+					c.dispatch(line)
+				}
+				early = nil
+				pending.Reset()
+			}
+			select {
+			case c.ready <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// dispatch turns a line from the server into an event.
+func (c *Client) dispatch(line string) {
+	line = strings.ReplaceAll(c.prompt.ReplaceAllString(line, ""), "\a", "")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	var ev Event = Notice{Text: line}
+	if fileEv, ok := c.fileEvent(line); ok {
+		if fileEv == nil {
+			return
+		}
+		ev = fileEv
+	} else if m := chatLine.FindStringSubmatch(line); m != nil {
+		if t, err := time.ParseInLocation(timeLayout, m[1], time.Local); err == nil {
+			ev = Message{Time: t, Name: m[2], Text: m[3]}
+		}
+	} else if m := joinLine.FindStringSubmatch(line); m != nil {
+		ev = Join{Name: m[1]}
+	} else if m := leaveLine.FindStringSubmatch(line); m != nil {
+		ev = Leave{Name: m[1]}
+	} else if m := errorLine.FindStringSubmatch(line); m != nil {
+		ev = ErrorReply{Code: m[1], Text: m[2]}
+	}
+
+	select {
+	case c.events <- ev:
+	case <-c.done:
+	}
+}
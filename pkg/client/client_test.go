@@ -0,0 +1,158 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer accepts one connection and speaks the chat protocol: it
+// refuses the name "taken", turns "full" away as if the chat were full,
+// replays one message, then answers every line with a message from Bob
+// followed by a join notice.
+func fakeServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tf := "[" + time.Now().Format(timeLayout) + "]"
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("Welcome to TCP-Chat!\n" + namePrompt))
+		name, _ := reader.ReadString('\n')
+		name = strings.TrimSpace(name)
+		if name == "taken" {
+			conn.Write([]byte("ERR_NAME_TAKEN: Name taken is already taken.\n" + namePrompt))
+			return
+		}
+		if name == "full" {
+			conn.Write([]byte("ERR_SERVER_FULL: Chat is full (10 users), please try again later.\n"))
+			return
+		}
+
+		prompt := tf + "[" + name + "]:"
+		conn.Write([]byte(tf + "[Alice]:earlier\n\n" + prompt))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("\n" + tf + "[Bob]:got " + strings.TrimSpace(line) + "\n" + prompt))
+			conn.Write([]byte("\nCarol has joined our chat... (3 users online)\n" + prompt))
+			conn.Write([]byte("ERR_NO_SUCH_USER: no such user Dave\n"))
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func next(t *testing.T, c *Client) Event {
+	t.Helper()
+	select {
+	case ev := <-c.Messages():
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for an event.")
+		return nil
+	}
+}
+
+// Test that Dial gives up on a server that never accepts the name
+func TestDialHandshakeTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte(namePrompt))
+		reader.ReadString('\n')
+		// A reworded prompt the client cannot recognise.
+		conn.Write([]byte("bot> "))
+		reader.ReadString('\n')
+	}()
+
+	defer func(d time.Duration) { handshakeTimeout = d }(handshakeTimeout)
+	handshakeTimeout = 200 * time.Millisecond
+	start := time.Now()
+	if _, err := Dial(ln.Addr().String(), "bot"); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected the handshake to time out, got %v", err)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("Expected Dial to give up promptly, waited %s", waited)
+	}
+}
+
+// Test that a client joins, sees the replay and exchanges typed events
+func TestDialSendReceive(t *testing.T) {
+	c, err := Dial(fakeServer(t), "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if m, ok := next(t, c).(Message); !ok || m.Name != "Alice" || m.Text != "earlier" {
+		t.Errorf("Expected the replayed message from Alice, got %#v", m)
+	}
+
+	if err := c.Send("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := next(t, c).(Message); !ok || m.Name != "Bob" || m.Text != "got hello" {
+		t.Errorf("Expected Bob's answer, got %#v", m)
+	}
+	if j, ok := next(t, c).(Join); !ok || j.Name != "Carol" {
+		t.Errorf("Expected Carol to join, got %#v", j)
+	}
+	if e, ok := next(t, c).(ErrorReply); !ok || e.Code != "ERR_NO_SUCH_USER" {
+		t.Errorf("Expected an error reply, got %#v", e)
+	}
+
+	c.Close()
+	if err := c.Send("too late"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
+
+// Test that a refused name is reported as a NameError
+func TestDialNameRefused(t *testing.T) {
+	_, err := Dial(fakeServer(t), "taken")
+
+	var nameErr *NameError
+	if !errors.As(err, &nameErr) || nameErr.Code != "ERR_NAME_TAKEN" || nameErr.Reason != "Name taken is already taken." {
+		t.Errorf("Expected a NameError with the reason, got %v", err)
+	}
+}
+
+// Test that a connection turned away during the handshake is reported as a
+// RefusedError, with whether it is worth trying again
+func TestDialRefused(t *testing.T) {
+	_, err := Dial(fakeServer(t), "full")
+
+	var refused *RefusedError
+	if !errors.As(err, &refused) || refused.Code != "ERR_SERVER_FULL" || !refused.Temporary() {
+		t.Errorf("Expected a temporary RefusedError, got %v", err)
+	}
+	if (&RefusedError{Code: "ERR_PERMISSION_DENIED"}).Temporary() {
+		t.Errorf("Expected ERR_PERMISSION_DENIED not to be worth retrying")
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// compressedConn compresses what is written to a connection, flushing
+// after every write.
+type compressedConn struct {
+	net.Conn
+
+	mu sync.Mutex
+	w  interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func newCompressedConn(conn net.Conn, method string) (*compressedConn, error) {
+	switch method {
+	case "deflate":
+		w, err := flate.NewWriter(conn, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		return &compressedConn{Conn: conn, w: w}, nil
+	case "gzip":
+		return &compressedConn{Conn: conn, w: gzip.NewWriter(conn)}, nil
+	}
+	return nil, fmt.Errorf("client: unsupported compression %q", method)
+}
+
+func (c *compressedConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+func (c *compressedConn) Close() error {
+	c.mu.Lock()
+	c.w.Close()
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// decompressor reads the server's compressed stream from src. It is opened
+// on the first read, since a gzip reader blocks until the header arrives.
+type decompressor struct {
+	src    io.Reader
+	method string
+	r      io.Reader
+}
+
+func (d *decompressor) Read(p []byte) (int, error) {
+	if d.r == nil {
+		if d.method == "gzip" {
+			r, err := gzip.NewReader(d.src)
+			if err != nil {
+				return 0, err
+			}
+			d.r = r
+		} else {
+			d.r = flate.NewReader(d.src)
+		}
+	}
+	return d.r.Read(p)
+}
+
+// newDecompressingReader returns a reader of the decompressed stream that
+// follows what reader has returned so far.
+func newDecompressingReader(reader *bufio.Reader, method string) *bufio.Reader {
+	return bufio.NewReader(&decompressor{src: reader, method: method})
+}
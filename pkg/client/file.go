@@ -0,0 +1,175 @@
+package client
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// FileChunkSize is how many bytes of a file go in each line sent to the
+// server. Encoded, a chunk fits the server's default message size.
+const FileChunkSize = 1024
+
+var (
+	fileOfferLine   = regexp.MustCompile(`^file offer (\d+) from (.+?): (.+) \((\d+) bytes\), /accept \d+ or /reject \d+$`)
+	fileOfferedLine = regexp.MustCompile(`^file offer (\d+) sent to .+?: (.+) \((\d+) bytes\)$`)
+	fileDataLine    = regexp.MustCompile(`^file data (\d+) ([A-Za-z0-9+/=]+)$`)
+	fileDoneLine    = regexp.MustCompile(`^file done (\d+): `)
+	fileAcceptLine  = regexp.MustCompile(`^file accepted (\d+): `)
+	fileEndLine     = regexp.MustCompile(`^file (?:rejected|cancelled) (\d+): `)
+)
+
+// FileOffer reports that a user offered us a file. Answer it with Accept
+// or Reject.
+type FileOffer struct {
+	ID   uint64
+	From string
+	Name string
+	Size int64
+}
+
+// File is a file received after accepting its offer.
+type File struct {
+	ID   uint64
+	From string
+	Name string
+	Data []byte
+}
+
+func (FileOffer) event() {}
+func (File) event()      {}
+
+// files tracks the files being sent and received.
+type files struct {
+	mu sync.Mutex
+	// offered holds the files offered with SendFile until the server says
+	// which transfer they are, keyed by name and size.
+	offered map[string][][]byte
+	// sending holds the files waiting to be accepted or being sent, and
+	// receiving those being received, by transfer.
+	sending   map[uint64][]byte
+	receiving map[uint64]*File
+}
+
+func offerKey(name string, size int) string {
+	return fmt.Sprintf("%s\x00%d", name, size)
+}
+
+// SendFile offers data to the user to as a file called name, which should
+// be a base name such as "notes.txt". It returns once the offer is sent;
+// the file is sent if they accept it, and a Notice reports the outcome.
+func (c *Client) SendFile(to, name string, data []byte) error {
+	if len(data) == 0 {
+		return errors.New("client: empty file")
+	}
+
+	c.files.mu.Lock()
+	if c.files.offered == nil {
+		c.files.offered = make(map[string][][]byte)
+	}
+	key := offerKey(name, len(data))
+	c.files.offered[key] = append(c.files.offered[key], data)
+	c.files.mu.Unlock()
+
+	return c.Send(fmt.Sprintf("/sendfile %s %d %s", to, len(data), name))
+}
+
+// Accept accepts the file offered as id. It arrives as a File event.
+func (c *Client) Accept(id uint64) error {
+	return c.Send(fmt.Sprintf("/accept %d", id))
+}
+
+// Reject turns down the file offered as id.
+func (c *Client) Reject(id uint64) error {
+	return c.Send(fmt.Sprintf("/reject %d", id))
+}
+
+// fileEvent handles a file transfer line from the server. It reports
+// whether line was one, with the event to deliver, if any.
+func (c *Client) fileEvent(line string) (Event, bool) {
+	f := &c.files
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if m := fileDataLine.FindStringSubmatch(line); m != nil {
+		id, _ := strconv.ParseUint(m[1], 10, 64)
+		if file := f.receiving[id]; file != nil {
+			data, err := base64.StdEncoding.DecodeString(m[2])
+			if err == nil {
+				file.Data = append(file.Data, data...)
+			}
+		}
+		return nil, true
+	}
+	if m := fileOfferLine.FindStringSubmatch(line); m != nil {
+		id, _ := strconv.ParseUint(m[1], 10, 64)
+		size, _ := strconv.ParseInt(m[4], 10, 64)
+		if f.receiving == nil {
+			f.receiving = make(map[uint64]*File)
+		}
+		f.receiving[id] = &File{ID: id, From: m[2], Name: m[3]}
+		return FileOffer{ID: id, From: m[2], Name: m[3], Size: size}, true
+	}
+	if m := fileDoneLine.FindStringSubmatch(line); m != nil {
+		id, _ := strconv.ParseUint(m[1], 10, 64)
+		if file := f.receiving[id]; file != nil {
+			delete(f.receiving, id)
+			return *file, true
+		}
+	}
+	if m := fileOfferedLine.FindStringSubmatch(line); m != nil {
+		id, _ := strconv.ParseUint(m[1], 10, 64)
+		size, _ := strconv.Atoi(m[3])
+		key := offerKey(m[2], size)
+		if queued := f.offered[key]; len(queued) > 0 {
+			if f.sending == nil {
+				f.sending = make(map[uint64][]byte)
+			}
+			f.sending[id] = queued[0]
+			if f.offered[key] = queued[1:]; len(queued) == 1 {
+				delete(f.offered, key)
+			}
+		}
+	}
+	if m := fileAcceptLine.FindStringSubmatch(line); m != nil {
+		id, _ := strconv.ParseUint(m[1], 10, 64)
+		if data, ok := f.sending[id]; ok {
+			// Send waits for the prompt, which only this goroutine can
+			// see, so the file is sent from another.
+			go c.streamFile(id, data)
+		}
+	}
+	if m := fileEndLine.FindStringSubmatch(line); m != nil {
+		id, _ := strconv.ParseUint(m[1], 10, 64)
+		delete(f.sending, id)
+		delete(f.receiving, id)
+	}
+	return nil, false
+}
+
+// streamFile sends the accepted transfer id in chunks, stopping early if
+// it is cancelled.
+func (c *Client) streamFile(id uint64, data []byte) {
+	for len(data) > 0 {
+		c.files.mu.Lock()
+		_, ok := c.files.sending[id]
+		c.files.mu.Unlock()
+		if !ok {
+			return
+		}
+
+		n := min(len(data), FileChunkSize)
+		if c.Send(fmt.Sprintf("/filedata %d %s", id, base64.StdEncoding.EncodeToString(data[:n]))) != nil {
+			return
+		}
+		data = data[n:]
+	}
+
+	c.files.mu.Lock()
+	delete(c.files.sending, id)
+	c.files.mu.Unlock()
+	c.Send(fmt.Sprintf("/filedata %d", id))
+}
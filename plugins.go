@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// commandPlugins maps a plugin name to the commands it adds. Plugins
+// register themselves from init, but their commands are only available on
+// servers that list the plugin in Config.Plugins.
+var commandPlugins = map[string]map[string]command{}
+
+// registerPlugin makes the commands in cmds available under the plugin
+// name. It panics if a command clashes with a built-in or another plugin.
+func registerPlugin(name string, cmds map[string]command) {
+	for cmd := range cmds {
+		if _, ok := commands[cmd]; ok {
+			panic("plugin " + name + " redefines built-in command " + cmd)
+		}
+		for other, otherCmds := range commandPlugins {
+			if _, ok := otherCmds[cmd]; ok {
+				panic("plugin " + name + " redefines " + cmd + " from plugin " + other)
+			}
+		}
+	}
+	commandPlugins[name] = cmds
+}
+
+// checkPlugins reports an error for any plugin name that is not registered.
+func checkPlugins(names []string) error {
+	for _, name := range names {
+		if _, ok := commandPlugins[name]; !ok {
+			known := slices.Sorted(maps.Keys(commandPlugins))
+			return fmt.Errorf("unknown plugin %q, have %s", name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// lookupCommand finds a built-in command, one from a plugin enabled on
+// this server, or a text command from its config.
+func (s *Server) lookupCommand(name string) (command, bool) {
+	if cmd, ok := commands[name]; ok {
+		return cmd, true
+	}
+	for _, plugin := range s.config.Plugins {
+		if cmd, ok := commandPlugins[plugin][name]; ok {
+			return cmd, true
+		}
+	}
+	if t, ok := s.config.TextCommands[name]; ok {
+		return t.command(name), true
+	}
+	return command{}, false
+}
+
+// availableCommands returns every command a server with cfg offers.
+func availableCommands(cfg Config) map[string]command {
+	available := maps.Clone(commands)
+	for _, plugin := range cfg.Plugins {
+		maps.Copy(available, commandPlugins[plugin])
+	}
+	for name, t := range cfg.TextCommands {
+		available[name] = t.command(name)
+	}
+	return available
+}
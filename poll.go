@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pollTimeout is how long a poll stays open unless it is ended early with
+// /endpoll.
+const pollTimeout = 5 * time.Minute
+
+// poll is a question put to the chat. Only one runs at a time.
+type poll struct {
+	question string
+	options  []string
+	creator  string
+
+	// votes maps each voter to the index of the option they chose.
+	votes map[string]int
+	timer *time.Timer
+}
+
+// tally describes the votes cast so far, most popular option first.
+func (p *poll) tally() string {
+	counts := make([]int, len(p.options))
+	for _, choice := range p.votes {
+		counts[choice]++
+	}
+
+	parts := make([]string, len(p.options))
+	best := 0
+	for i, option := range p.options {
+		parts[i] = fmt.Sprintf("%d. %s (%d)", i+1, option, counts[i])
+		best = max(best, counts[i])
+	}
+	result := strings.Join(parts, ", ")
+
+	var winners []string
+	for i, n := range counts {
+		if n == best && best > 0 {
+			winners = append(winners, p.options[i])
+		}
+	}
+	switch len(winners) {
+	case 0:
+		return result + "; no votes"
+	case 1:
+		return result + "; " + winners[0] + " leads"
+	default:
+		return result + "; tie between " + strings.Join(winners, " and ")
+	}
+}
+
+// startPoll opens a poll asked by client, unless one is already running.
+func (s *Server) startPoll(client *Client, question string, options []string) error {
+	s.mu.Lock()
+	if s.poll != nil {
+		s.mu.Unlock()
+		return newClientError(ErrCodeConflict, "a poll is already running: "+s.pollQuestion())
+	}
+	p := &poll{question: question, options: options, creator: client.name.get(), votes: make(map[string]int)}
+	p.timer = time.AfterFunc(pollTimeout, func() { s.endPoll(p) })
+	s.poll = p
+	s.mu.Unlock()
+
+	var choices []string
+	for i, option := range options {
+		choices = append(choices, fmt.Sprintf("%d. %s", i+1, option))
+	}
+	s.announce(fmt.Sprintf("%s started a poll: %s %s. Answer with /vote <number>; it closes in %s.",
+		client.name.get(), question, strings.Join(choices, ", "), pollTimeout), client)
+	return nil
+}
+
+// pollQuestion returns the running poll's question. The caller must hold
+// s.mu.
+func (s *Server) pollQuestion() string {
+	return `"` + s.poll.question + `"`
+}
+
+// vote records name's choice in the running poll, replacing any earlier
+// vote. choice is an option's number or its text.
+func (s *Server) vote(name, choice string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.poll
+	if p == nil {
+		return "", newClientError(ErrCodeNotFound, "there is no poll running")
+	}
+	index := -1
+	if n, err := strconv.Atoi(choice); err == nil && n >= 1 && n <= len(p.options) {
+		index = n - 1
+	}
+	for i, option := range p.options {
+		if strings.EqualFold(option, choice) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return "", newClientError(ErrCodeInvalidArgument, fmt.Sprintf("%q is not an option in the poll", choice))
+	}
+	p.votes[name] = index
+	return p.options[index], nil
+}
+
+// endPoll closes p, if it is still running, and announces the result.
+func (s *Server) endPoll(p *poll) {
+	s.mu.Lock()
+	if s.poll != p {
+		s.mu.Unlock()
+		return
+	}
+	s.poll = nil
+	p.timer.Stop()
+	s.mu.Unlock()
+
+	s.announce(`Poll "`+p.question+`" closed: `+p.tally(), nil)
+}
+
+func cmdPoll(s *Server, client *Client, args []string) {
+	if len(args) == 0 {
+		s.mu.Lock()
+		p := s.poll
+		var status string
+		if p != nil {
+			status = "Poll " + s.pollQuestion() + " by " + p.creator + ": " + p.tally()
+		}
+		s.mu.Unlock()
+		if p == nil {
+			status = "there is no poll running"
+		}
+		s.reply(client, status)
+		return
+	}
+
+	if len(args) < 3 {
+		s.replyUsage(client, "/poll")
+		return
+	}
+	if !s.mayPost(client) {
+		return
+	}
+	if err := s.startPoll(client, args[0], args[1:]); err != nil {
+		s.replyError(client, err)
+	}
+}
+
+func cmdVote(s *Server, client *Client, args []string) {
+	if len(args) == 0 {
+		s.replyUsage(client, "/vote")
+		return
+	}
+	option, err := s.vote(client.name.get(), strings.Join(args, " "))
+	if err != nil {
+		s.replyError(client, err)
+		return
+	}
+	s.reply(client, "you voted for "+option)
+}
+
+func cmdEndPoll(s *Server, client *Client, args []string) {
+	s.mu.Lock()
+	p := s.poll
+	allowed := p != nil && (p.creator == client.name.get() || client.operator)
+	s.mu.Unlock()
+
+	switch {
+	case p == nil:
+		s.replyError(client, newClientError(ErrCodeNotFound, "there is no poll running"))
+	case !allowed:
+		s.replyError(client, newClientError(ErrCodePermissionDenied, "only "+p.creator+" or an operator can end this poll"))
+	default:
+		s.reply(client, "poll ended")
+		s.endPoll(p)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that a poll collects votes and announces the result when ended
+func TestPoll(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	carol, _ := pipeClient(t, "Carol", "192.168.1.3")
+	server.addClient(alice)
+	server.addClient(bob)
+	server.addClient(carol)
+
+	server.handleCommand(&alice, `/poll "lunch today?" pizza "sushi rolls"`)
+	if !containsSubstring(bobOutput(), "Alice started a poll: lunch today? 1. pizza, 2. sushi rolls") {
+		t.Fatalf("Expected Bob to see the poll, got %q", bobOutput())
+	}
+
+	server.handleCommand(&bob, "/vote 1")
+	server.handleCommand(&carol, "/vote pizza")
+	server.handleCommand(&carol, "/vote sushi rolls")
+	server.handleCommand(&alice, "/vote 3")
+	if !containsSubstring(aliceOutput(), "not an option") {
+		t.Errorf("Expected an invalid vote to be refused, got %q", aliceOutput())
+	}
+
+	server.handleCommand(&bob, "/endpoll")
+	if !containsSubstring(bobOutput(), "only Alice or an operator") {
+		t.Errorf("Expected Bob not to be able to end Alice's poll, got %q", bobOutput())
+	}
+
+	server.handleCommand(&alice, "/endpoll")
+	want := `Poll "lunch today?" closed: 1. pizza (1), 2. sushi rolls (1); tie between pizza and sushi rolls`
+	if !containsSubstring(bobOutput(), want) {
+		t.Errorf("Expected Bob to see %q, got %q", want, bobOutput())
+	}
+
+	server.handleCommand(&bob, "/vote 1")
+	if !containsSubstring(bobOutput(), "there is no poll running") {
+		t.Errorf("Expected votes after the poll closed to be refused")
+	}
+}
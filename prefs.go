@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// userPrefs are the display settings a user chose with /color,
+// /accessible and /width. They are kept in the server state by name, like
+// ignore lists and notification settings, so they are applied again when
+// the user reconnects, even after a restart. Without Config.StateFile they
+// only last until the server stops. A setting left nil was never chosen,
+// and what the client announced when connecting is used instead.
+type userPrefs struct {
+	Color      *bool `json:"color,omitempty"`
+	Accessible *bool `json:"accessible,omitempty"`
+	Width      *int  `json:"width,omitempty"`
+}
+
+// setPref changes name's saved display settings with update and saves
+// them.
+func (s *Server) setPref(name string, update func(*userPrefs)) error {
+	s.mu.Lock()
+	if s.state.Prefs == nil {
+		s.state.Prefs = make(map[string]userPrefs)
+	}
+	prefs := s.state.Prefs[name]
+	update(&prefs)
+	s.state.Prefs[name] = prefs
+	s.mu.Unlock()
+
+	return s.saveState()
+}
+
+// applyPrefs gives client the display settings saved for its name.
+func (s *Server) applyPrefs(client *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefs := s.state.Prefs[client.name.get()]
+	if prefs.Color != nil {
+		client.color = *prefs.Color
+	}
+	if prefs.Accessible != nil {
+		client.accessible = *prefs.Accessible
+	}
+	if prefs.Width != nil {
+		client.width = *prefs.Width
+	}
+}
+
+// describePrefs lists the settings saved for name, for /prefs.
+func (s *Server) describePrefs(name string) string {
+	onOff := func(on bool) string {
+		if on {
+			return "on"
+		}
+		return "off"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefs := s.state.Prefs[name]
+	var saved []string
+	if prefs.Color != nil {
+		saved = append(saved, "color "+onOff(*prefs.Color))
+	}
+	if prefs.Accessible != nil {
+		saved = append(saved, "accessible "+onOff(*prefs.Accessible))
+	}
+	if prefs.Width != nil {
+		if *prefs.Width == 0 {
+			saved = append(saved, "width off")
+		} else {
+			saved = append(saved, fmt.Sprintf("width %d", *prefs.Width))
+		}
+	}
+	if muted := s.state.Muted[name]; len(muted) > 0 {
+		saved = append(saved, "notifications off: "+strings.Join(muted, ", "))
+	}
+	if ignores := s.state.Ignores[name]; len(ignores) > 0 {
+		saved = append(saved, "ignoring: "+strings.Join(ignores, ", "))
+	}
+	if len(saved) == 0 {
+		return "no saved settings"
+	}
+	return "saved settings: " + strings.Join(saved, "; ")
+}
+
+func cmdPrefs(s *Server, client *Client, args []string) {
+	switch {
+	case len(args) == 0:
+		s.reply(client, s.describePrefs(client.name.get()))
+	case len(args) == 1 && args[0] == "reset":
+		s.mu.Lock()
+		delete(s.state.Prefs, client.name.get())
+		s.mu.Unlock()
+		if err := s.saveState(); err != nil {
+			fmt.Println("Error saving server state:", err)
+		}
+		s.reply(client, "display settings forgotten, they apply until you leave")
+	default:
+		s.replyUsage(client, "/prefs")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "sync"
+
+// Deliveries to one client take turns, and while the chat is busy several
+// can be waiting for a slow connection. System notices, such as operator
+// announcements, go ahead of the chat messages waiting with them so they
+// still get through promptly.
+
+// priority orders deliveries waiting for the same client.
+type priority int
+
+const (
+	priorityChat priority = iota
+	prioritySystem
+)
+
+// writeGate lets one delivery at a time write to a client, serving
+// waiting system deliveries before waiting chat ones and each in the order
+// they arrived.
+type writeGate struct {
+	mu      sync.Mutex
+	busy    bool
+	waiting [prioritySystem + 1][]chan struct{}
+}
+
+// lock waits for the gate, behind any waiting delivery of at least prio.
+func (g *writeGate) lock(prio priority) {
+	g.mu.Lock()
+	if !g.busy {
+		g.busy = true
+		g.mu.Unlock()
+		return
+	}
+	turn := make(chan struct{})
+	g.waiting[prio] = append(g.waiting[prio], turn)
+	g.mu.Unlock()
+	<-turn
+}
+
+// unlock hands the gate to the next waiting delivery.
+func (g *writeGate) unlock() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for prio := prioritySystem; prio >= priorityChat; prio-- {
+		if queue := g.waiting[prio]; len(queue) > 0 {
+			g.waiting[prio] = queue[1:]
+			close(queue[0])
+			return
+		}
+	}
+	g.busy = false
+}
+
+// senderPriority is the priority of a broadcast from sender: nameless
+// senders are the server itself.
+func senderPriority(sender Client) priority {
+	if sender.name.get() == "" {
+		return prioritySystem
+	}
+	return priorityChat
+}
+
+// pending returns how many deliveries are waiting for the gate.
+func (g *writeGate) pending() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := 0
+	for _, queue := range g.waiting {
+		n += len(queue)
+	}
+	return n
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that waiting system deliveries go before waiting chat ones, each
+// in the order they arrived
+func TestWriteGatePriority(t *testing.T) {
+	var gate writeGate
+	gate.lock(priorityChat)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	wait := func(name string, prio priority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gate.lock(prio)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			gate.unlock()
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	wait("chat 1", priorityChat)
+	wait("chat 2", priorityChat)
+	wait("system 1", prioritySystem)
+	wait("system 2", prioritySystem)
+
+	gate.unlock()
+	wg.Wait()
+	if want := []string{"system 1", "system 2", "chat 1", "chat 2"}; !slices.Equal(order, want) {
+		t.Errorf("Expected %v, got %v", want, order)
+	}
+	if gate.busy {
+		t.Errorf("Expected the gate to be free again.")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// reverseDNSTimeout bounds a reverse lookup, so a slow resolver only
+	// delays showing a host name, never a client joining.
+	reverseDNSTimeout = 2 * time.Second
+
+	// reverseDNSTTL is how long a lookup, successful or not, is cached.
+	reverseDNSTTL = time.Hour
+
+	// reverseDNSCacheSize bounds the cache; once it is full, expired
+	// entries are dropped, and if none have expired it is emptied.
+	reverseDNSCacheSize = 4096
+)
+
+type hostEntry struct {
+	name    string
+	expires time.Time
+}
+
+// hostCache remembers reverse lookups by IP address.
+type hostCache struct {
+	mu      sync.Mutex
+	entries map[string]hostEntry
+}
+
+func (c *hostCache) get(ip string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[ip]
+	if !ok || time.Now().After(e.expires) {
+		return "", false
+	}
+	return e.name, true
+}
+
+func (c *hostCache) put(ip, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]hostEntry)
+	}
+	if len(c.entries) >= reverseDNSCacheSize {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= reverseDNSCacheSize {
+			clear(c.entries)
+		}
+	}
+	c.entries[ip] = hostEntry{name: name, expires: time.Now().Add(reverseDNSTTL)}
+}
+
+// lookupHost returns the host name addr's IP address resolves to, or "" if
+// it has none or the lookup fails or times out.
+func (s *Server) lookupHost(addr string) string {
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(ip) == nil {
+		return ""
+	}
+	if name, ok := s.hosts.get(ip); ok {
+		return name
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reverseDNSTimeout)
+	defer cancel()
+	var name string
+	if names, err := s.resolver.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	s.hosts.put(ip, name)
+	return name
+}
+
+// resolveHost starts looking up addr when Config.ReverseDNS is set. The
+// returned function waits for the result.
+func (s *Server) resolveHost(addr string) func() string {
+	if !s.config.ReverseDNS {
+		return func() string { return "" }
+	}
+	result := make(chan string, 1)
+	go func() { result <- s.lookupHost(addr) }()
+	return sync.OnceValue(func() string { return <-result })
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// Test that reverse lookups are cached, including failures
+func TestLookupHost(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	var lookups atomic.Int32
+	server.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			lookups.Add(1)
+			return nil, errors.New("no resolver")
+		},
+	}
+
+	server.hosts.put("192.168.1.1", "alice.example.com")
+	if host := server.lookupHost("192.168.1.1:4000"); host != "alice.example.com" {
+		t.Errorf("Expected the cached host name, got %q", host)
+	}
+	if host := server.lookupHost("unix:#1"); host != "" {
+		t.Errorf("Expected no host name for a unix socket, got %q", host)
+	}
+
+	if host := server.lookupHost("192.168.1.2:4000"); host != "" {
+		t.Errorf("Expected a failed lookup to give no host name, got %q", host)
+	}
+	tried := lookups.Load()
+	server.lookupHost("192.168.1.2:4001")
+	if lookups.Load() != tried {
+		t.Errorf("Expected the failed lookup to be cached.")
+	}
+}
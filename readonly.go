@@ -0,0 +1,45 @@
+package main
+
+import "fmt"
+
+// An announcement-only chat, for status or incident updates, is one where
+// only operators can post: everyone else can read, run commands that only
+// answer them and send direct messages, but their chat messages and
+// commands that post to everyone, such as /share or /roll, are refused.
+// Config.AnnounceOnly sets it at startup and /readonly changes it while
+// the server runs.
+
+// mayPost reports whether client may post to the chat, telling it why not
+// if it may not.
+func (s *Server) mayPost(client *Client) bool {
+	if !s.announceOnly.Load() || s.isOperator(client) {
+		return true
+	}
+	s.replyError(client, newClientError(ErrCodePermissionDenied, "the chat is announcement-only, only operators can post"))
+	return false
+}
+
+func cmdReadOnly(s *Server, client *Client, args []string) {
+	switch {
+	case len(args) == 0:
+		if s.announceOnly.Load() {
+			s.reply(client, "the chat is announcement-only")
+		} else {
+			s.reply(client, "everyone can post")
+		}
+	case len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+		on := args[0] == "on"
+		if s.announceOnly.Swap(on) == on {
+			s.reply(client, "no change")
+			return
+		}
+		fmt.Printf("%s %s turned announce_only %s\n", client.connID, client.name.get(), args[0])
+		if on {
+			s.announce(client.name.get()+" made the chat announcement-only: only operators can post", client)
+		} else {
+			s.announce(client.name.get()+" opened the chat: everyone can post again", client)
+		}
+	default:
+		s.replyUsage(client, "/readonly")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Structured clients that announce the "receipts" capability can mark how
+// far they have read with /read, and ask how much is unread with /unread,
+// both answered in JSON. A client's read marker is relayed to those it
+// has exchanged direct messages with, if they announced the capability
+// too, as
+//
+//	{"receipt":{"name":"Bob","read_up_to":42}}
+//
+// Plain text clients never see receipts, and cannot send them.
+
+// Receipt tells a client how far a user it exchanged direct messages with
+// has read.
+type Receipt struct {
+	Name     string `json:"name"`
+	ReadUpTo uint64 `json:"read_up_to"`
+}
+
+// Unread answers /read and /unread: how many messages from others are
+// newer than the read marker, and the ID of the newest message.
+type Unread struct {
+	Unread   int    `json:"unread"`
+	ReadUpTo uint64 `json:"read_up_to"`
+	Latest   uint64 `json:"latest"`
+}
+
+// addPeers records that a and b exchanged direct messages, so their read
+// receipts are relayed to each other. The caller must hold s.mu.
+func addPeers(a, b *Client) {
+	if !slices.Contains(a.peers, b.name.get()) {
+		a.peers = append(a.peers, b.name.get())
+	}
+	if !slices.Contains(b.peers, a.name.get()) {
+		b.peers = append(b.peers, a.name.get())
+	}
+}
+
+// markRead moves client's read marker up to id, which never moves it
+// back, and relays it to its direct message peers.
+func (s *Server) markRead(client *Client, id uint64) {
+	s.mu.Lock()
+	if id <= s.readMarkers[client.name.get()] {
+		s.mu.Unlock()
+		return
+	}
+	if s.readMarkers == nil {
+		s.readMarkers = make(map[string]uint64)
+	}
+	s.readMarkers[client.name.get()] = id
+	peers := slices.Clone(client.peers)
+	s.mu.Unlock()
+
+	data, _ := json.Marshal(struct {
+		Receipt Receipt `json:"receipt"`
+	}{Receipt{Name: client.name.get(), ReadUpTo: id}})
+	for _, name := range peers {
+		peer := s.findClient(name)
+		if peer == nil {
+			continue
+		}
+		s.mu.Lock()
+		wants := peer.receipts && slices.Contains(peer.peers, client.name.get())
+		s.mu.Unlock()
+		if wants {
+			s.frame(peer, string(data))
+		}
+	}
+}
+
+// unread counts the messages from others newer than name's read marker.
+func (s *Server) unread(name string) Unread {
+	s.mu.Lock()
+	u := Unread{ReadUpTo: s.readMarkers[name]}
+	s.mu.Unlock()
+	for _, m := range s.history.Messages() {
+		u.Latest = m.id
+		if m.id > u.ReadUpTo && m.name != name {
+			u.Unread++
+		}
+	}
+	return u
+}
+
+// wantsReceipts reports whether client announced the receipts capability,
+// telling it in JSON if it did not.
+func (s *Server) wantsReceipts(client *Client) bool {
+	s.mu.Lock()
+	receipts := client.receipts
+	s.mu.Unlock()
+	if !receipts {
+		s.replyJSONError(client, newClientError(ErrCodeDisabled, "read receipts need the receipts capability, asked for by answering the name prompt with /caps receipts when connecting"))
+	}
+	return receipts
+}
+
+func (s *Server) replyUnread(client *Client) {
+	data, _ := json.Marshal(s.unread(client.name.get()))
+	s.reply(client, string(data))
+}
+
+func cmdRead(s *Server, client *Client, args []string) {
+	if !s.wantsReceipts(client) {
+		return
+	}
+	if len(args) > 1 {
+		s.replyJSONError(client, newClientError(ErrCodeUsage, "usage: /read [message id]"))
+		return
+	}
+	id := s.unread(client.name.get()).Latest
+	if len(args) == 1 {
+		n, err := strconv.ParseUint(strings.TrimPrefix(args[0], "#"), 10, 64)
+		if err != nil {
+			s.replyJSONError(client, newClientError(ErrCodeInvalidArgument, "not a message ID: "+args[0]))
+			return
+		}
+		id = min(n, id)
+	}
+	s.markRead(client, id)
+	s.replyUnread(client)
+}
+
+func cmdUnread(s *Server, client *Client, args []string) {
+	if !s.wantsReceipts(client) {
+		return
+	}
+	s.replyUnread(client)
+}
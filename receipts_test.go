@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that read markers count unread messages and reach direct message
+// peers that asked for receipts, and nobody else
+func TestReadReceipts(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	carol, carolOutput := pipeClient(t, "Carol", "192.168.1.3")
+	alice.receipts, bob.receipts = true, true
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+	c := server.addClient(carol)
+
+	server.messageClients(*a, "\n[01-01-2025 10:00:00][Alice]:one", "")
+	server.messageClients(*c, "\n[01-01-2025 10:00:01][Carol]:two", "")
+	server.messageClients(*c, "\n[01-01-2025 10:00:02][Carol]:three", "")
+
+	server.handleCommand(a, "/unread")
+	if !containsSubstring(aliceOutput(), `{"unread":2,"read_up_to":0,"latest":3}`) {
+		t.Errorf("Expected two unread messages from others, got %q", aliceOutput())
+	}
+
+	server.sendDirect(a, []string{"Bob"}, "hi")
+	server.handleCommand(b, "/read 2")
+	if !containsSubstring(bobOutput(), `{"unread":1,"read_up_to":2,"latest":3}`) {
+		t.Errorf("Expected one unread message after reading up to #2, got %q", bobOutput())
+	}
+	if !containsSubstring(aliceOutput(), `{"receipt":{"name":"Bob","read_up_to":2}}`) {
+		t.Errorf("Expected Alice to get Bob's receipt, got %q", aliceOutput())
+	}
+
+	server.handleCommand(b, "/read 1")
+	if server.unread("Bob").ReadUpTo != 2 {
+		t.Errorf("Expected the read marker never to move back, got %+v", server.unread("Bob"))
+	}
+
+	server.sendDirect(c, []string{"Bob"}, "psst")
+	server.handleCommand(b, "/read")
+	if containsSubstring(carolOutput(), "receipt") {
+		t.Errorf("Expected a plain text client never to see receipts, got %q", carolOutput())
+	}
+	server.handleCommand(c, "/unread")
+	if !containsSubstring(carolOutput(), `{"code":"ERR_DISABLED","error":"read receipts need the receipts capability, asked for by answering the name prompt with /caps receipts when connecting"`) {
+		t.Errorf("Expected /unread to be refused without the capability, got %q", carolOutput())
+	}
+}
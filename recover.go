@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// recoverPanic, deferred in a goroutine serving one connection, stops a
+// panic there from taking down the whole server. It logs the panic and its
+// stack with the connection's ID, then runs cleanup, which should
+// disconnect only that client.
+func (s *Server) recoverPanic(id string, cleanup func(err error)) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	fmt.Printf("%s Panic serving connection: %v\n%s", id, r, debug.Stack())
+	cleanup(fmt.Errorf("panic: %v", r))
+}
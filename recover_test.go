@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that a panic while serving one client disconnects only that client
+func TestPanicDisconnectsClient(t *testing.T) {
+	commands["/boom"] = command{usage: "/boom", run: func(*Server, *Client, []string) { panic("boom") }}
+	t.Cleanup(func() { delete(commands, "/boom") })
+
+	server := newTestServer(t, DefaultConfig())
+	var log lockedBuffer
+	server.eventLog = &log
+
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	server.addClient(bob)
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	go server.handleConn(conn)
+	reader := bufio.NewReader(peer)
+	readUntil(reader, namePrompt)
+	peer.Write([]byte("Alice\n"))
+	readUntil(reader, "[Alice]:")
+	peer.Write([]byte("/boom\n"))
+
+	// The connection is closed rather than the process crashing.
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, err := reader.ReadString('\n'); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatal("Expected the connection to be closed after the panic.")
+			}
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if server.findClient("Alice") != nil {
+		t.Errorf("Expected Alice to be removed after the panic.")
+	}
+	if server.findClient("Bob") == nil || !containsSubstring(bobOutput(), "Alice has left our chat") {
+		t.Errorf("Expected Bob to stay and be told Alice left, got %q", bobOutput())
+	}
+	var left bool
+	for _, line := range strings.Split(strings.TrimSpace(log.String()), "\n") {
+		var ev Event
+		json.Unmarshal([]byte(line), &ev)
+		left = left || (ev.Type == EventLeave && ev.Reason == "panic: boom")
+	}
+	if !left {
+		t.Errorf("Expected a leave event for the panic, got %s", log.String())
+	}
+}
+
+// panicConn panics when written to.
+type panicConn struct {
+	net.Conn
+}
+
+func (panicConn) Write([]byte) (int, error) { panic("write") }
+
+// Test that a panic delivering to one client does not stop the broadcast
+func TestPanicInDelivery(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.eventLog = nil
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	broken := mockClient("Broken", "192.168.1.1", panicConn{conn})
+	server.addClient(broken)
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	server.addClient(bob)
+	alice := mockClient("Alice", "192.168.1.3", nil)
+
+	server.broadcastLocal(alice, "\n[01-01-2025 10:00:00][Alice]:hello", "[01-01-2025 10:00:00]")
+	if !containsSubstring(bobOutput(), "[Alice]:hello") {
+		t.Errorf("Expected Bob to get the message, got %q", bobOutput())
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rename changes client's name to name. Everything that follows the name
+// moves with it in one step under s.mu: the registry entry, the resumable
+// session, read receipts, and the ignore lists and notification settings
+// in the state.
+// It runs in the client's own readLoop, which also broadcasts its
+// messages, so everything it sent before is broadcast under the old name
+// before the rename notice, and everything after under the new one.
+func (s *Server) rename(client *Client, name string) error {
+	if name == client.name.get() {
+		return newClientError(ErrCodeConflict, "You are already called "+name+".")
+	}
+	if isLinkHandshake(name) || strings.HasPrefix(name, "/") {
+		return newClientError(ErrCodeInvalidArgument, "Names cannot start with /.")
+	}
+
+	// joinMu keeps joining clients from claiming the name meanwhile.
+	s.joinMu.Lock()
+	defer s.joinMu.Unlock()
+	if problem := s.checkName(name); problem != nil {
+		return problem
+	}
+
+	s.mu.Lock()
+	old := client.name.get()
+	if !s.clients.Rename(client, name) {
+		s.mu.Unlock()
+		return newClientError(ErrCodeNameTaken, "Name "+name+" is already taken.")
+	}
+	if sess := s.sessions[client.session]; sess != nil {
+		sess.name = name
+	}
+	s.state.renameUser(old, name)
+	if marker, ok := s.readMarkers[old]; ok {
+		delete(s.readMarkers, old)
+		s.readMarkers[name] = marker
+	}
+	for _, c := range s.clients.All() {
+		for i, peer := range c.peers {
+			if peer == old {
+				c.peers[i] = name
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if err := s.saveState(); err != nil {
+		fmt.Println("Error saving server state:", err)
+	}
+	s.linkPresence(old, false)
+	s.linkPresence(name, true)
+	s.announce(s.mustText("rename", map[string]string{"Old": old, "Name": name}), client)
+	return nil
+}
+
+func cmdName(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/name")
+		return
+	}
+	if err := s.rename(client, args[0]); err != nil {
+		s.replyError(client, err)
+	}
+}
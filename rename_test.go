@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Test that /name moves everything keyed by the name at once and orders
+// the notice between messages sent under the old and the new name
+func TestRename(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	server := newTestServer(t, cfg)
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	b := server.addClient(bob)
+	server.issueSession(a)
+	server.setIgnore("Bob", "Alice", true)
+	server.setIgnore("Alice", "Carol", true)
+
+	server.handleCommand(b, "/name Alice")
+	if !containsSubstring(bobOutput(), ErrCodeNameTaken) {
+		t.Errorf("Expected a taken name to be refused, got %q", bobOutput())
+	}
+
+	server.messageClients(*b, "\n[01-01-2025 10:00:00][Bob]:before", "")
+	server.handleCommand(b, "/n Robert")
+	server.messageClients(*b, "\n[01-01-2025 10:00:01][Robert]:after", "")
+	out := aliceOutput()
+	before, notice, after := strings.Index(out, "[Bob]:before"), strings.Index(out, "Bob is now known as Robert"), strings.Index(out, "[Robert]:after")
+	if before < 0 || notice < before || after < notice {
+		t.Errorf("Expected the old name, the notice and the new name in order, got %q", out)
+	}
+	if server.findClient("Bob") != nil || server.findClient("Robert") != b {
+		t.Errorf("Expected only the new name to be registered.")
+	}
+	if !slices.Equal(server.ignoreList("Robert"), []string{"Alice"}) {
+		t.Errorf("Expected Bob's ignore list to follow him, got %v", server.ignoreList("Robert"))
+	}
+
+	server.handleCommand(a, "/name Alicia")
+	if !server.ignoring("Alicia", "Carol") || server.ignoring("Robert", "Alice") || !server.ignoring("Robert", "Alicia") {
+		t.Errorf("Expected ignore lists to follow Alice, got %v", server.state.Ignores)
+	}
+	if server.sessions[a.session].name != "Alicia" {
+		t.Errorf("Expected Alice's session to follow her.")
+	}
+}
+
+// Test that renaming while others send to and format the client is safe;
+// run with -race to check
+func TestRenameConcurrent(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, _ := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 20 {
+			server.handleCommand(b, "/name Bob"+strconv.Itoa(i))
+		}
+	}()
+	for range 20 {
+		server.messageClients(*a, "\n[01-01-2025 10:00:00][Alice]:hi", "[01-01-2025 10:00:00]")
+		server.prompt(b, "[01-01-2025 10:00:00]")
+		server.whoList(true)
+	}
+	<-done
+	if server.findClient("Bob19") != b {
+		t.Errorf("Expected Bob to end up as Bob19, got %q", b.name.get())
+	}
+}
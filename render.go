@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Clients can say what their terminal supports by answering the name
+// prompt with "/caps" and a list of capabilities, such as "/caps color".
+// The server replies "caps:" and those it recognised, then asks for the
+// name again. Clients that send the line as soon as they connect get the
+// reply before the banner, which "nobanner" skips. Capabilities can also
+// be changed later with commands such as /color.
+
+// capabilities are those a client may announce.
+var capabilities = []string{"color", "accessible", "nobanner", "receipts"}
+
+// capabilityWait is how long a new connection is given to announce its
+// capabilities before the banner is sent, so that "nobanner" and
+// "accessible" clients can skip it.
+const capabilityWait = 100 * time.Millisecond
+
+// earlyCapabilities reads a "/caps" line sent straight after connecting,
+// before the banner, and reports whether there was one. Anything else is
+// left in reader for the name prompt.
+func (s *Server) earlyCapabilities(conn net.Conn, reader *bufio.Reader) ([]string, bool) {
+	conn.SetReadDeadline(time.Now().Add(capabilityWait))
+	prefix, _ := reader.Peek(len("/caps "))
+	conn.SetReadDeadline(time.Time{})
+	if string(prefix) != "/caps " {
+		return nil, false
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, false
+	}
+	return capabilityRequest(strings.TrimRight(line, "\r\n"))
+}
+
+// capabilityRequest reports whether a name line announces capabilities
+// and, if so, which of them the server knows.
+func capabilityRequest(line string) ([]string, bool) {
+	rest, ok := strings.CutPrefix(line+" ", "/caps ")
+	if !ok {
+		return nil, false
+	}
+	var known []string
+	for _, c := range strings.Fields(strings.ToLower(rest)) {
+		if slices.Contains(capabilities, c) && !slices.Contains(known, c) {
+			known = append(known, c)
+		}
+	}
+	return known, true
+}
+
+// ANSI attributes used to render markdown-lite for color clients.
+const (
+	ansiBold      = "\x1b[1m"
+	ansiBoldOff   = "\x1b[22m"
+	ansiItalic    = "\x1b[3m"
+	ansiItalicOff = "\x1b[23m"
+	ansiCode      = "\x1b[36m"
+	ansiDefaultFg = "\x1b[39m"
+)
+
+var (
+	// chatPrefix and separator match the decorations accessible mode
+	// simplifies: "[02-01-2006 15:04:05][name]:" and a leading "*** ".
+	chatPrefix = regexp.MustCompile(`(?m)^\[\d\d-\d\d-\d{4} \d\d:\d\d:\d\d\]\[([^\]]*)\]:`)
+	separator  = regexp.MustCompile(`(?m)^\*\*\* `)
+
+	boldSpan   = regexp.MustCompile(`(^|[^\w*])\*([^*\s](?:[^*]*[^*\s])?)\*($|[^\w*])`)
+	italicSpan = regexp.MustCompile(`(^|[^\w_])_([^_\s](?:[^_]*[^_\s])?)_($|[^\w_])`)
+)
+
+// renderMarkdown shows *bold*, _italic_ and `code` spans in text with ANSI
+// attributes. Code spans are shown as they are, without further
+// formatting, and markers without a partner are left alone.
+func renderMarkdown(text string) string {
+	if !strings.ContainsAny(text, "*_`") {
+		return text
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(text, '`')
+		end := -1
+		if start >= 0 {
+			end = strings.IndexByte(text[start+1:], '`')
+		}
+		if end <= 0 {
+			b.WriteString(renderEmphasis(text))
+			return b.String()
+		}
+		end += start + 1
+		b.WriteString(renderEmphasis(text[:start]))
+		b.WriteString(ansiCode + text[start+1:end] + ansiDefaultFg)
+		text = text[end+1:]
+	}
+}
+
+func renderEmphasis(text string) string {
+	text = boldSpan.ReplaceAllString(text, "${1}"+ansiBold+"${2}"+ansiBoldOff+"${3}")
+	return italicSpan.ReplaceAllString(text, "${1}"+ansiItalic+"${2}"+ansiItalicOff+"${3}")
+}
+
+// renderAccessible simplifies text for screen readers: bells and
+// decorative separators are dropped, and chat messages are prefixed with
+// just the sender's name.
+func renderAccessible(text string) string {
+	text = strings.ReplaceAll(text, bell, "")
+	text = separator.ReplaceAllString(text, "")
+	return chatPrefix.ReplaceAllString(text, "$1: ")
+}
+
+// render prepares text sent to c for its terminal: it is simplified for
+// accessible clients, wrapped to the client's width, if it set one, and
+// markdown-lite is rendered for color clients. Everyone else gets text
+// untouched.
+func (s *Server) render(c *Client, text string) string {
+	s.mu.Lock()
+	color, width, accessible := c.color, c.width, c.accessible
+	s.mu.Unlock()
+
+	if accessible {
+		text = renderAccessible(text)
+	}
+	text = wrapText(text, width)
+	if color {
+		return renderMarkdown(text)
+	}
+	return text
+}
+
+// prompt is what c is shown when the server is ready for its next line.
+func (s *Server) prompt(c *Client, tf string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.accessible {
+		return "> "
+	}
+	return tf + "[" + c.name.get() + "]:"
+}
+
+func cmdAccessible(s *Server, client *Client, args []string) {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		s.replyUsage(client, "/accessible")
+		return
+	}
+
+	on := args[0] == "on"
+	s.mu.Lock()
+	client.accessible = on
+	s.mu.Unlock()
+	if err := s.setPref(client.name.get(), func(p *userPrefs) { p.Accessible = &on }); err != nil {
+		fmt.Println("Error saving server state:", err)
+	}
+
+	if args[0] == "on" {
+		s.reply(client, "accessible mode on: messages are shown as name, colon, text, without bells or decorations")
+	} else {
+		s.reply(client, "accessible mode off")
+	}
+}
+
+func cmdColor(s *Server, client *Client, args []string) {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		s.replyUsage(client, "/color")
+		return
+	}
+
+	on := args[0] == "on"
+	s.mu.Lock()
+	client.color = on
+	s.mu.Unlock()
+	if err := s.setPref(client.name.get(), func(p *userPrefs) { p.Color = &on }); err != nil {
+		fmt.Println("Error saving server state:", err)
+	}
+
+	if args[0] == "on" {
+		s.reply(client, "color on: "+renderMarkdown("*bold*, _italic_ and `code` are shown formatted"))
+	} else {
+		s.reply(client, "color off: messages are shown as they were typed")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := map[string]string{
+		"plain text":           "plain text",
+		"a *bold* move":        "a " + ansiBold + "bold" + ansiBoldOff + " move",
+		"_quite_ so":           ansiItalic + "quite" + ansiItalicOff + " so",
+		"run `go *test*` now":  "run " + ansiCode + "go *test*" + ansiDefaultFg + " now",
+		"snake_case_name":      "snake_case_name",
+		"2 * 3 * 4":            "2 * 3 * 4",
+		"an `unclosed span":    "an `unclosed span",
+		"[10:00][bob_b]:*hi*!": "[10:00][bob_b]:" + ansiBold + "hi" + ansiBoldOff + "!",
+	}
+	for in, want := range tests {
+		if got := renderMarkdown(in); got != want {
+			t.Errorf("renderMarkdown(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// Test that only clients with the color capability get formatting
+func TestColorClients(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	carol, carolOutput := pipeClient(t, "Carol", "192.168.1.3")
+	a := server.addClient(alice)
+	server.addClient(bob)
+	c := server.addClient(carol)
+	server.handleCommand(c, "/color on")
+
+	server.messageClients(*a, "\n[01-01-2025 10:00:00][Alice]:*really*", "")
+	if !containsSubstring(bobOutput(), "[Alice]:*really*") {
+		t.Errorf("Expected Bob to get the raw text, got %q", bobOutput())
+	}
+	if !containsSubstring(carolOutput(), "[Alice]:"+ansiBold+"really"+ansiBoldOff) {
+		t.Errorf("Expected Carol to get bold text, got %q", carolOutput())
+	}
+
+	if caps, ok := capabilityRequest("/caps COLOR sixel color"); !ok || len(caps) != 1 || caps[0] != "color" {
+		t.Errorf("Expected only the color capability, got %v, %v", caps, ok)
+	}
+}
+
+// Test that accessible clients get plain prefixes without decorations
+func TestAccessibleMode(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+	server.handleCommand(b, "/accessible on")
+
+	server.messageClients(*a, "\n[01-01-2025 10:00:00][Alice]:hi @Bob", "[01-01-2025 10:00:00]")
+	server.announce("*** GLOBAL [Op]: maintenance at noon", nil)
+
+	out := bobOutput()
+	if !containsSubstring(out, "\nAlice: hi @Bob\n> ") {
+		t.Errorf("Expected a simple prefix and prompt, got %q", out)
+	}
+	if !containsSubstring(out, "\nGLOBAL [Op]: maintenance at noon") {
+		t.Errorf("Expected the separator to be dropped, got %q", out)
+	}
+	if containsSubstring(out, bell) || containsSubstring(out, "[01-01-2025") {
+		t.Errorf("Expected no bells or timestamps, got %q", out)
+	}
+}
+
+// Test that the banner can be skipped by config or by an early capability
+func TestSkipBanner(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	greeting := func(send string) string {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		go server.handleConn(conn)
+		if send != "" {
+			peer.Write([]byte(send))
+		}
+		reader := bufio.NewReader(peer)
+		var got strings.Builder
+		for !strings.HasSuffix(got.String(), namePrompt) {
+			b, err := reader.ReadByte()
+			if err != nil {
+				break
+			}
+			got.WriteByte(b)
+		}
+		return got.String()
+	}
+
+	if got := greeting(""); got != welcomeBanner+namePrompt {
+		t.Errorf("Expected the banner by default, got %q", got)
+	}
+	if got := greeting("/caps nobanner color\n"); got != "caps: nobanner color\n"+namePrompt {
+		t.Errorf("Expected the banner to be skipped, got %q", got)
+	}
+
+	server.config.ShowBanner = false
+	if got := greeting(""); got != namePrompt {
+		t.Errorf("Expected no banner when it is turned off, got %q", got)
+	}
+}
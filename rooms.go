@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Rooms are side conversations within the chat. Everyone starts in the
+// main chat, which Config.DefaultRoom can name as a lobby such as #lobby;
+// /join #golang moves a client into the room #golang, where it only sees
+// and only reaches that room's members, and /leave, or joining the lobby,
+// takes it back. A room is created by the first client
+// to join it and deleted, with its history, when the last one leaves. Room
+// messages are kept in memory only: they are not logged, snapshotted,
+// exported or relayed to linked servers. Direct messages, /global and
+// scheduled announcements reach everyone wherever they are.
+
+// roomHistoryLimit is how many messages a room keeps to show those who
+// join it.
+const roomHistoryLimit = 100
+
+// maxRoomNameLength is the most characters a room name may have after
+// its "#".
+const maxRoomNameLength = 32
+
+// room is a room, its members and its recent messages.
+type room struct {
+	name    string
+	members map[*Client]bool
+	history *memoryHistory
+
+	// owner may change the room's settings with /roomset: the member who
+	// created it, or once they leave the first of the others by name.
+	owner *Client
+
+	// rateLimit and maxMessageSize, when positive, are the room's own
+	// message_rate_limit and max_message_size.
+	rateLimit, maxMessageSize int64
+}
+
+// replay returns the room's recent messages, to show someone joining it.
+func (r *room) replay() string {
+	var b strings.Builder
+	for _, m := range r.history.Messages() {
+		b.Write(m.payload)
+	}
+	return b.String()
+}
+
+// roomName returns name as a room name: lower case, with a leading "#",
+// which may be left out.
+func roomName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimPrefix(name, "#"))
+	invalid := func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-' || r == '_')
+	}
+	if name == "" || len(name) > maxRoomNameLength || strings.ContainsFunc(name, invalid) {
+		return "", newClientError(ErrCodeInvalidArgument, fmt.Sprintf("a room name is # and up to %d letters, digits, - or _", maxRoomNameLength))
+	}
+	return "#" + name, nil
+}
+
+// roomCapacity returns how many clients the room name may hold, or 0 for
+// no cap of its own.
+func (s *Server) roomCapacity(name string) int {
+	for key, limit := range s.config.RoomCapacity {
+		if n, _ := roomName(key); n == name {
+			return limit
+		}
+	}
+	return 0
+}
+
+// lobby returns the room name Config.DefaultRoom gives the main chat, or
+// "" if it has none.
+func (s *Server) lobby() string {
+	name, err := roomName(s.config.DefaultRoom)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// moveTo moves client into the room to, or to the main chat if to is "",
+// creating the room if needed and deleting the one it left if that is now
+// empty. It returns where the client was and the room it joined, or a
+// ClientError if it is already there or the room is full.
+func (s *Server) moveTo(client *Client, to string) (string, *room, error) {
+	var lastID uint64
+	if msgs := s.history.Messages(); len(msgs) > 0 {
+		lastID = msgs[len(msgs)-1].id
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from := client.room
+	switch {
+	case from == to && to == "" && s.lobby() != "":
+		return from, nil, newClientError(ErrCodeConflict, "you are already in "+s.lobby())
+	case from == to && to == "":
+		return from, nil, newClientError(ErrCodeConflict, "you are not in a room")
+	case from == to:
+		return from, nil, newClientError(ErrCodeConflict, "you are already in "+to)
+	}
+	if limit := s.roomCapacity(to); limit > 0 && s.rooms[to] != nil && len(s.rooms[to].members) >= limit {
+		return from, nil, newClientError(ErrCodeRoomFull, fmt.Sprintf("%s is full (%d users), please try again later.", to, limit))
+	}
+	if r := s.rooms[from]; r != nil {
+		delete(r.members, client)
+		if len(r.members) == 0 {
+			delete(s.rooms, from)
+		} else if r.owner == client {
+			r.owner = slices.MinFunc(slices.Collect(maps.Keys(r.members)), func(a, b *Client) int { return strings.Compare(a.name.get(), b.name.get()) })
+		}
+	}
+
+	client.room = to
+	if to == "" {
+		return from, nil, nil
+	}
+	if from == "" {
+		client.mainSeen = lastID
+	}
+	if s.rooms == nil {
+		s.rooms = make(map[string]*room)
+	}
+	r := s.rooms[to]
+	if r == nil {
+		r = &room{name: to, members: make(map[*Client]bool), history: newMemoryHistory(), owner: client}
+		s.rooms[to] = r
+	}
+	r.members[client] = true
+	return from, r, nil
+}
+
+// roomLabel names the room called name in templates and metrics: name
+// itself, or for the main chat the lobby's name or else the server name.
+func (s *Server) roomLabel(name string) string {
+	switch {
+	case name != "":
+		return name
+	case s.lobby() != "":
+		return s.lobby()
+	}
+	return s.config.ServerName
+}
+
+// roomMembers returns the names of the members of the room name, sorted.
+func (s *Server) roomMembers(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	if r := s.rooms[name]; r != nil {
+		for c := range r.members {
+			names = append(names, c.name.get())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// broadcastRoom is broadcastLocal for a client in a room: message is kept
+// in the room's history and sent to its other members.
+func (s *Server) broadcastRoom(client Client, message string, tf string) {
+	s.mu.Lock()
+	r := s.rooms[client.room]
+	var members []*Client
+	if r != nil {
+		members = slices.Collect(maps.Keys(r.members))
+	}
+	s.mu.Unlock()
+	if r == nil {
+		// The room was deleted when its last member left.
+		return
+	}
+
+	if !client.ephemeral {
+		m := r.history.Append(Message{from: client.ipAdd, name: client.name.get(), payload: []byte(message), sent: time.Now()})
+		r.history.Remove(func(old Message) bool { return old.id+roomHistoryLimit <= m.id })
+	}
+	s.deliverAll(client, members, message, tf)
+}
+
+// roomNotice tells everyone in the room name, or the main chat if name is
+// "", what client did.
+func (s *Server) roomNotice(name string, client *Client, text string) {
+	s.broadcastNotice(Client{ipAdd: client.ipAdd, room: name}, text)
+}
+
+// announceAll is announce for the main chat and every room, for
+// announcements meant for everyone on the server.
+func (s *Server) announceAll(text string, except *Client) {
+	s.announce(text, except)
+
+	s.mu.Lock()
+	names := slices.Sorted(maps.Keys(s.rooms))
+	s.mu.Unlock()
+	sender := Client{}
+	if except != nil {
+		sender.ipAdd = except.ipAdd
+	}
+	for _, name := range names {
+		sender.room = name
+		s.broadcastNotice(sender, text)
+	}
+}
+
+// roomList describes every room and who is in it, one a line.
+func (s *Server) roomList() string {
+	s.mu.Lock()
+	names := slices.Sorted(maps.Keys(s.rooms))
+	var lobby []string
+	if s.lobby() != "" {
+		for _, c := range s.clients.All() {
+			if c.room == "" {
+				lobby = append(lobby, c.name.get())
+			}
+		}
+	}
+	s.mu.Unlock()
+	if len(names) == 0 && s.lobby() == "" {
+		return "no rooms, /join #name to start one"
+	}
+
+	var lines []string
+	if s.lobby() != "" {
+		sort.Strings(lobby)
+		lines = append(lines, fmt.Sprintf("%s (%d): %s", s.lobby(), len(lobby), strings.Join(lobby, ", ")))
+	}
+	for _, name := range names {
+		members := s.roomMembers(name)
+		lines = append(lines, fmt.Sprintf("%s (%d): %s", name, len(members), strings.Join(members, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func cmdJoin(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/join")
+		return
+	}
+	name, err := roomName(args[0])
+	if err != nil {
+		s.replyError(client, err)
+		return
+	}
+	if name == s.lobby() {
+		cmdLeave(s, client, nil)
+		return
+	}
+	from, r, err := s.moveTo(client, name)
+	if err != nil {
+		s.replyError(client, err)
+		return
+	}
+	s.roomNotice(from, client, client.name.get()+" went to "+name)
+	s.roomNotice(name, client, client.name.get()+" joined "+name)
+
+	reply := "you joined " + name
+	if others := slices.DeleteFunc(s.roomMembers(name), func(n string) bool { return n == client.name.get() }); len(others) > 0 {
+		reply += ", here: " + strings.Join(others, ", ")
+	} else {
+		reply += ", nobody else is here yet"
+	}
+	s.reply(client, reply+r.replay())
+}
+
+func cmdLeave(s *Server, client *Client, args []string) {
+	if len(args) != 0 {
+		s.replyUsage(client, "/leave")
+		return
+	}
+	from, _, err := s.moveTo(client, "")
+	if err != nil {
+		s.replyError(client, err)
+		return
+	}
+	s.roomNotice(from, client, client.name.get()+" left "+from)
+	s.roomNotice("", client, client.name.get()+" came back from "+from)
+
+	s.mu.Lock()
+	seen := client.mainSeen
+	s.mu.Unlock()
+	back := "the main chat"
+	if s.lobby() != "" {
+		back = s.lobby()
+	}
+	reply := "you left " + from + " and are back in " + back
+	if marker := s.unreadMarker(seen); marker != "" {
+		reply += "\n" + marker + s.replayAfter(seen)
+	}
+	s.reply(client, reply)
+}
+
+func cmdRooms(s *Server, client *Client, args []string) {
+	s.reply(client, s.roomList())
+}
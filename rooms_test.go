@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that messages in a room only reach its members, and that the room
+// goes away with its last member
+func TestRooms(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OperatorPassword = "secret"
+	server := newTestServer(t, cfg)
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	carol, carolOutput := pipeClient(t, "Carol", "192.168.1.3")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+	c := server.addClient(carol)
+
+	server.handleCommand(a, "/join #Go-Lang")
+	if !containsSubstring(aliceOutput(), "you joined #go-lang, nobody else is here yet") {
+		t.Errorf("Expected Alice to create the room, got %q", aliceOutput())
+	}
+	if !containsSubstring(carolOutput(), "Alice went to #go-lang") {
+		t.Errorf("Expected the main chat to be told, got %q", carolOutput())
+	}
+	server.messageClients(*a, "\n[01-01-2025 10:00:00][Alice]:first in the room", "")
+	server.handleCommand(b, "/join go-lang")
+	if out := bobOutput(); !containsSubstring(out, "you joined #go-lang, here: Alice") || !containsSubstring(out, "[Alice]:first in the room") {
+		t.Errorf("Expected Bob to see who is there and the room's history, got %q", out)
+	}
+
+	server.messageClients(*b, "\n[01-01-2025 10:00:05][Bob]:room only", "")
+	server.messageClients(*c, "\n[01-01-2025 10:00:06][Carol]:main only", "")
+	if out := aliceOutput(); !containsSubstring(out, "room only") || containsSubstring(out, "main only") {
+		t.Errorf("Expected Alice to see only the room, got %q", out)
+	}
+	if out := carolOutput(); containsSubstring(out, "room only") || containsSubstring(out, "first in the room") {
+		t.Errorf("Expected Carol not to see the room, got %q", out)
+	}
+	if history := server.replayHistory(); containsSubstring(history, "room only") || !containsSubstring(history, "main only") {
+		t.Errorf("Expected room messages to stay out of the main history, got %q", history)
+	}
+
+	server.handleCommand(c, "/rooms")
+	if !containsSubstring(carolOutput(), "#go-lang (2): Alice, Bob") {
+		t.Errorf("Expected the room to be listed, got %q", carolOutput())
+	}
+	server.handleCommand(c, "/oper secret")
+	server.handleCommand(c, "/global maintenance at noon")
+	if !containsSubstring(aliceOutput(), "maintenance at noon") {
+		t.Errorf("Expected /global to reach the room, got %q", aliceOutput())
+	}
+
+	server.handleCommand(b, "/leave")
+	if out := bobOutput(); !containsSubstring(out, "you left #go-lang and are back in the main chat") || !containsSubstring(out, "[Carol]:main only") {
+		t.Errorf("Expected Bob to be shown what they missed, got %q", out)
+	}
+	if !containsSubstring(aliceOutput(), "Bob left #go-lang") {
+		t.Errorf("Expected the room to be told Bob left, got %q", aliceOutput())
+	}
+
+	server.handleCommand(a, "/leave")
+	server.mu.Lock()
+	rooms := len(server.rooms)
+	server.mu.Unlock()
+	if rooms != 0 {
+		t.Errorf("Expected the empty room to be deleted, %d left", rooms)
+	}
+}
+
+// Test that room names are checked and /leave needs a room
+func TestRoomErrors(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+
+	server.handleCommand(a, "/join #no spaces")
+	server.handleCommand(a, "/join #bad!name")
+	server.handleCommand(a, "/leave")
+	for _, want := range []string{"ERR_USAGE: usage: /join", "ERR_INVALID_ARGUMENT: a room name is #", "ERR_CONFLICT: you are not in a room"} {
+		if !containsSubstring(output(), want) {
+			t.Errorf("Expected %q, got %q", want, output())
+		}
+	}
+}
+
+// Test that a room with a capacity of its own refuses clients once full,
+// whatever the server-wide limit
+func TestRoomCapacity(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RoomCapacity = map[string]int{"#side": 0}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a room capacity under 1 to be refused")
+	}
+	cfg.RoomCapacity = map[string]int{"Side": 1}
+	server := newTestServer(t, cfg)
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+
+	server.handleCommand(a, "/join #side")
+	server.handleCommand(b, "/join #side")
+	if !containsSubstring(bobOutput(), "ERR_ROOM_FULL: #side is full (1 users)") {
+		t.Errorf("Expected Bob to be told the room is full, got %q", bobOutput())
+	}
+	if members := server.roomMembers("#side"); !slices.Equal(members, []string{"Alice"}) {
+		t.Errorf("Expected only Alice in #side, got %v", members)
+	}
+	server.handleCommand(b, "/join #other")
+	if members := server.roomMembers("#other"); !slices.Equal(members, []string{"Bob"}) {
+		t.Errorf("Expected rooms without a capacity to take Bob, got %v", members)
+	}
+
+	server.handleCommand(a, "/leave")
+	server.handleCommand(b, "/join #side")
+	if members := server.roomMembers("#side"); !slices.Equal(members, []string{"Bob"}) {
+		t.Errorf("Expected Bob to get in once Alice left, got %v", members)
+	}
+}
+
+// Test that a client leaving the server from a room is announced both in
+// the room and in the main chat, each naming where it was
+func TestRoomDisconnect(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LeaveMessage = "{{.Name}} left {{.Room}}"
+	cfg.ServerName = "chat1"
+	server := newTestServer(t, cfg)
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	carol, carolOutput := pipeClient(t, "Carol", "192.168.1.3")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+	server.addClient(carol)
+	server.handleCommand(a, "/join #side")
+	server.handleCommand(b, "/join #side")
+
+	server.leave(a, io.EOF)
+	if !containsSubstring(bobOutput(), "Alice left #side") {
+		t.Errorf("Expected the room to be told Alice left, got %q", bobOutput())
+	}
+	if !containsSubstring(carolOutput(), "Alice left chat1") {
+		t.Errorf("Expected the main chat to be told Alice left, got %q", carolOutput())
+	}
+}
+
+// Test that Config.DefaultRoom names the main chat, so its messages are
+// logged like any other, and that clients can join back to it
+func TestDefaultRoom(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DefaultRoom = "bad room"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected an invalid default_room to be refused")
+	}
+	cfg.DefaultRoom = "Lobby"
+	cfg.RoomCapacity = map[string]int{"#lobby": 5}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a room_capacity for the main chat to be refused")
+	}
+	cfg.RoomCapacity = nil
+	server := newTestServer(t, cfg)
+
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	b := server.addClient(bob)
+	server.messageClients(*b, "\n[01-01-2025 10:00:00][Bob]:welcome in", "")
+	if data, _ := os.ReadFile(server.logPath); !containsSubstring(string(data), "[Bob]:welcome in") {
+		t.Errorf("Expected the lobby's messages in the log file, got %q", data)
+	}
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	go server.handleConn(conn)
+	peer.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(peer)
+	var got strings.Builder
+	for !strings.Contains(got.String(), "[Bob]:welcome in") {
+		c, err := reader.ReadByte()
+		if err != nil {
+			t.Fatalf("Expected the lobby's messages, got %q: %v", got.String(), err)
+		}
+		got.WriteByte(c)
+		if strings.HasSuffix(got.String(), namePrompt) {
+			go peer.Write([]byte("Alice\n"))
+		}
+	}
+	go io.Copy(io.Discard, reader)
+
+	server.handleCommand(b, "/join #side")
+	server.handleCommand(b, "/rooms")
+	if !containsSubstring(bobOutput(), "#lobby (1): Alice\n#side (1): Bob") {
+		t.Errorf("Expected /rooms to list the lobby, got %q", bobOutput())
+	}
+	server.handleCommand(b, "/join #lobby")
+	if !containsSubstring(bobOutput(), "you left #side and are back in #lobby") {
+		t.Errorf("Expected /join #lobby to go back to the main chat, got %q", bobOutput())
+	}
+	server.handleCommand(b, "/join #lobby")
+	if !containsSubstring(bobOutput(), "ERR_CONFLICT: you are already in #lobby") {
+		t.Errorf("Expected Bob to be told they are in the lobby, got %q", bobOutput())
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A room's owner can make it stricter than the rest of the server with
+// /roomset: a lower message_rate_limit for slow mode, or a lower
+// max_message_size. Operators can change any room they are in. The
+// settings last as long as the room does.
+
+// roomSettings are the settings /roomset changes, each the room's own
+// value of the tunable of the same name.
+var roomSettings = map[string]func(r *room) *int64{
+	"max_message_size":   func(r *room) *int64 { return &r.maxMessageSize },
+	"message_rate_limit": func(r *room) *int64 { return &r.rateLimit },
+}
+
+// roomLimits returns the message_rate_limit and max_message_size for
+// client where it is: its room's own where it has them, or the server's.
+func (s *Server) roomLimits(client *Client) (rate, size int64) {
+	rate, size = s.messageRateLimit.Load(), s.maxMessageSize.Load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r := s.rooms[client.room]; r != nil {
+		if r.rateLimit > 0 && (rate <= 0 || r.rateLimit < rate) {
+			rate = r.rateLimit
+		}
+		if r.maxMessageSize > 0 && r.maxMessageSize < size {
+			size = r.maxMessageSize
+		}
+	}
+	return rate, size
+}
+
+// setRoomSetting changes the setting called name in r to value, or back
+// to the server's with "default". A room can only be stricter than the
+// server.
+func (s *Server) setRoomSetting(r *room, name, value string) error {
+	setting, ok := roomSettings[name]
+	if !ok {
+		return newClientError(ErrCodeNotFound, fmt.Sprintf("%s is not a room setting, only %s", name, strings.Join(slices.Sorted(maps.Keys(roomSettings)), ", ")))
+	}
+	var n int64
+	if value != "default" {
+		most := tunables[name].value(s).Load()
+		var err error
+		n, err = strconv.ParseInt(value, 10, 64)
+		switch {
+		case most > 0 && (err != nil || n < 1 || n > most):
+			return newClientError(ErrCodeInvalidArgument, fmt.Sprintf("%s must be a whole number from 1 to %d, the server's, or default", name, most))
+		case err != nil || n < 1:
+			return newClientError(ErrCodeInvalidArgument, fmt.Sprintf("%s must be a whole number of at least 1, or default", name))
+		}
+	}
+	s.mu.Lock()
+	*setting(r) = n
+	s.mu.Unlock()
+	return nil
+}
+
+// roomSettingsText describes r's owner and settings, one a line.
+func (s *Server) roomSettingsText(r *room) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := []string{r.name + " is owned by " + r.owner.name.get()}
+	for _, name := range slices.Sorted(maps.Keys(roomSettings)) {
+		if n := *roomSettings[name](r); n > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %d", name, n))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: %d, the server's", name, tunables[name].value(s).Load()))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func cmdRoomSet(s *Server, client *Client, args []string) {
+	s.mu.Lock()
+	r := s.rooms[client.room]
+	allowed := r != nil && (r.owner == client || client.operator)
+	var owner string
+	if r != nil {
+		owner = r.owner.name.get()
+	}
+	s.mu.Unlock()
+
+	switch {
+	case len(args) != 0 && len(args) != 2:
+		s.replyUsage(client, "/roomset")
+		return
+	case r == nil:
+		s.replyError(client, newClientError(ErrCodeConflict, "you are not in a room"))
+		return
+	case len(args) == 0:
+		s.reply(client, s.roomSettingsText(r))
+		return
+	case !allowed:
+		s.replyError(client, newClientError(ErrCodePermissionDenied, fmt.Sprintf("only %s, who owns %s, or an operator can change its settings", owner, r.name)))
+		return
+	}
+	if err := s.setRoomSetting(r, args[0], args[1]); err != nil {
+		s.replyError(client, err)
+		return
+	}
+	fmt.Printf("%s %s set %s in %s to %s\n", client.connID, client.name.get(), args[0], r.name, args[1])
+	s.roomNotice(r.name, client, client.name.get()+" set "+args[0]+" to "+args[1])
+	s.reply(client, args[0]+" in "+r.name+" is now "+args[1])
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Test that a room's owner can make it stricter than the server with
+// /roomset, that its messages are held to that, and that ownership passes
+// on when the owner leaves
+func TestRoomSet(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+	conn, peer := net.Pipe()
+	b := server.addClient(mockClient("Bob", "192.168.1.2", conn))
+	var bobOutput lockedBuffer
+	go io.Copy(&bobOutput, peer)
+
+	server.handleCommand(a, "/roomset")
+	server.handleCommand(a, "/join #side")
+	server.handleCommand(b, "/join #side")
+	server.handleCommand(b, "/roomset message_rate_limit 1")
+	server.handleCommand(a, "/roomset max_message_size 5000")
+	server.handleCommand(a, "/roomset message_rate_limit 1")
+	server.handleCommand(a, "/roomset max_message_size 10")
+	server.handleCommand(b, "/roomset")
+	time.Sleep(20 * time.Millisecond)
+	for _, want := range []string{
+		"ERR_CONFLICT: you are not in a room",
+		"ERR_INVALID_ARGUMENT: max_message_size must be a whole number from 1 to 2048",
+		"max_message_size in #side is now 10",
+	} {
+		if !containsSubstring(aliceOutput(), want) {
+			t.Errorf("Expected %q in %q", want, aliceOutput())
+		}
+	}
+	for _, want := range []string{
+		"ERR_PERMISSION_DENIED: only Alice, who owns #side, or an operator can change its settings",
+		"Alice set message_rate_limit to 1",
+		"#side is owned by Alice\nmax_message_size: 10\nmessage_rate_limit: 1",
+	} {
+		if !containsSubstring(bobOutput.String(), want) {
+			t.Errorf("Expected %q in %q", want, bobOutput.String())
+		}
+	}
+
+	go server.readLoop(conn, b)
+	peer.Write([]byte("far too long\n"))
+	peer.Write([]byte("hi\n"))
+	peer.Write([]byte("again\n"))
+	time.Sleep(20 * time.Millisecond)
+	if out := bobOutput.String(); !containsSubstring(out, "ERR_MSG_TOO_LONG: Messages can be at most 9 bytes here") || !containsSubstring(out, "ERR_RATE_LIMIT: You can send 1 messages a minute here") {
+		t.Errorf("Expected the room's limits to be enforced, got %q", out)
+	}
+	if out := aliceOutput(); !containsSubstring(out, "[Bob]:hi") || containsSubstring(out, "far too long") || containsSubstring(out, "again") {
+		t.Errorf("Expected only the message within the limits to reach the room, got %q", out)
+	}
+
+	server.handleCommand(a, "/leave")
+	peer.Write([]byte("/roomset max_message_size default\n"))
+	time.Sleep(20 * time.Millisecond)
+	peer.Close()
+	if !containsSubstring(bobOutput.String(), "max_message_size in #side is now default") {
+		t.Errorf("Expected Bob to own the room once Alice left, got %q", bobOutput.String())
+	}
+	if rate, size := server.roomLimits(a); rate != 0 || size != defaultMaxMessageSize {
+		t.Errorf("Expected the main chat to keep the server's limits, got %d and %d", rate, size)
+	}
+}
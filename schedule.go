@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Announcement is a system message sent to everyone on a cron-style
+// schedule, such as "0 2 * * *" for every day at 02:00.
+type Announcement struct {
+	Schedule string `json:"schedule"`
+	Text     string `json:"text"`
+}
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week. Each field is a bit set of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny record a "*" day field. As in cron, when both day
+	// fields are restricted a time matching either one is due.
+	domAny, dowAny bool
+}
+
+// parseCron parses a five-field cron expression. Each field is "*" or a
+// comma-separated list of values and ranges, each optionally with a step,
+// e.g. "*/15" or "1-5". Day of week 7 means Sunday, like 0.
+func parseCron(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("schedule %q: want 5 fields, got %d", expr, len(fields))
+	}
+
+	var c cronSchedule
+	limits := []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	}
+	for i, f := range fields {
+		set, err := parseCronField(f, limits[i].min, limits[i].max)
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("schedule %q: %v", expr, err)
+		}
+		*limits[i].set = set
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField returns the values field allows between lo and hi as a bit
+// set.
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+		}
+
+		from, to := lo, hi
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("bad value in %q", part)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("bad range in %q", part)
+				}
+			} else if hasStep {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// due reports whether the schedule fires in the minute containing t.
+func (c cronSchedule) due(t time.Time) bool {
+	has := func(set uint64, v int) bool { return set&(1<<v) != 0 }
+	if !has(c.minute, t.Minute()) || !has(c.hour, t.Hour()) || !has(c.month, int(t.Month())) {
+		return false
+	}
+
+	dom, dow := has(c.dom, t.Day()), has(c.dow, int(t.Weekday()))
+	switch {
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// announcements returns the scheduled announcements: those from the config
+// first, then those added with /schedule.
+func (s *Server) announcements() []Announcement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(append([]Announcement(nil), s.config.Announcements...), s.state.Announcements...)
+}
+
+// addAnnouncement schedules a after checking its schedule, and saves it.
+func (s *Server) addAnnouncement(a Announcement) error {
+	if _, err := parseCron(a.Schedule); err != nil {
+		return wrapClientError(ErrCodeInvalidArgument, err.Error(), err)
+	}
+
+	s.mu.Lock()
+	s.state.Announcements = append(s.state.Announcements, a)
+	s.mu.Unlock()
+	return s.saveState()
+}
+
+// removeAnnouncement deletes the i'th announcement, counting from zero as
+// announcements lists them. Those from the config cannot be removed.
+func (s *Server) removeAnnouncement(i int) error {
+	s.mu.Lock()
+	fixed := len(s.config.Announcements)
+	if i < 0 || i-fixed >= len(s.state.Announcements) {
+		s.mu.Unlock()
+		return newClientError(ErrCodeNotFound, fmt.Sprintf("no announcement %d", i+1))
+	}
+	if i < fixed {
+		s.mu.Unlock()
+		return newClientError(ErrCodePermissionDenied, fmt.Sprintf("announcement %d is set in the config file", i+1))
+	}
+	s.state.Announcements = append(s.state.Announcements[:i-fixed], s.state.Announcements[i-fixed+1:]...)
+	s.mu.Unlock()
+	return s.saveState()
+}
+
+// announceDue sends every announcement due in the minute containing now.
+func (s *Server) announceDue(now time.Time) {
+	for _, a := range s.announcements() {
+		c, err := parseCron(a.Schedule)
+		if err != nil {
+			continue
+		}
+		if c.due(now) {
+			s.announceAll(s.mustText("announcement", map[string]string{"Text": a.Text}), nil)
+		}
+	}
+}
+
+// scheduleLoop sends scheduled announcements at the start of each minute
+// until the server stops.
+func (s *Server) scheduleLoop() {
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-time.After(next.Sub(now)):
+			s.announceDue(next)
+		case <-s.quitch:
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that cron expressions fire on the right minutes
+func TestCronSchedule(t *testing.T) {
+	// 2025-01-20 was a Monday.
+	monday := time.Date(2025, 1, 20, 2, 0, 0, 0, time.Local)
+	tests := []struct {
+		expr string
+		at   time.Time
+		due  bool
+	}{
+		{"0 2 * * *", monday, true},
+		{"0 2 * * *", monday.Add(time.Minute), false},
+		{"*/15 * * * *", monday.Add(45 * time.Minute), true},
+		{"*/15 * * * *", monday.Add(50 * time.Minute), false},
+		{"0 2 * * 1-5", monday, true},
+		{"0 2 * * 0,6", monday, false},
+		{"0 2 * * 7", monday.AddDate(0, 0, 6), true},
+		{"0 2 1 * 1", monday, true},
+		{"0 2 1 * 2", monday, false},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		if got := c.due(tt.at); got != tt.due {
+			t.Errorf("%q due at %s = %v, want %v", tt.expr, tt.at, got, tt.due)
+		}
+	}
+
+	for _, bad := range []string{"0 2 * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+// Test that operators can schedule announcements that reach everyone
+func TestScheduledAnnouncement(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.config.StateFile = filepath.Join(t.TempDir(), "state.json")
+	server.config.Announcements = []Announcement{{Schedule: "0 9 * * 1", Text: "weekly standup"}}
+
+	op, opOutput := pipeClient(t, "Op", "192.168.1.1")
+	op.operator = true
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	server.addClient(op)
+	server.addClient(bob)
+
+	server.handleCommand(&op, "/schedule add 0 2 * * * backup at 02:00")
+	server.handleCommand(&op, "/schedule remove 1")
+	if !containsSubstring(opOutput(), "set in the config file") {
+		t.Errorf("Expected announcements from the config to be kept, got %q", opOutput())
+	}
+
+	server.announceDue(time.Date(2025, 1, 20, 2, 0, 0, 0, time.Local))
+	if !containsSubstring(bobOutput(), "*** ANNOUNCEMENT: backup at 02:00") {
+		t.Errorf("Expected Bob to get the announcement, got %q", bobOutput())
+	}
+	if containsSubstring(bobOutput(), "weekly standup") {
+		t.Errorf("Expected the standup announcement to wait until 09:00")
+	}
+
+	server.handleCommand(&op, "/schedule remove 2")
+	if len(server.announcements()) != 1 {
+		t.Errorf("Expected the added announcement to be removed, got %v", server.announcements())
+	}
+}
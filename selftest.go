@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"net-cat/pkg/client"
+)
+
+// selfTestWait is how long each self-test step waits for the server.
+const selfTestWait = 5 * time.Second
+
+// selfTest starts a server on a free local port with a throwaway log,
+// connects clients to it, and checks that they can chat, that /name works
+// and that the rate limit holds. Each step is reported to w, and the first
+// failure is returned.
+func selfTest(w io.Writer) error {
+	dir, err := os.MkdirTemp("", "net-cat-selftest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := DefaultConfig()
+	cfg.LogFile = filepath.Join(dir, "server_log.txt")
+	cfg.StateFile = ""
+	cfg.MessageRateLimit = 5
+	server := NewServerWithConfig("127.0.0.1:0", cfg)
+	server.eventLog = nil
+	started := make(chan error, 1)
+	go func() { started <- server.Start() }()
+	defer server.Stop()
+	// Let the server see the clients leave before its log is removed.
+	defer func() {
+		for deadline := time.Now().Add(time.Second); server.clients.Count() > 0 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	var addr net.Addr
+	for deadline := time.Now().Add(selfTestWait); addr == nil; {
+		select {
+		case err := <-started:
+			return fmt.Errorf("start: %w", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return errors.New("start: server did not listen in time")
+		}
+		addr = server.Addr()
+	}
+	fmt.Fprintf(w, "start on %s: ok\n", addr)
+
+	alice, err := client.Dial(addr.String(), "selftest-alice")
+	if err != nil {
+		return fmt.Errorf("join: %w", err)
+	}
+	defer alice.Close()
+	bob, err := client.Dial(addr.String(), "selftest-bob")
+	if err != nil {
+		return fmt.Errorf("join: %w", err)
+	}
+	defer bob.Close()
+	fmt.Fprintln(w, "join: ok")
+
+	if err := alice.Send("hello from alice"); err != nil {
+		return fmt.Errorf("chat: %w", err)
+	}
+	if err := awaitEvent(bob, func(ev client.Event) bool {
+		m, ok := ev.(client.Message)
+		return ok && m.Name == "selftest-alice" && m.Text == "hello from alice"
+	}); err != nil {
+		return fmt.Errorf("chat: %w", err)
+	}
+	fmt.Fprintln(w, "chat: ok")
+
+	if err := bob.Send("/name selftest-robert"); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	if err := awaitEvent(alice, func(ev client.Event) bool {
+		n, ok := ev.(client.Notice)
+		return ok && n.Text == "selftest-bob is now known as selftest-robert"
+	}); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	if server.findClient("selftest-robert") == nil || server.findClient("selftest-bob") != nil {
+		return errors.New("rename: the server does not know the new name")
+	}
+	fmt.Fprintln(w, "rename: ok")
+
+	for i := range cfg.MessageRateLimit + 1 {
+		if err := alice.Send(fmt.Sprintf("flood %d", i)); err != nil {
+			return fmt.Errorf("rate limit: %w", err)
+		}
+	}
+	if err := awaitEvent(alice, func(ev client.Event) bool {
+		e, ok := ev.(client.ErrorReply)
+		return ok && e.Code == ErrCodeRateLimit
+	}); err != nil {
+		return fmt.Errorf("rate limit: %w", err)
+	}
+	fmt.Fprintln(w, "rate limit: ok")
+	return nil
+}
+
+// awaitEvent reads events from c until one matches, failing if the
+// connection ends or none does within selfTestWait.
+func awaitEvent(c *client.Client, match func(client.Event) bool) error {
+	timeout := time.After(selfTestWait)
+	var seen []string
+	for {
+		select {
+		case ev, ok := <-c.Messages():
+			if !ok {
+				return fmt.Errorf("connection ended: %v", c.Err())
+			}
+			if match(ev) {
+				return nil
+			}
+			seen = append(seen, describeEvent(ev))
+		case <-timeout:
+			return fmt.Errorf("not seen within %s; got %s", selfTestWait, strings.Join(seen, " | "))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A session token lets a client that lost its connection rejoin under the
+// same name and be sent only the messages it missed. Clients ask for one
+// with /session, and resume by answering the name prompt with
+// "/resume <token>".
+
+// sessionTTL is how long a disconnected session can still be resumed.
+const sessionTTL = 10 * time.Minute
+
+// chatSession records where a session's client left off.
+type chatSession struct {
+	name string
+
+	// lastID is the newest message the client had been sent when it
+	// disconnected; connected is true while it is online.
+	lastID    uint64
+	connected bool
+	expires   time.Time
+}
+
+// resumeToken extracts the token from a "/resume <token>" name line.
+func resumeToken(line string) (string, bool) {
+	token, ok := strings.CutPrefix(line, "/resume ")
+	return strings.TrimSpace(token), ok
+}
+
+// issueSession returns client's session token, creating one if needed.
+func (s *Server) issueSession(client *Client) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if client.session != "" {
+		return client.session
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+
+	if s.sessions == nil {
+		s.sessions = make(map[string]*chatSession)
+	}
+	for t, sess := range s.sessions {
+		if !sess.connected && time.Now().After(sess.expires) {
+			delete(s.sessions, t)
+		}
+	}
+	s.sessions[token] = &chatSession{name: client.name.get(), connected: true}
+	client.session = token
+	return token
+}
+
+// resumeSession looks up token and, if it can be resumed, returns the name
+// to rejoin with and the ID of the last message the client saw.
+func (s *Server) resumeSession(token string) (string, uint64, bool) {
+	s.mu.Lock()
+	sess, ok := s.sessions[token]
+	if ok && !sess.connected && time.Now().After(sess.expires) {
+		delete(s.sessions, token)
+		ok = false
+	}
+	if !ok || sess.connected {
+		s.mu.Unlock()
+		return "", 0, false
+	}
+	name, lastID := sess.name, sess.lastID
+	s.mu.Unlock()
+
+	// Someone else may have taken the name in the meantime.
+	if s.checkName(name) != nil {
+		return "", 0, false
+	}
+
+	s.mu.Lock()
+	sess.connected = true
+	s.mu.Unlock()
+	return name, lastID, true
+}
+
+// unreadMarker is the line shown before the messages a resumed session
+// missed, saying how many there are, or empty if it missed none.
+func (s *Server) unreadMarker(after uint64) string {
+	missed := 0
+	for _, m := range s.history.Messages() {
+		if m.id > after {
+			missed++
+		}
+	}
+	if missed == 0 {
+		return ""
+	}
+	count := "1 unread message"
+	if missed > 1 {
+		count = fmt.Sprintf("%d unread messages", missed)
+	}
+	text, _ := s.text("unread_marker", map[string]string{"Count": count})
+	return text
+}
+
+// suspendSession records that client disconnected, so its session can be
+// resumed for a while.
+func (s *Server) suspendSession(client *Client) {
+	var lastID uint64
+	if msgs := s.history.Messages(); len(msgs) > 0 {
+		lastID = msgs[len(msgs)-1].id
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if client.room != "" {
+		// It has not seen the main chat since going into a room.
+		lastID = client.mainSeen
+	}
+	if sess, ok := s.sessions[client.session]; ok {
+		sess.connected = false
+		sess.lastID = lastID
+		sess.expires = time.Now().Add(sessionTTL)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that a suspended session resumes under its name and replays only
+// what was missed
+func TestResumeSession(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	tf := "[" + time.Now().Format("02-01-2006 15:04:05") + "]"
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, _ := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+
+	server.messageClients(*b, "before", tf)
+	token := server.issueSession(a)
+	if again := server.issueSession(a); again != token {
+		t.Errorf("Expected the same token twice, got %q and %q", token, again)
+	}
+
+	if _, _, ok := server.resumeSession(token); ok {
+		t.Errorf("Expected a connected session not to be resumable.")
+	}
+
+	server.removeClient(*a)
+	server.suspendSession(a)
+	server.messageClients(*b, "missed", tf)
+
+	name, lastID, ok := server.resumeSession(token)
+	if !ok || name != "Alice" {
+		t.Fatalf("Expected to resume as Alice, got %q, %v", name, ok)
+	}
+
+	replay := server.replayAfter(lastID)
+	if containsSubstring(replay, "before") || !containsSubstring(replay, "missed") {
+		t.Errorf("Expected only the missed message to be replayed, got %q", replay)
+	}
+	if marker := server.unreadMarker(lastID); marker != "---- you have 1 unread message ----" {
+		t.Errorf("Expected a marker for the missed message, got %q", marker)
+	}
+	if marker := server.unreadMarker(lastID + 1); marker != "" {
+		t.Errorf("Expected no marker when nothing was missed, got %q", marker)
+	}
+
+	if _, _, ok := server.resumeSession(token); ok {
+		t.Errorf("Expected a session to be resumed only once.")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A long block of text pasted into the chat pushes the conversation off
+// everyone's screen. /share collects it instead: every line until one
+// holding just "." is stored as a snippet, and the chat is only told
+// "Alice shared snippet #a1b2 (42 lines), /get a1b2". Snippets are kept in
+// memory for Config.ShareTTL and can be at most Config.ShareMaxSize bytes.
+
+// snippet is a block of text shared with /share.
+type snippet struct {
+	id      string
+	name    string
+	text    string
+	lines   int
+	expires time.Time
+}
+
+// shareDraft is the text a client is sharing, collected line by line.
+type shareDraft struct {
+	lines   []string
+	size    int
+	partial string
+}
+
+// sharing reports whether client is in the middle of a /share.
+func (s *Server) sharing(client *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return client.share != nil
+}
+
+// collectShare adds what client sent to the text it is sharing, and
+// shares it once a line holding just "." arrives. Lines may be split
+// across reads, so an unfinished one is kept for the next.
+func (s *Server) collectShare(client *Client, data string) {
+	s.mu.Lock()
+	draft := client.share
+	text := draft.partial + strings.ReplaceAll(data, "\r", "")
+	lines := strings.Split(text, "\n")
+	draft.partial = lines[len(lines)-1]
+	done := false
+	for _, line := range lines[:len(lines)-1] {
+		if line == "." {
+			done = true
+			break
+		}
+		draft.lines = append(draft.lines, line)
+		draft.size += len(line) + 1
+	}
+	tooBig := draft.size+len(draft.partial) > s.config.ShareMaxSize
+	if done || tooBig {
+		client.share = nil
+	}
+	s.mu.Unlock()
+
+	switch {
+	case tooBig:
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, fmt.Sprintf("snippets can be at most %d bytes, nothing shared", s.config.ShareMaxSize)))
+	case done && len(draft.lines) == 0:
+		s.reply(client, "nothing shared")
+	case done:
+		s.share(client, draft.lines)
+	}
+}
+
+// share stores lines from client as a snippet and tells everyone.
+func (s *Server) share(client *Client, lines []string) {
+	now := time.Now()
+	sn := &snippet{name: client.name.get(), text: strings.Join(lines, "\n"), lines: len(lines),
+		expires: now.Add(time.Duration(s.config.ShareTTL))}
+
+	s.mu.Lock()
+	for id, old := range s.snippets {
+		if now.After(old.expires) {
+			delete(s.snippets, id)
+		}
+	}
+	if s.snippets == nil {
+		s.snippets = make(map[string]*snippet)
+	}
+	for sn.id == "" || s.snippets[sn.id] != nil {
+		sn.id = newSnippetID()
+	}
+	s.snippets[sn.id] = sn
+	s.mu.Unlock()
+
+	count := "1 line"
+	if sn.lines != 1 {
+		count = fmt.Sprintf("%d lines", sn.lines)
+	}
+	s.announce(s.mustText("share", map[string]string{"Name": client.name.get(), "ID": sn.id, "Lines": count}), client)
+}
+
+// newSnippetID returns a short random snippet ID such as "a1b2".
+func newSnippetID() string {
+	id := make([]byte, 2)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// snippet returns the snippet id, unless it has expired.
+func (s *Server) snippet(id string) *snippet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sn := s.snippets[strings.TrimPrefix(id, "#")]
+	if sn == nil || time.Now().After(sn.expires) {
+		return nil
+	}
+	return sn
+}
+
+func cmdShare(s *Server, client *Client, args []string) {
+	if len(args) != 0 {
+		s.replyUsage(client, "/share")
+		return
+	}
+	if s.config.ShareMaxSize <= 0 {
+		s.replyError(client, newClientError(ErrCodeDisabled, "sharing is turned off"))
+		return
+	}
+	s.mu.Lock()
+	client.share = &shareDraft{}
+	s.mu.Unlock()
+	s.reply(client, "paste the text to share, then a line with just . to finish")
+}
+
+func cmdGet(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/get")
+		return
+	}
+	sn := s.snippet(args[0])
+	if sn == nil {
+		s.replyError(client, newClientError(ErrCodeNotFound, "no snippet "+args[0]+", it may have expired"))
+		return
+	}
+	s.reply(client, fmt.Sprintf("snippet #%s from %s:\n%s\n(end of snippet #%s)", sn.id, sn.name, sn.text, sn.id))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// Test that /share collects lines until "." and /get returns them
+func TestShare(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+
+	server.handleCommand(a, "/share")
+	if !server.sharing(a) {
+		t.Fatalf("Expected Alice to be sharing after /share, got %q", aliceOutput())
+	}
+	server.collectShare(a, "func main() {\n\tprintln(\"hi\")\n")
+	server.collectShare(a, "}\n.")
+	if !server.sharing(a) {
+		t.Fatalf("Expected the share to wait for the end of the last line.")
+	}
+	server.collectShare(a, "\n")
+
+	m := regexp.MustCompile(`Alice shared snippet #([0-9a-f]{4}) \(3 lines\), /get ([0-9a-f]{4})`).FindStringSubmatch(bobOutput())
+	if m == nil || m[1] != m[2] {
+		t.Fatalf("Expected Bob to be told about the snippet, got %q", bobOutput())
+	}
+	if containsSubstring(bobOutput(), "println") {
+		t.Errorf("Expected the snippet text to stay out of the chat, got %q", bobOutput())
+	}
+
+	server.handleCommand(b, "/get "+m[1])
+	if !containsSubstring(bobOutput(), "snippet #"+m[1]+" from Alice:\nfunc main() {\n\tprintln(\"hi\")\n}\n") {
+		t.Errorf("Expected /get to show the snippet, got %q", bobOutput())
+	}
+
+	server.mu.Lock()
+	server.snippets[m[1]].expires = time.Now().Add(-time.Second)
+	server.mu.Unlock()
+	server.handleCommand(b, "/get "+m[1])
+	if !containsSubstring(bobOutput(), "ERR_NOT_FOUND: no snippet "+m[1]) {
+		t.Errorf("Expected an expired snippet to be gone, got %q", bobOutput())
+	}
+}
+
+// Test that a snippet over share_max_size is refused
+func TestShareTooBig(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.config.ShareMaxSize = 10
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+
+	server.handleCommand(a, "/share")
+	server.collectShare(a, "this line is too long\n")
+	if server.sharing(a) || !containsSubstring(output(), "ERR_INVALID_ARGUMENT: snippets can be at most 10 bytes") {
+		t.Errorf("Expected the snippet to be refused, got %q", output())
+	}
+}
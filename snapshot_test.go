@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that a snapshot saved by one server is loaded by the next
+func TestSnapshotRoundTrip(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SnapshotFile = filepath.Join(t.TempDir(), "history.json")
+	cfg.LogKey = "secret"
+
+	server := newTestServer(t, cfg)
+	server.messageClients(mockClient("Alice", "192.168.1.1", nil), "\n[01-01-2025 10:00:00][Alice]:hello", "")
+
+	if err := server.saveSnapshot(); err != nil {
+		t.Fatalf("saveSnapshot failed: %v", err)
+	}
+
+	restarted := newTestServer(t, cfg)
+	if err := restarted.loadSnapshot(); err != nil {
+		t.Fatalf("loadSnapshot failed: %v", err)
+	}
+
+	if got := restarted.replayHistory(); got != "\n[01-01-2025 10:00:00][Alice]:hello" {
+		t.Errorf("Unexpected history after restart: %q", got)
+	}
+
+	if sent := restarted.history.Messages()[0].sent; sent.IsZero() || time.Since(sent) > time.Minute {
+		t.Errorf("Expected send time to survive the snapshot.")
+	}
+}
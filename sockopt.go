@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listenConfig returns how to create the listening socket, applying the
+// SO_REUSEADDR and SO_REUSEPORT settings to TCP listeners and the
+// keepalive period to the connections they accept.
+func (s *Server) listenConfig() *net.ListenConfig {
+	lc := &net.ListenConfig{KeepAlive: time.Duration(s.config.TCPKeepAlive)}
+	reuseAddr, reusePort := s.config.TCPReuseAddr, s.config.TCPReusePort
+	if !reuseAddr && !reusePort {
+		return lc
+	}
+	lc.Control = func(network, address string, c syscall.RawConn) error {
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+		var err error
+		if ctrlErr := c.Control(func(fd uintptr) { err = setReuse(fd, reuseAddr, reusePort) }); ctrlErr != nil {
+			return ctrlErr
+		}
+		return err
+	}
+	return lc
+}
+
+// listenOn is net.Listen with the configured socket options.
+func (s *Server) listenOn(network, address string) (net.Listener, error) {
+	return s.listenConfig().Listen(context.Background(), network, address)
+}
+
+// tuneConn applies the configured TCP options to an accepted connection.
+// Failures are logged rather than dropping the client.
+func (s *Server) tuneConn(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if s.config.TCPNoDelay != nil {
+		if err := tcp.SetNoDelay(*s.config.TCPNoDelay); err != nil {
+			fmt.Println("Error setting TCP_NODELAY:", err)
+		}
+	}
+	if n := s.config.TCPReadBuffer; n > 0 {
+		if err := tcp.SetReadBuffer(n); err != nil {
+			fmt.Println("Error setting the receive buffer size:", err)
+		}
+	}
+	if n := s.config.TCPWriteBuffer; n > 0 {
+		if err := tcp.SetWriteBuffer(n); err != nil {
+			fmt.Println("Error setting the send buffer size:", err)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+// soReusePort is SO_REUSEPORT, which the frozen syscall package does not
+// define for Linux.
+const soReusePort = 0xf
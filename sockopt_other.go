@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+// setReuse is not supported on this platform.
+func setReuse(fd uintptr, reuseAddr, reusePort bool) error {
+	return errors.New("tcp_reuse_addr and tcp_reuse_port are not supported on this platform")
+}
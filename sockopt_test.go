@@ -0,0 +1,34 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+// Test that SO_REUSEPORT lets two listeners share a port
+func TestReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT semantics differ on " + runtime.GOOS)
+	}
+
+	server := newTestServer(t, DefaultConfig())
+	server.listenAddr = "127.0.0.1:0"
+	server.config.TCPReusePort = true
+	first, err := server.listen(server.listenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := server.listen(first.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected a second listener on %s: %v", first.Addr(), err)
+	}
+	second.Close()
+
+	server.config.TCPReusePort = false
+	if ln, err := server.listen(first.Addr().String()); err == nil {
+		ln.Close()
+		t.Errorf("Expected the port to be taken without SO_REUSEPORT")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "syscall"
+
+// setReuse sets SO_REUSEADDR and SO_REUSEPORT on a socket before it is
+// bound.
+func setReuse(fd uintptr, reuseAddr, reusePort bool) error {
+	if reuseAddr {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+			return err
+		}
+	}
+	if reusePort {
+		return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+)
+
+// serverState is the chat state that must survive a restart, saved as JSON
+// to Config.StateFile.
+type serverState struct {
+	Topic string `json:"topic,omitempty"`
+
+	// Ignores maps a user name to the names whose public and direct
+	// messages they do not want to receive.
+	Ignores map[string][]string `json:"ignores,omitempty"`
+
+	// Muted maps a user name to the notifications they turned off with
+	// /notify.
+	Muted map[string][]string `json:"muted,omitempty"`
+
+	// Profiles maps a user name to the line they set with /setinfo.
+	Profiles map[string]string `json:"profiles,omitempty"`
+
+	// Prefs maps a user name to the display settings they chose.
+	Prefs map[string]userPrefs `json:"prefs,omitempty"`
+
+	// Pins are the messages pinned with /pin.
+	Pins []Pin `json:"pins,omitempty"`
+
+	// Announcements are those scheduled with /schedule.
+	Announcements []Announcement `json:"announcements,omitempty"`
+}
+
+// loadState reads Config.StateFile. A missing file leaves the state empty.
+func (s *Server) loadState() error {
+	if s.config.StateFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.config.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state serverState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+	return nil
+}
+
+// saveState writes the current state to Config.StateFile.
+func (s *Server) saveState() error {
+	if s.config.StateFile == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.config.StateFile)
+}
+
+// topic returns the current chat topic.
+func (s *Server) topic() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.Topic
+}
+
+// setTopic changes the topic, saves it and tells everyone.
+func (s *Server) setTopic(setBy *Client, topic string) error {
+	s.mu.Lock()
+	s.state.Topic = topic
+	s.mu.Unlock()
+
+	if topic == "" {
+		s.announce(setBy.name.get()+" cleared the topic", setBy)
+	} else {
+		s.announce(setBy.name.get()+" changed the topic to: "+topic, setBy)
+	}
+	return s.saveState()
+}
+
+// ignoring reports whether name has blocked messages from sender. The
+// caller must hold s.mu.
+func (s *Server) ignoring(name, sender string) bool {
+	return sender != "" && slices.Contains(s.state.Ignores[name], sender)
+}
+
+// setIgnore adds or removes sender from name's block list and saves it.
+func (s *Server) setIgnore(name, sender string, ignore bool) error {
+	s.mu.Lock()
+	list := slices.DeleteFunc(s.state.Ignores[name], func(n string) bool { return n == sender })
+	if ignore {
+		list = append(list, sender)
+	}
+	if s.state.Ignores == nil {
+		s.state.Ignores = make(map[string][]string)
+	}
+	if len(list) == 0 {
+		delete(s.state.Ignores, name)
+	} else {
+		s.state.Ignores[name] = list
+	}
+	s.mu.Unlock()
+
+	return s.saveState()
+}
+
+// renameUser moves old's ignore list, notification and display settings
+// and profile to name, and follows the rename in everyone's ignore lists,
+// so a new name does not get around being ignored. The caller must hold
+// s.mu.
+func (st *serverState) renameUser(old, name string) {
+	if list, ok := st.Ignores[old]; ok {
+		delete(st.Ignores, old)
+		st.Ignores[name] = list
+	}
+	for user, list := range st.Ignores {
+		for i, ignored := range list {
+			if ignored == old {
+				list[i] = name
+			}
+		}
+		st.Ignores[user] = list
+	}
+	if muted, ok := st.Muted[old]; ok {
+		delete(st.Muted, old)
+		st.Muted[name] = muted
+	}
+	if profile, ok := st.Profiles[old]; ok {
+		delete(st.Profiles, old)
+		st.Profiles[name] = profile
+	}
+	if prefs, ok := st.Prefs[old]; ok {
+		delete(st.Prefs, old)
+		st.Prefs[name] = prefs
+	}
+}
+
+// profile returns the line name set with /setinfo.
+func (s *Server) profile(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.Profiles[name]
+}
+
+// setProfile sets name's profile line, or clears it if it is empty, and
+// saves it.
+func (s *Server) setProfile(name, profile string) error {
+	s.mu.Lock()
+	if s.state.Profiles == nil {
+		s.state.Profiles = make(map[string]string)
+	}
+	if profile == "" {
+		delete(s.state.Profiles, name)
+	} else {
+		s.state.Profiles[name] = profile
+	}
+	s.mu.Unlock()
+
+	return s.saveState()
+}
+
+// ignoreList returns the names name has blocked.
+func (s *Server) ignoreList(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.state.Ignores[name])
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Test that the topic is announced and survives a restart
+func TestTopicPersisted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	server := newTestServer(t, cfg)
+
+	op, output := pipeClient(t, "Op", "192.168.1.1")
+
+	if err := server.setTopic(&op, "release day"); err != nil {
+		t.Fatalf("setTopic failed: %v", err)
+	}
+
+	if !containsSubstring(server.replayHistory(), "Op changed the topic to: release day") {
+		t.Errorf("Expected the topic change to be announced, got %q", server.replayHistory())
+	}
+
+	if !containsSubstring(output(), "changed the topic") {
+		t.Errorf("Expected the operator to see the topic change, got %q", output())
+	}
+
+	restarted := newTestServer(t, cfg)
+	if err := restarted.loadState(); err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+
+	if restarted.topic() != "release day" {
+		t.Errorf("Expected topic to survive a restart, got %q", restarted.topic())
+	}
+}
+
+// Test that ignore lists block public and direct messages and are saved
+func TestIgnorePersisted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	server := newTestServer(t, cfg)
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	server.addClient(bob)
+
+	if err := server.setIgnore("Bob", "Alice", true); err != nil {
+		t.Fatalf("setIgnore failed: %v", err)
+	}
+
+	server.messageClients(*a, "\n[01-01-2025 10:00:00][Alice]:hello all", "")
+	server.sendDirect(a, []string{"Bob"}, "psst")
+
+	if containsSubstring(bobOutput(), "hello all") || containsSubstring(bobOutput(), "psst") {
+		t.Errorf("Expected Bob to receive nothing from Alice, got %q", bobOutput())
+	}
+
+	restarted := newTestServer(t, cfg)
+	if err := restarted.loadState(); err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+
+	if list := restarted.ignoreList("Bob"); len(list) != 1 || list[0] != "Alice" {
+		t.Errorf("Expected Bob's ignore list to survive a restart, got %v", list)
+	}
+}
+
+// Test that /setinfo is shown in /whois, survives a restart and can be cleared
+func TestProfilePersisted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	server := newTestServer(t, cfg)
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+
+	server.handleCommand(a, "/setinfo she/her, backend, @alice")
+	server.handleCommand(b, "/whois Alice")
+	if !containsSubstring(bobOutput(), "info: she/her, backend, @alice") {
+		t.Errorf("Expected /whois to show Alice's info, got %q", bobOutput())
+	}
+
+	restarted := newTestServer(t, cfg)
+	if err := restarted.loadState(); err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+	if profile := restarted.profile("Alice"); profile != "she/her, backend, @alice" {
+		t.Errorf("Expected Alice's info to survive a restart, got %q", profile)
+	}
+
+	server.handleCommand(a, "/setinfo")
+	if profile := server.profile("Alice"); profile != "" {
+		t.Errorf("Expected /setinfo without text to clear the info, got %q", profile)
+	}
+}
+
+// Test that display settings are applied again when the user reconnects
+// after a restart
+func TestPrefsPersisted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	server := newTestServer(t, cfg)
+
+	alice, output := pipeClient(t, "Alice", "192.168.1.1")
+	a := server.addClient(alice)
+	server.handleCommand(a, "/color on")
+	server.handleCommand(a, "/width 60")
+	server.handleCommand(a, "/prefs")
+	if !containsSubstring(output(), "saved settings: color on; width 60") {
+		t.Errorf("Expected /prefs to list the saved settings, got %q", output())
+	}
+
+	restarted := newTestServer(t, cfg)
+	if err := restarted.loadState(); err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+	again, _ := pipeClient(t, "Alice", "192.168.1.1")
+	b := restarted.addClient(again)
+	restarted.applyPrefs(b)
+	if !b.color || b.width != 60 || b.accessible {
+		t.Errorf("Expected color on and width 60 after reconnecting, got color %v width %d accessible %v", b.color, b.width, b.accessible)
+	}
+
+	restarted.handleCommand(b, "/prefs reset")
+	if prefs := restarted.describePrefs("Alice"); prefs != "no saved settings" {
+		t.Errorf("Expected /prefs reset to forget the settings, got %q", prefs)
+	}
+}
+
+// Test that an operator can pin a message, which survives a restart, and
+// unpin it
+func TestPinPersisted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	cfg.OperatorPassword = "secret"
+	server := newTestServer(t, cfg)
+
+	op, opOutput := pipeClient(t, "Op", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	o := server.addClient(op)
+	b := server.addClient(bob)
+	server.messageClients(*b, "\n[01-01-2025 10:00:00][Bob]:we ship on friday", "")
+
+	server.handleCommand(b, "/pin last")
+	if !containsSubstring(bobOutput(), "ERR_PERMISSION_DENIED") {
+		t.Errorf("Expected /pin to be for operators only, got %q", bobOutput())
+	}
+
+	server.handleCommand(o, "/oper secret")
+	server.handleCommand(o, "/pin last")
+	if !containsSubstring(bobOutput(), "Op pinned #1 [01-01-2025 10:00:00][Bob]:we ship on friday") {
+		t.Errorf("Expected the pin to be announced, got %q", bobOutput())
+	}
+	server.handleCommand(o, "/pin 1")
+	if !containsSubstring(opOutput(), "ERR_CONFLICT: #1 is already pinned") {
+		t.Errorf("Expected a second pin to be refused, got %q", opOutput())
+	}
+
+	restarted := newTestServer(t, cfg)
+	if err := restarted.loadState(); err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+	if pins := restarted.pinList(); pins != "Pinned:\n#1 [01-01-2025 10:00:00][Bob]:we ship on friday" {
+		t.Errorf("Expected the pin to survive a restart, got %q", pins)
+	}
+
+	server.handleCommand(o, "/unpin #1")
+	if len(server.pins()) != 0 {
+		t.Errorf("Expected /unpin to remove the pin, got %v", server.pins())
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// ClientRegistry tracks the clients connected to this server. The server
+// only reaches its clients through this interface, so a clustering backend
+// can provide its own.
+type ClientRegistry interface {
+	// Add registers c.
+	Add(c *Client)
+	// Remove unregisters the client connected from addr.
+	Remove(addr string)
+	// Find returns the client called name, or nil.
+	Find(name string) *Client
+	// All returns every registered client in the order they were added.
+	All() []*Client
+	// Count returns how many clients are registered.
+	Count() int
+	// Rename changes c's name to name unless another client has it,
+	// reporting whether it did, so that Find never sees both or neither.
+	Rename(c *Client, name string) bool
+}
+
+// HistoryStore holds the public message history, oldest first.
+type HistoryStore interface {
+	// Append stores m, assigning it the next message ID, and returns it.
+	Append(m Message) Message
+	// Messages returns a copy of every stored message.
+	Messages() []Message
+	// Load replaces the stored messages, keeping their IDs.
+	Load(msgs []Message)
+	// Remove deletes the messages match returns true for and reports how
+	// many were deleted.
+	Remove(match func(Message) bool) int
+}
+
+// memoryRegistry is the default ClientRegistry.
+type memoryRegistry struct {
+	mu      sync.Mutex
+	clients []*Client
+}
+
+func newMemoryRegistry() *memoryRegistry {
+	return &memoryRegistry{}
+}
+
+func (r *memoryRegistry) Add(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients = append(r.clients, c)
+}
+
+func (r *memoryRegistry) Remove(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients = slices.DeleteFunc(r.clients, func(c *Client) bool { return c.ipAdd == addr })
+}
+
+func (r *memoryRegistry) Find(name string) *Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.clients {
+		if c.name.get() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (r *memoryRegistry) All() []*Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.clients)
+}
+
+func (r *memoryRegistry) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.clients)
+}
+
+func (r *memoryRegistry) Rename(c *Client, name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.clients {
+		if other != c && other.name.get() == name {
+			return false
+		}
+	}
+	c.name.set(name)
+	return true
+}
+
+// memoryHistory is the default HistoryStore.
+type memoryHistory struct {
+	mu     sync.Mutex
+	msgs   []Message
+	lastID uint64
+}
+
+func newMemoryHistory() *memoryHistory {
+	return &memoryHistory{}
+}
+
+func (h *memoryHistory) Append(m Message) Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	m.id = h.lastID
+	h.msgs = append(h.msgs, m)
+	return m
+}
+
+func (h *memoryHistory) Messages() []Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.msgs)
+}
+
+func (h *memoryHistory) Load(msgs []Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.msgs = slices.Clone(msgs)
+	for _, m := range msgs {
+		h.lastID = max(h.lastID, m.id)
+	}
+}
+
+func (h *memoryHistory) Remove(match func(Message) bool) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	before := len(h.msgs)
+	h.msgs = slices.DeleteFunc(h.msgs, match)
+	return before - len(h.msgs)
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+// Test that the in-memory history assigns increasing IDs across loads
+func TestMemoryHistoryIDs(t *testing.T) {
+	h := newMemoryHistory()
+	h.Load([]Message{{id: 7, name: "Alice"}})
+
+	if m := h.Append(Message{name: "Bob"}); m.id != 8 {
+		t.Errorf("Expected the next ID after a load to be 8, got %d", m.id)
+	}
+
+	if removed := h.Remove(func(m Message) bool { return m.name == "Alice" }); removed != 1 {
+		t.Errorf("Expected 1 message removed, got %d", removed)
+	}
+
+	if msgs := h.Messages(); len(msgs) != 1 || msgs[0].name != "Bob" {
+		t.Errorf("Unexpected messages after Remove: %+v", msgs)
+	}
+}
+
+// Test that the in-memory registry finds and removes clients
+func TestMemoryRegistry(t *testing.T) {
+	r := newMemoryRegistry()
+	alice := mockClient("Alice", "192.168.1.1", nil)
+	r.Add(&alice)
+
+	if r.Find("Alice") != &alice || r.Find("Bob") != nil {
+		t.Errorf("Find did not return the registered client.")
+	}
+
+	r.Remove("192.168.1.1")
+	if r.Count() != 0 {
+		t.Errorf("Expected no clients after Remove, got %d", r.Count())
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hourCounter counts events over the last hour in one-minute buckets.
+type hourCounter struct {
+	buckets [60]int
+	// minute is the Unix minute the newest bucket counts.
+	minute int64
+}
+
+// advance moves the counter to the minute containing now, emptying the
+// buckets for the minutes skipped.
+func (h *hourCounter) advance(now time.Time) {
+	m := now.Unix() / 60
+	for gap := min(m-h.minute, 60); gap > 0; gap-- {
+		h.buckets[(m-gap+1)%60] = 0
+	}
+	if m > h.minute {
+		h.minute = m
+	}
+}
+
+func (h *hourCounter) add(now time.Time) {
+	h.advance(now)
+	h.buckets[h.minute%60]++
+}
+
+// thisMinute returns how many events were added in the minute containing
+// now.
+func (h *hourCounter) thisMinute(now time.Time) int {
+	h.advance(now)
+	return h.buckets[h.minute%60]
+}
+
+// count returns how many events were added in the hour up to now.
+func (h *hourCounter) count(now time.Time) int {
+	h.advance(now)
+	total := 0
+	for _, n := range h.buckets {
+		total += n
+	}
+	return total
+}
+
+// countMessage records that client sent a chat message.
+func (s *Server) countMessage(client *Client) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client.messages++
+	client.lastHour.add(now)
+	s.lastHour.add(now)
+	s.roomMessages.inc(s.roomLabel(client.room))
+}
+
+// talker is one line of the /top report.
+type talker struct {
+	name            string
+	total, lastHour int
+}
+
+// topTalkers returns the n connected clients that sent the most messages
+// in the last hour, busiest first, breaking ties by messages since they
+// connected.
+func (s *Server) topTalkers(n int) []talker {
+	clients := s.clients.All()
+	now := time.Now()
+
+	s.mu.Lock()
+	talkers := make([]talker, 0, len(clients))
+	for _, c := range clients {
+		talkers = append(talkers, talker{name: c.name.get(), total: c.messages, lastHour: c.lastHour.count(now)})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(talkers, func(i, j int) bool {
+		a, b := talkers[i], talkers[j]
+		if a.lastHour != b.lastHour {
+			return a.lastHour > b.lastHour
+		}
+		if a.total != b.total {
+			return a.total > b.total
+		}
+		return a.name < b.name
+	})
+	return talkers[:min(n, len(talkers))]
+}
+
+// messageStats summarises chat traffic for /stats.
+func (s *Server) messageStats() string {
+	s.mu.Lock()
+	lastHour := s.lastHour.count(time.Now())
+	s.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "messages: %d in the last hour", lastHour)
+	if top := s.topTalkers(1); len(top) > 0 && top[0].lastHour > 0 {
+		fmt.Fprintf(&b, ", most from %s (%d)", top[0].name, top[0].lastHour)
+	}
+	return b.String()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that the hour counter forgets messages older than an hour
+func TestHourCounter(t *testing.T) {
+	var h hourCounter
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	h.add(start)
+	h.add(start.Add(30 * time.Minute))
+	h.add(start.Add(30 * time.Minute))
+
+	if n := h.count(start.Add(59 * time.Minute)); n != 3 {
+		t.Errorf("Expected 3 messages within the hour, got %d", n)
+	}
+	if n := h.count(start.Add(61 * time.Minute)); n != 2 {
+		t.Errorf("Expected the first message to have expired, got %d", n)
+	}
+	if n := h.count(start.Add(3 * time.Hour)); n != 0 {
+		t.Errorf("Expected every message to have expired, got %d", n)
+	}
+}
+
+// Test that /top lists the busiest clients first
+func TestTopTalkers(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, _ := pipeClient(t, "Bob", "192.168.1.2")
+	op, opOutput := pipeClient(t, "Op", "192.168.1.3")
+	op.operator = true
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+	o := server.addClient(op)
+
+	server.countMessage(a)
+	for range 3 {
+		server.countMessage(b)
+	}
+
+	server.handleCommand(o, "/top 2")
+	want := "1. Bob: 3 in the last hour, 3 since joining\n2. Alice: 1 in the last hour, 1 since joining"
+	if !containsSubstring(opOutput(), want) {
+		t.Errorf("Expected %q, got %q", want, opOutput())
+	}
+
+	server.handleCommand(o, "/stats")
+	if !containsSubstring(opOutput(), "messages: 4 in the last hour, most from Bob (3)") {
+		t.Errorf("Expected /stats to report traffic, got %q", opOutput())
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Everything the server says to people is rendered from a named
+// text/template, so deployments can reword or rebrand it through
+// Config.Templates without recompiling. Each template is given a map of
+// the fields listed for it below; using any other field is an error found
+// when the config is validated. The prompts and error lines are not
+// templates: linked servers, upstream relays and the Go library wait for
+// the name prompt and the chat prompt, and clients read the code at the
+// start of every error line.
+type outputTemplate struct {
+	text   string
+	fields []string
+}
+
+var outputTemplates = map[string]outputTemplate{
+	"banner":         {welcomeBanner, nil},
+	"help":           {"{{.Usage}} - {{.Help}}", []string{"Command", "Usage", "Help"}},
+	"topic":          {"Topic: {{.Topic}}", []string{"Topic"}},
+	"currently_here": {"Currently here: {{.Names}}", []string{"Names"}},
+	"announcement":   {"*** ANNOUNCEMENT: {{.Text}}", []string{"Text"}},
+	"global":         {"*** GLOBAL [{{.Name}}]: {{.Text}}", []string{"Name", "Text"}},
+	// Config.JoinMessage and LeaveMessage set these two, which an empty
+	// text turns off. Time is when it happened, Room the room it happened
+	// in, or the server name in the main chat, and Online e.g. "(7 users
+	// online)".
+	"join":   {defaultJoinMessage, []string{"Name", "Time", "Room", "Online"}},
+	"leave":  {defaultLeaveMessage, []string{"Name", "Time", "Room", "Online"}},
+	"rename": {"{{.Old}} is now known as {{.Name}}", []string{"Old", "Name"}},
+	// unread_marker comes before the messages a resumed session missed,
+	// Count being e.g. "12 unread messages".
+	"unread_marker": {"---- you have {{.Count}} ----", []string{"Count"}},
+	// share announces a snippet, Lines being e.g. "42 lines".
+	"share": {"{{.Name}} shared snippet #{{.ID}} ({{.Lines}}), /get {{.ID}}", []string{"Name", "ID", "Lines"}},
+	// churn sums up the notices held back by Config.NoticeBatchWindow,
+	// Summary being e.g. "5 users joined, 3 left".
+	"churn": {"{{.Summary}} {{.Online}}", []string{"Summary", "Time", "Room", "Online"}},
+	// repeated stands for a notice repeated within Config.DedupeWindow,
+	// Times being e.g. "3 more times".
+	"repeated": {"{{.Text}} (repeated {{.Times}})", []string{"Text", "Times"}},
+}
+
+// Default join and leave notices.
+const (
+	defaultJoinMessage  = "{{.Name}} has joined our chat... {{.Online}}"
+	defaultLeaveMessage = "{{.Name}} has left our chat..."
+)
+
+// parseTemplates parses every output template, taking the text from
+// overrides where it has one. Templates with an empty text are left out.
+func parseTemplates(overrides map[string]string) (map[string]*template.Template, error) {
+	for name := range overrides {
+		if _, ok := outputTemplates[name]; !ok {
+			return nil, fmt.Errorf("unknown template %q, want one of %s", name, strings.Join(slices.Sorted(maps.Keys(outputTemplates)), ", "))
+		}
+	}
+
+	parsed := make(map[string]*template.Template, len(outputTemplates))
+	for name, def := range outputTemplates {
+		text, ok := overrides[name]
+		if !ok {
+			text = def.text
+		}
+		if text == "" {
+			continue
+		}
+
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		sample := make(map[string]string)
+		for _, f := range def.fields {
+			sample[f] = f
+		}
+		if err := tmpl.Execute(io.Discard, sample); err != nil {
+			return nil, err
+		}
+		parsed[name] = tmpl
+	}
+	return parsed, nil
+}
+
+// templateOverrides gathers the template texts the config replaces.
+func (c Config) templateOverrides() map[string]string {
+	overrides := maps.Clone(c.Templates)
+	if overrides == nil {
+		overrides = make(map[string]string)
+	}
+	overrides["join"] = c.JoinMessage
+	overrides["leave"] = c.LeaveMessage
+	return overrides
+}
+
+// text renders the template called name with data, reporting false if it
+// is turned off or fails.
+func (s *Server) text(name string, data map[string]string) (string, bool) {
+	tmpl := s.templates[name]
+	if tmpl == nil {
+		return "", false
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		fmt.Printf("Error rendering %s template: %v\n", name, err)
+		return "", false
+	}
+	return b.String(), true
+}
+
+// mustText is text for templates that cannot be turned off.
+func (s *Server) mustText(name string, data map[string]string) string {
+	text, _ := s.text(name, data)
+	return text
+}
+
+// errorText renders err for a client, starting with its code.
+func (s *Server) errorText(err error) string {
+	ce := asClientError(err)
+	return ce.Code + ": " + ce.Message
+}
+
+// notice renders the join or leave notice for client, reporting false if
+// it is turned off.
+func (s *Server) notice(name string, client *Client) (string, bool) {
+	if (name == "join" && !s.config.AnnounceJoins) || (name == "leave" && !s.config.AnnounceLeaves) {
+		return "", false
+	}
+	return s.text(name, map[string]string{
+		"Name":   client.name.get(),
+		"Time":   time.Now().Format("02-01-2006 15:04:05"),
+		"Room":   s.roomLabel(client.room),
+		"Online": s.onlineCount(),
+	})
+}
+
+// noticePattern matches the notices the template called name produces for
+// user, whatever the time, room, online count and churn summary were.
+func (s *Server) noticePattern(name, user string) *regexp.Regexp {
+	const userMark, anyMark, summaryMark = "\x00name\x00", "\x00any\x00", "\x00summary\x00"
+	text, ok := s.text(name, map[string]string{"Name": userMark, "Time": anyMark, "Room": anyMark, "Online": anyMark, "Summary": summaryMark})
+	if !ok {
+		return nil
+	}
+	pattern := regexp.QuoteMeta(text)
+	pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(userMark), regexp.QuoteMeta(user))
+	pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(anyMark), ".*")
+	pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(summaryMark), `\d+ users? (?:joined|left)(?:, \d+ left)?`)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// isNoticeFor reports whether a log line is a join or leave notice for
+// name.
+func (s *Server) isNoticeFor(line, name string) bool {
+	for _, notice := range []string{"join", "leave"} {
+		if re := s.noticePattern(notice, name); re != nil && re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
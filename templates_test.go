@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that join and leave notices follow their templates and can be
+// forgotten
+func TestNoticeTemplates(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LogFile = filepath.Join(t.TempDir(), "server_log.txt")
+	cfg.ServerName = "lobby"
+	cfg.JoinMessage = "-> {{.Name}} entered {{.Room}} at {{.Time}}"
+	cfg.LeaveMessage = ""
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, cfg)
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	text, ok := server.notice("join", &alice)
+	if !ok || !containsSubstring(text, "-> Alice entered lobby at ") {
+		t.Errorf("Unexpected join notice %q", text)
+	}
+	if _, ok := server.notice("leave", &alice); ok {
+		t.Errorf("Expected the leave notice to be turned off.")
+	}
+
+	server.messageClients(alice, "\n"+text, "")
+	server.messageClients(alice, "\n-> Alicia entered lobby at 01-01-2025 10:00:00", "")
+	if _, err := server.Forget("Alice", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(cfg.LogFile)
+	if string(data) != "\n-> Alicia entered lobby at 01-01-2025 10:00:00" {
+		t.Errorf("Expected only Alice's notice to be forgotten, got %q", data)
+	}
+
+	cfg.JoinMessage = "{{.Nickname}} joined"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected an unknown field to be refused.")
+	}
+}
+
+// Test that configured templates replace the built-in wording
+func TestOutputTemplates(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LogFile = ""
+	cfg.Templates = map[string]string{
+		"help": "{{.Command}}: {{.Help}}",
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, cfg)
+
+	if text := server.mustText("help", map[string]string{"Command": "/who", "Usage": "/who", "Help": "list users"}); text != "/who: list users" {
+		t.Errorf("Unexpected help text %q", text)
+	}
+	if text := server.mustText("topic", map[string]string{"Topic": "cats"}); text != "Topic: cats" {
+		t.Errorf("Expected the default topic text, got %q", text)
+	}
+
+	cfg.Templates = map[string]string{"footer": "bye"}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected an unknown template to be refused.")
+	}
+	for _, name := range []string{"name_prompt", "prompt", "error"} {
+		cfg.Templates = map[string]string{name: "{{.Name}}> "}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %s not to be a template, as clients wait for or parse it.", name)
+		}
+	}
+	cfg.Templates = map[string]string{"help": "{{.Reason}}"}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected an unknown field to be refused.")
+	}
+}
+
+// Test that AnnounceJoins and AnnounceLeaves turn the notices off without
+// touching the templates
+func TestAnnounceToggles(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AnnounceJoins = false
+	server := newTestServer(t, cfg)
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	if _, ok := server.notice("join", &alice); ok {
+		t.Errorf("Expected the join notice to be turned off.")
+	}
+	if _, ok := server.notice("leave", &alice); !ok {
+		t.Errorf("Expected the leave notice to be sent.")
+	}
+	if !server.isNoticeFor("Alice has joined our chat... (1 user online)", "Alice") {
+		t.Errorf("Expected join notices to still be recognised.")
+	}
+}
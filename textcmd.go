@@ -0,0 +1,47 @@
+package main
+
+import "fmt"
+
+// TextCommand is a command defined in the config, such as /rules, that
+// sends fixed text to whoever runs it or, with Broadcast, to everyone.
+type TextCommand struct {
+	Text string `json:"text"`
+	// Help describes the command in /help.
+	Help      string `json:"help"`
+	Broadcast bool   `json:"broadcast"`
+	// Operator limits the command to operators.
+	Operator bool `json:"operator"`
+}
+
+// command returns the command that runs t.
+func (t TextCommand) command(name string) command {
+	help := t.Help
+	if help == "" {
+		help = "show the " + name[1:] + " text"
+	}
+	return command{usage: name, help: help, operator: t.Operator, posts: t.Broadcast, run: func(s *Server, client *Client, args []string) {
+		if t.Broadcast {
+			s.announce(t.Text, client)
+		} else {
+			s.reply(client, t.Text)
+		}
+	}}
+}
+
+// checkTextCommands reports an error for a text command that has no text,
+// does not start with a slash or would replace another command.
+func checkTextCommands(cmds map[string]TextCommand, plugins []string) error {
+	others := availableCommands(Config{Plugins: plugins})
+	for name, t := range cmds {
+		switch {
+		case len(name) < 2 || name[0] != '/':
+			return fmt.Errorf("text command %q must start with /", name)
+		case t.Text == "":
+			return fmt.Errorf("text command %s has no text", name)
+		}
+		if _, ok := others[name]; ok {
+			return fmt.Errorf("text command %s would replace the command of the same name", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// throttledConn limits how fast data is written to a connection with a
+// token bucket that refills at rate bytes per second and holds at most one
+// second's worth, so a long history replay to one client cannot use up the
+// bandwidth the others need.
+type throttledConn struct {
+	net.Conn
+	rate int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newThrottledConn(conn net.Conn, rate int) *throttledConn {
+	return &throttledConn{Conn: conn, rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// Write sends p in pieces no larger than the bucket, waiting for each piece
+// to be paid for before sending it.
+func (c *throttledConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	written := 0
+	for written < len(p) {
+		chunk := min(len(p)-written, c.rate)
+		c.wait(chunk)
+		n, err := c.Conn.Write(p[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// wait blocks until n tokens are available and takes them.
+func (c *throttledConn) wait(n int) {
+	now := time.Now()
+	c.tokens = min(c.tokens+now.Sub(c.last).Seconds()*float64(c.rate), float64(c.rate))
+	c.last = now
+
+	c.tokens -= float64(n)
+	if c.tokens < 0 {
+		time.Sleep(time.Duration(-c.tokens / float64(c.rate) * float64(time.Second)))
+	}
+}
+
+// throttle applies the configured send rate to conn.
+func (s *Server) throttle(conn net.Conn) net.Conn {
+	if s.config.ClientSendRate <= 0 {
+		return conn
+	}
+	return newThrottledConn(conn, s.config.ClientSendRate)
+}
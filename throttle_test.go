@@ -0,0 +1,24 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Test that writes beyond the burst are held to the configured rate
+func TestThrottledConn(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	go io.Copy(io.Discard, peer)
+
+	throttled := newThrottledConn(conn, 1000)
+	start := time.Now()
+	if n, err := throttled.Write(make([]byte, 1500)); n != 1500 || err != nil {
+		t.Fatalf("Expected to write 1500 bytes, got %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("Expected 500 bytes over the burst to take half a second, took %v", elapsed)
+	}
+}
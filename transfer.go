@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files are sent between two clients through the server, which never
+// stores them. The sender offers a file with "/sendfile <user> <size>
+// <name>", and the receiver answers "/accept <id>" or "/reject <id>".
+// Once accepted, the sender streams the file as base64 chunks with
+// "/filedata <id> <chunk>" and ends it with a bare "/filedata <id>". The
+// receiver is sent lines a client can reassemble the file from:
+//
+//	file offer 3 from Alice: notes.txt (1204 bytes), /accept 3 or /reject 3
+//	file data 3 aGVsbG8...
+//	file done 3: notes.txt (1204 bytes) from Alice
+//
+// and "file cancelled 3: ..." if the transfer is abandoned. The server
+// enforces Config.MaxFileSize on the offered size and stops a transfer
+// whose data runs past it.
+
+// transfer is a file offered by one client to another.
+type transfer struct {
+	id       uint64
+	from, to *Client
+	name     string
+	size     int64
+	received int64
+	accepted bool
+}
+
+// describe names the file and its size, such as "notes.txt (1204 bytes)".
+func (t *transfer) describe() string {
+	return fmt.Sprintf("%s (%d bytes)", t.name, t.size)
+}
+
+// offerFile records a file from sender to the user name, unless they are
+// ignoring the sender, and tells them about it.
+func (s *Server) offerFile(sender *Client, name string, size int64, file string) {
+	to := s.findClient(name)
+	if to == nil {
+		s.replyError(sender, newClientError(ErrCodeNoSuchUser, "not connected: "+name))
+		return
+	}
+	if to == sender {
+		s.replyError(sender, newClientError(ErrCodeInvalidArgument, "you cannot send a file to yourself"))
+		return
+	}
+
+	s.mu.Lock()
+	s.transferSeq++
+	t := &transfer{id: s.transferSeq, from: sender, to: to, name: file, size: size}
+	blocked := s.ignoring(to.name.get(), sender.name.get())
+	if !blocked {
+		if s.transfers == nil {
+			s.transfers = make(map[uint64]*transfer)
+		}
+		s.transfers[t.id] = t
+	}
+	s.mu.Unlock()
+
+	// Offers to someone ignoring the sender are dropped silently, like
+	// direct messages, so the sender cannot tell.
+	s.fileReply(sender, fmt.Sprintf("file offer %d sent to %s: %s", t.id, to.name.get(), t.describe()))
+	if !blocked {
+		s.frame(to, fmt.Sprintf("file offer %d from %s: %s, /accept %d or /reject %d", t.id, sender.name.get(), t.describe(), t.id, t.id))
+	}
+}
+
+// findTransfer returns the transfer id that client takes part in as the
+// sender, if sending, or otherwise as the receiver.
+func (s *Server) findTransfer(client *Client, id string, sending bool) (*transfer, error) {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, newClientError(ErrCodeInvalidArgument, "not a file transfer number: "+id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.transfers[n]
+	if t == nil || (sending && t.from != client) || (!sending && t.to != client) {
+		return nil, newClientError(ErrCodeNotFound, fmt.Sprintf("no file transfer %d for you", n))
+	}
+	return t, nil
+}
+
+// answerFile accepts or rejects the transfer id offered to client.
+func (s *Server) answerFile(client *Client, id string, accept bool) {
+	t, err := s.findTransfer(client, id, false)
+	if err != nil {
+		s.replyError(client, err)
+		return
+	}
+
+	s.mu.Lock()
+	already := t.accepted
+	if accept {
+		t.accepted = true
+	} else {
+		delete(s.transfers, t.id)
+	}
+	s.mu.Unlock()
+	if already {
+		s.replyError(client, newClientError(ErrCodeConflict, fmt.Sprintf("file %d is already being received", t.id)))
+		return
+	}
+
+	if accept {
+		s.fileReply(client, fmt.Sprintf("receiving file %d from %s: %s", t.id, t.from.name.get(), t.describe()))
+		s.frame(t.from, fmt.Sprintf("file accepted %d: %s is ready for %s", t.id, client.name.get(), t.name))
+	} else {
+		s.fileReply(client, fmt.Sprintf("file %d rejected", t.id))
+		s.frame(t.from, fmt.Sprintf("file rejected %d: %s declined %s", t.id, client.name.get(), t.name))
+	}
+}
+
+// fileData passes a base64 chunk of the transfer id from client on to its
+// receiver, or finishes the transfer if chunk is empty.
+func (s *Server) fileData(client *Client, id, chunk string) {
+	t, err := s.findTransfer(client, id, true)
+	if err != nil {
+		s.replyError(client, err)
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(chunk)
+	if err != nil {
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, fmt.Sprintf("file %d data is not base64", t.id)))
+		return
+	}
+
+	s.mu.Lock()
+	accepted := t.accepted
+	if accepted {
+		t.received += int64(len(data))
+	}
+	received := t.received
+	s.mu.Unlock()
+
+	switch {
+	case !accepted:
+		s.replyError(client, newClientError(ErrCodeConflict, fmt.Sprintf("file %d has not been accepted yet", t.id)))
+	case received > t.size:
+		s.cancelTransfer(t, client, "more data than the offered size")
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, fmt.Sprintf("file %d is larger than the %d bytes offered", t.id, t.size)))
+	case chunk != "":
+		s.frame(t.to, fmt.Sprintf("file data %d %s", t.id, chunk))
+	case received < t.size:
+		s.cancelTransfer(t, client, "less data than the offered size")
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, fmt.Sprintf("file %d ended after %d of the %d bytes offered", t.id, received, t.size)))
+	default:
+		s.mu.Lock()
+		delete(s.transfers, t.id)
+		s.mu.Unlock()
+		s.frame(t.to, fmt.Sprintf("file done %d: %s from %s", t.id, t.describe(), client.name.get()))
+		s.fileReply(client, fmt.Sprintf("file sent %d: %s to %s", t.id, t.describe(), t.to.name.get()))
+	}
+}
+
+// cancelTransfer abandons t because of what by did, and tells both ends
+// why.
+func (s *Server) cancelTransfer(t *transfer, by *Client, reason string) {
+	s.mu.Lock()
+	_, ok := s.transfers[t.id]
+	delete(s.transfers, t.id)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	for _, c := range []*Client{t.from, t.to} {
+		if c == by {
+			s.fileReply(c, fmt.Sprintf("file cancelled %d: %s", t.id, reason))
+		} else {
+			s.frame(c, fmt.Sprintf("file cancelled %d: %s", t.id, reason))
+		}
+	}
+}
+
+// cancelTransfers abandons the transfers client takes part in, once it has
+// left.
+func (s *Server) cancelTransfers(client *Client) {
+	s.mu.Lock()
+	var abandoned []*transfer
+	for _, t := range s.transfers {
+		if t.from == client || t.to == client {
+			abandoned = append(abandoned, t)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, t := range abandoned {
+		s.mu.Lock()
+		delete(s.transfers, t.id)
+		s.mu.Unlock()
+		other := t.to
+		if other == client {
+			other = t.from
+		}
+		s.frame(other, fmt.Sprintf("file cancelled %d: %s left", t.id, client.name.get()))
+	}
+}
+
+// frame writes a line meant for programs to c as it is, such as a file
+// transfer line or a read receipt: wrapping or formatting would break the
+// base64 data, and clients would not recognise the line.
+func (s *Server) frame(c *Client, line string) {
+	tf := "[" + time.Now().Format("02-01-2006 15:04:05") + "]"
+	s.write(c, "\n"+line, tf, priorityChat)
+}
+
+// fileReply answers a transfer command from c, as it is like frame.
+func (s *Server) fileReply(c *Client, line string) {
+	c.conn.Write([]byte(line + "\n"))
+}
+
+func cmdSendFile(s *Server, client *Client, args []string) {
+	if len(args) < 3 {
+		s.replyUsage(client, "/sendfile")
+		return
+	}
+	limit := s.config.MaxFileSize
+	if limit <= 0 {
+		s.replyError(client, newClientError(ErrCodeDisabled, "file transfers are turned off"))
+		return
+	}
+	size, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || size <= 0 {
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, "size must be a number of bytes"))
+		return
+	}
+	if size > limit {
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, fmt.Sprintf("files can be at most %d bytes", limit)))
+		return
+	}
+	// Only the base name is passed on, so the receiver is never told
+	// where to write.
+	name := path.Base(strings.ReplaceAll(strings.Join(args[2:], " "), `\`, "/"))
+	if name == "/" || name == "." || name == ".." {
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, "the file needs a name"))
+		return
+	}
+	s.offerFile(client, args[0], size, name)
+}
+
+func cmdAcceptFile(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/accept")
+		return
+	}
+	s.answerFile(client, args[0], true)
+}
+
+func cmdRejectFile(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/reject")
+		return
+	}
+	s.answerFile(client, args[0], false)
+}
+
+func cmdFileData(s *Server, client *Client, args []string) {
+	switch len(args) {
+	case 1:
+		s.fileData(client, args[0], "")
+	case 2:
+		s.fileData(client, args[0], args[1])
+	default:
+		s.replyUsage(client, "/filedata")
+	}
+}
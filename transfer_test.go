@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"net-cat/pkg/client"
+)
+
+// Test that a file offered with the library is sent once accepted and
+// arrives whole
+func TestSendFile(t *testing.T) {
+	cfg := DefaultConfig()
+	server := newTestServer(t, cfg)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.handleConn(conn)
+		}
+	}()
+	addr := ln.Addr().String()
+
+	alice, err := client.Dial(addr, "Alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	bob, err := client.Dial(addr, "Bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 300)
+	if err := alice.SendFile("Bob", "notes.txt", data); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-bob.Messages():
+			switch ev := ev.(type) {
+			case client.FileOffer:
+				if ev.From != "Alice" || ev.Name != "notes.txt" || ev.Size != int64(len(data)) {
+					t.Fatalf("Expected an offer of notes.txt from Alice, got %+v", ev)
+				}
+				if err := bob.Accept(ev.ID); err != nil {
+					t.Fatal(err)
+				}
+			case client.File:
+				if ev.Name != "notes.txt" || !bytes.Equal(ev.Data, data) {
+					t.Fatalf("Expected notes.txt to arrive whole, got %d bytes", len(ev.Data))
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for the file.")
+		}
+	}
+}
+
+// Test that the server enforces the size limit and the offered size
+func TestSendFileLimits(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.config.MaxFileSize = 100
+
+	alice, aliceOutput := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+
+	server.handleCommand(a, "/sendfile Bob 500 big.bin")
+	if !containsSubstring(aliceOutput(), "ERR_INVALID_ARGUMENT: files can be at most 100 bytes") {
+		t.Errorf("Expected an oversized offer to be refused, got %q", aliceOutput())
+	}
+
+	server.handleCommand(a, "/sendfile Bob 3 ../../etc/passwd")
+	if !containsSubstring(bobOutput(), "file offer 1 from Alice: passwd (3 bytes), /accept 1 or /reject 1") {
+		t.Fatalf("Expected Bob to be offered passwd, got %q", bobOutput())
+	}
+
+	server.handleCommand(a, "/filedata 1 aGk=")
+	if !containsSubstring(aliceOutput(), "ERR_CONFLICT: file 1 has not been accepted yet") {
+		t.Errorf("Expected data before accepting to be refused, got %q", aliceOutput())
+	}
+
+	server.handleCommand(b, "/accept 1")
+	server.handleCommand(a, "/filedata 1 aGVsbG8=")
+	if !containsSubstring(aliceOutput(), "ERR_INVALID_ARGUMENT: file 1 is larger than the 3 bytes offered") {
+		t.Errorf("Expected data past the offered size to be refused, got %q", aliceOutput())
+	}
+	if !containsSubstring(bobOutput(), "file cancelled 1: more data than the offered size") {
+		t.Errorf("Expected Bob to be told the transfer was cancelled, got %q", bobOutput())
+	}
+	if containsSubstring(bobOutput(), "file data 1") {
+		t.Errorf("Expected the oversized chunk not to reach Bob, got %q", bobOutput())
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// tunable is a limit operators can change with /set while the server
+// runs. The new value applies from the next check: max_clients to the next
+// connection and max_message_size to each client's next read.
+type tunable struct {
+	// least and most are the smallest and largest values allowed; most
+	// is zero if there is no largest.
+	least, most int64
+	value       func(s *Server) *atomic.Int64
+}
+
+var tunables = map[string]tunable{
+	"max_clients":        {0, 0, func(s *Server) *atomic.Int64 { return &s.maxClients }},
+	"max_message_size":   {1, maxMaxMessageSize, func(s *Server) *atomic.Int64 { return &s.maxMessageSize }},
+	"message_rate_limit": {0, 0, func(s *Server) *atomic.Int64 { return &s.messageRateLimit }},
+}
+
+// initTunables loads the tunable limits, and whether the chat is
+// announcement-only, from the config.
+func (s *Server) initTunables() {
+	s.maxClients.Store(int64(s.config.MaxClients))
+	size := s.config.MaxMessageSize
+	if size <= 0 {
+		size = defaultMaxMessageSize
+	}
+	s.maxMessageSize.Store(int64(size))
+	s.messageRateLimit.Store(int64(s.config.MessageRateLimit))
+	s.announceOnly.Store(s.config.AnnounceOnly)
+}
+
+// runningConfig returns the config with the tunable limits as they are
+// now.
+func (s *Server) runningConfig() Config {
+	cfg := s.config
+	cfg.MaxClients = int(s.maxClients.Load())
+	cfg.MaxMessageSize = int(s.maxMessageSize.Load())
+	cfg.MessageRateLimit = int(s.messageRateLimit.Load())
+	cfg.AnnounceOnly = s.announceOnly.Load()
+	return cfg
+}
+
+// setTunable changes the tunable limit called name to value.
+func (s *Server) setTunable(name, value string) error {
+	t, ok := tunables[name]
+	if !ok {
+		return newClientError(ErrCodeNotFound, fmt.Sprintf("%s cannot be changed while running, only %s", name, strings.Join(slices.Sorted(maps.Keys(tunables)), ", ")))
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	switch {
+	case t.most > 0 && (err != nil || n < t.least || n > t.most):
+		return newClientError(ErrCodeInvalidArgument, fmt.Sprintf("%s must be a whole number from %d to %d", name, t.least, t.most))
+	case err != nil || n < t.least:
+		return newClientError(ErrCodeInvalidArgument, fmt.Sprintf("%s must be a whole number of at least %d", name, t.least))
+	}
+	t.value(s).Store(n)
+	return nil
+}
+
+// allowMessage reports whether client may send another chat message under
+// a message_rate_limit of limit, as roomLimits gives it.
+func (s *Server) allowMessage(client *Client, limit int64) bool {
+	if limit <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(client.lastHour.thisMinute(time.Now())) < limit
+}
+
+func cmdSet(s *Server, client *Client, args []string) {
+	if len(args) == 0 {
+		var lines []string
+		for _, name := range slices.Sorted(maps.Keys(tunables)) {
+			lines = append(lines, fmt.Sprintf("%s: %d", name, tunables[name].value(s).Load()))
+		}
+		s.reply(client, strings.Join(lines, "\n"))
+		return
+	}
+	if len(args) != 2 {
+		s.replyUsage(client, "/set")
+		return
+	}
+	if err := s.setTunable(args[0], args[1]); err != nil {
+		s.replyError(client, err)
+		return
+	}
+	fmt.Printf("%s %s set %s to %s\n", client.connID, client.name.get(), args[0], args[1])
+	s.reply(client, args[0]+" is now "+args[1])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that operators can change limits with /set and they apply to the
+// next check
+func TestSetTunables(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	op, output := pipeClient(t, "Op", "192.168.1.1")
+	op.operator = true
+	server.addClient(op)
+
+	server.handleCommand(&op, "/set max_clients 1")
+	if !containsSubstring(output(), "max_clients is now 1") || !server.isFull() {
+		t.Errorf("Expected the server to be full after lowering max_clients, got %q", output())
+	}
+	server.handleCommand(&op, "/config max_clients")
+	if !containsSubstring(output(), "max_clients: 1") {
+		t.Errorf("Expected /config to show the new value, got %q", output())
+	}
+
+	server.handleCommand(&op, "/set max_message_size 0")
+	if !containsSubstring(output(), "ERR_INVALID_ARGUMENT") || server.maxMessageSize.Load() != defaultMaxMessageSize {
+		t.Errorf("Expected a zero message size to be refused, got %q", output())
+	}
+	server.handleCommand(&op, "/set max_message_size 1000000000")
+	if !containsSubstring(output(), "max_message_size must be a whole number from 1 to 65536") || server.maxMessageSize.Load() != defaultMaxMessageSize {
+		t.Errorf("Expected a huge message size to be refused, got %q", output())
+	}
+	cfg := DefaultConfig()
+	cfg.MaxMessageSize = maxMaxMessageSize + 1
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a huge max_message_size to be refused in the config")
+	}
+	server.handleCommand(&op, "/set log_file other.txt")
+	if !containsSubstring(output(), "ERR_NOT_FOUND: log_file cannot be changed while running") {
+		t.Errorf("Expected log_file to be refused, got %q", output())
+	}
+
+	server.handleCommand(&op, "/set message_rate_limit 2")
+	rate, _ := server.roomLimits(&op)
+	for i := range 3 {
+		if allowed := server.allowMessage(&op, rate); allowed != (i < 2) {
+			t.Errorf("Message %d: expected allowed to be %v", i+1, i < 2)
+		}
+		server.countMessage(&op)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In relay mode the server joins Config.Upstream as an ordinary client
+// called Config.UpstreamName. Messages from upstream are shown to local
+// clients, and local messages are posted upstream, so a LAN-only server can
+// be bridged to a public one without either needing link support.
+
+// upstreamConn is the live connection to the upstream server.
+type upstreamConn struct {
+	conn net.Conn
+
+	// mu serialises writes to conn.
+	mu sync.Mutex
+}
+
+// dialUpstream keeps the server connected to Config.Upstream, reconnecting
+// after a delay whenever the connection drops.
+func (s *Server) dialUpstream() {
+	if s.config.Upstream == "" {
+		return
+	}
+
+	go func() {
+		for {
+			if err := s.runUpstream(); err != nil {
+				fmt.Println("upstream", s.config.Upstream, "failed:", err)
+			}
+			select {
+			case <-s.quitch:
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}()
+}
+
+// runUpstream joins the upstream server and mirrors its messages locally
+// until the connection drops.
+func (s *Server) runUpstream() error {
+	conn, err := net.DialTimeout("tcp", s.config.Upstream, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	if err := readUntil(reader, namePrompt); err != nil {
+		return err
+	}
+	name := s.config.UpstreamName
+	if name == "" {
+		name = "relay-" + s.config.ServerName
+	}
+	fmt.Fprintf(conn, "%s\n", name)
+
+	// Skip the history replay: everything up to our first prompt was
+	// already said before we joined.
+	prompt := regexp.MustCompile(`\[\d\d-\d\d-\d{4} \d\d:\d\d:\d\d\]\[` + regexp.QuoteMeta(name) + `\]:`)
+	var replay strings.Builder
+	for !prompt.MatchString(replay.String()) {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		replay.WriteByte(b)
+	}
+
+	up := &upstreamConn{conn: conn}
+	s.mu.Lock()
+	s.upstream = up
+	s.mu.Unlock()
+	fmt.Println("relaying to upstream", s.config.Upstream)
+
+	defer func() {
+		s.mu.Lock()
+		s.upstream = nil
+		s.mu.Unlock()
+	}()
+
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(prompt.ReplaceAllString(line, ""))
+		if line != "" {
+			tf := "[" + time.Now().Format("02-01-2006 15:04:05") + "]"
+			s.broadcastLocal(Client{name: newClientName(senderName(line)), ipAdd: "upstream"}, "\n"+line, tf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// upstreamMessage posts a message broadcast on this server to the upstream
+// server, without its timestamp since upstream adds its own.
+func (s *Server) upstreamMessage(message string) {
+	s.mu.Lock()
+	up := s.upstream
+	s.mu.Unlock()
+	if up == nil {
+		return
+	}
+
+	line := strings.TrimPrefix(message, "\n")
+	if _, ok := lineTime(line); ok {
+		line = line[strings.Index(line, "]")+1:]
+	}
+
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	if _, err := up.conn.Write([]byte(line + "\n")); err != nil {
+		up.conn.Close()
+	}
+}
+
+// senderName returns the name in a "[time][name]:text" line, or "" for
+// notices.
+func senderName(line string) string {
+	if _, ok := lineTime(line); !ok {
+		return ""
+	}
+	rest := line[strings.Index(line, "]")+1:]
+	end := strings.Index(rest, "]:")
+	if !strings.HasPrefix(rest, "[") || end < 0 {
+		return ""
+	}
+	return rest[1:end]
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// Test that a relay mirrors messages to and from its upstream server
+func TestUpstreamRelay(t *testing.T) {
+	upstream := linkedServer(t, "public")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	defer upstream.Stop()
+	upstream.ln = ln
+	go upstream.acceptLoop()
+
+	leaf := linkedServer(t, "lan")
+	leaf.config.Upstream = ln.Addr().String()
+	go leaf.runUpstream()
+
+	bob, bobOutput := pipeClient(t, "Bob", "10.0.0.2")
+	leaf.addClient(bob)
+
+	// Wait for the relay to join upstream before a public user arrives.
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if err := readUntil(reader, namePrompt); err != nil {
+		t.Fatalf("reading banner: %v", err)
+	}
+	conn.Write([]byte("Alice\n"))
+	time.Sleep(50 * time.Millisecond)
+	conn.Write([]byte("hello lan\n"))
+	time.Sleep(100 * time.Millisecond)
+
+	if !containsSubstring(bobOutput(), "[Alice]:hello lan") {
+		t.Errorf("Expected Bob to see Alice's message, got %q", bobOutput())
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	leaf.messageClients(bob, "\n[01-01-2025 10:00:00][Bob]:hello public", "")
+	if err := readUntil(reader, "[Bob]:hello public"); err != nil {
+		t.Errorf("Expected Alice to see Bob's message: %v", err)
+	}
+
+	// Let Alice's leave notice reach both logs before they are removed.
+	conn.Close()
+	for i := 0; i < 50 && !containsSubstring(bobOutput(), "Alice has left"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Messages are broadcast by writing to each recipient in turn, so one
+// client whose connection stops draining holds up everyone after it. The
+// watchdog notices deliveries that have been running for longer than
+// Config.WatchdogTimeout, logs what every goroutine is doing and, with
+// Config.WatchdogDisconnect, closes the stuck connection so the broadcast
+// can carry on.
+
+// watchdog tracks the deliveries in progress.
+type watchdog struct {
+	mu       sync.Mutex
+	next     uint64
+	inFlight map[uint64]*delivery
+}
+
+// delivery is one write to a client.
+type delivery struct {
+	client  *Client
+	started time.Time
+	// reported is set once the watchdog has logged the delivery.
+	reported bool
+}
+
+// begin records that a delivery to c has started, returning a token for
+// end.
+func (w *watchdog) begin(c *Client) uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.inFlight == nil {
+		w.inFlight = make(map[uint64]*delivery)
+	}
+	w.next++
+	w.inFlight[w.next] = &delivery{client: c, started: time.Now()}
+	return w.next
+}
+
+func (w *watchdog) end(token uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.inFlight, token)
+}
+
+// stuck returns the deliveries started before cutoff that have not been
+// reported yet, marking them reported, and how many are in progress.
+func (w *watchdog) stuck(cutoff time.Time) (stuck []delivery, inFlight int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, d := range w.inFlight {
+		if !d.reported && d.started.Before(cutoff) {
+			d.reported = true
+			stuck = append(stuck, *d)
+		}
+	}
+	return stuck, len(w.inFlight)
+}
+
+// checkDeliveries reports deliveries that have been stuck for longer than
+// the watchdog timeout to out, and disconnects their clients if the config
+// says to. It returns how many it found.
+func (s *Server) checkDeliveries(now time.Time, out io.Writer) int {
+	timeout := time.Duration(s.config.WatchdogTimeout)
+	stuck, inFlight := s.watch.stuck(now.Add(-timeout))
+	if len(stuck) == 0 {
+		return 0
+	}
+
+	fmt.Fprintf(out, "Watchdog: %d of %d deliveries stuck for over %s\n", len(stuck), inFlight, timeout)
+	for _, d := range stuck {
+		fmt.Fprintf(out, "  %s to %s (%s) since %s\n", d.client.connID, d.client.name.get(), d.client.ipAdd, d.started.Format(time.RFC3339))
+	}
+	fmt.Fprintln(out, s.budgetStats())
+	buf := make([]byte, 1<<20)
+	fmt.Fprintf(out, "%s\n", buf[:runtime.Stack(buf, true)])
+
+	if s.config.WatchdogDisconnect {
+		for _, d := range stuck {
+			fmt.Fprintf(out, "Watchdog: disconnecting %s\n", d.client.name.get())
+			s.closeClient(d.client)
+		}
+	}
+	return len(stuck)
+}
+
+// watchdogLoop checks for stuck deliveries until the server stops.
+func (s *Server) watchdogLoop() {
+	timeout := time.Duration(s.config.WatchdogTimeout)
+	if timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(max(timeout/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quitch:
+			return
+		case now := <-ticker.C:
+			s.checkDeliveries(now, os.Stdout)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that the watchdog reports a delivery to a client that stopped
+// reading, and disconnects it when configured to
+func TestWatchdogStuckDelivery(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.config.WatchdogTimeout = Duration(10 * time.Millisecond)
+	server.config.WatchdogDisconnect = true
+
+	// Nothing reads the other end of the pipe, so writes block.
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	stuck := mockClient("Stuck", "192.168.1.1", conn)
+	stuck.connID = "abcd1234"
+
+	done := make(chan struct{})
+	go func() {
+		server.deliver(&stuck, "hello", "", priorityChat)
+		close(done)
+	}()
+	time.Sleep(30 * time.Millisecond)
+
+	var out strings.Builder
+	if n := server.checkDeliveries(time.Now(), &out); n != 1 {
+		t.Fatalf("Expected one stuck delivery, got %d", n)
+	}
+	for _, want := range []string{"1 of 1 deliveries stuck", "abcd1234 to Stuck", "goroutine ", "disconnecting Stuck"} {
+		if !containsSubstring(out.String(), want) {
+			t.Errorf("Expected %q in the report", want)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the delivery to end once the client was disconnected.")
+	}
+	if n := server.checkDeliveries(time.Now(), &out); n != 0 {
+		t.Errorf("Expected nothing left to report, got %d", n)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxNameLength is the most characters a name may have.
+const maxNameLength = 32
+
+// checkName returns why name cannot be used to join, or nil if it can. Names
+// must be unique across this server and every server linked to it, and
+// cannot be used while kicked. Brackets and commas would be mistaken for
+// the log's "[name]:" and /msg's list of names, and a leading "/" for a
+// command.
+func (s *Server) checkName(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return newClientError(ErrCodeNameEmpty, "Name cannot be empty.")
+	case utf8.RuneCountInString(name) > maxNameLength:
+		return newClientError(ErrCodeNameInvalid, fmt.Sprintf("Name can be at most %d characters.", maxNameLength))
+	case strings.HasPrefix(name, "/"):
+		return newClientError(ErrCodeNameInvalid, "Name cannot start with /.")
+	case !utf8.ValidString(name) || strings.ContainsAny(name, "[],") || strings.ContainsFunc(name, unicode.IsControl):
+		return newClientError(ErrCodeNameInvalid, "Name cannot contain brackets, commas or control characters.")
+	case s.findClient(name) != nil || s.remoteServer(name) != "":
+		return newClientError(ErrCodeNameTaken, "Name "+name+" is already taken.")
+	}
+	if wait := s.kickedFor(kickNameKey(name)); wait > 0 {
+		return kickedError(wait)
+	}
+	return nil
+}
+
+// remoteServer returns the link through which name is present, or "".
+func (s *Server) remoteServer(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for peer, names := range s.remote {
+		if names[name] {
+			return peer
+		}
+	}
+	return ""
+}
+
+// clientDetails describes c's connection: how long it has been connected,
+// how many messages it has sent, how long it has been idle and which room
+// it is in, and with full, meant for operators, where it connected from
+// and how.
+func (s *Server) clientDetails(c *Client, full bool) string {
+	s.mu.Lock()
+	messages := c.messages
+	lastActivity := c.lastActivity
+	room := c.room
+	s.mu.Unlock()
+
+	details := fmt.Sprintf("connected %s, %d messages", time.Since(c.connectedAt).Round(time.Second), messages)
+	if idle := time.Since(lastActivity); !lastActivity.IsZero() && idle >= time.Minute {
+		details += ", idle " + idleTime(idle)
+	}
+	if room != "" {
+		details += ", in " + room
+	}
+	if full {
+		compression := c.compression
+		if compression == "" {
+			compression = "uncompressed"
+		}
+		from := s.redactAddr(c.ipAdd)
+		if c.host != "" && !s.config.RedactIPs {
+			from = c.host
+		}
+		if c.country != "" {
+			from += " (" + c.country + ")"
+		}
+		details += fmt.Sprintf(", from %s over %s, %s%s", from, c.network, compression, s.latencyDetails(c))
+	}
+	return details
+}
+
+// idleTime formats how long a client has been idle to the minute, such as
+// "12m" or "3h5m".
+func idleTime(idle time.Duration) string {
+	hours, minutes := int(idle.Hours()), int(idle.Minutes())%60
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes == 0:
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dh%dm", hours, minutes)
+}
+
+// onlineCount says how many users are in the chat, including those on
+// linked servers, as in "(7 users online)".
+func (s *Server) onlineCount() string {
+	n := s.clients.Count()
+	s.mu.Lock()
+	for _, names := range s.remote {
+		n += len(names)
+	}
+	s.mu.Unlock()
+
+	if n == 1 {
+		return "(1 user online)"
+	}
+	return fmt.Sprintf("(%d users online)", n)
+}
+
+// othersHere returns the names of everyone in the chat except name, local
+// users first, each group sorted.
+func (s *Server) othersHere(name string) []string {
+	var local []string
+	for _, c := range s.clients.All() {
+		if c.name.get() != name {
+			local = append(local, c.name.get())
+		}
+	}
+	sort.Strings(local)
+
+	var remote []string
+	s.mu.Lock()
+	for _, names := range s.remote {
+		for n, online := range names {
+			if online && n != name {
+				remote = append(remote, n)
+			}
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(remote)
+	return append(local, remote...)
+}
+
+// currentlyHere is the summary a client joining as name is sent, such as
+// "Currently here: alice, bob, carol".
+func (s *Server) currentlyHere(name string) string {
+	others := s.othersHere(name)
+	if len(others) == 0 {
+		others = []string{"nobody else"}
+	}
+	return s.mustText("currently_here", map[string]string{"Names": strings.Join(others, ", ")})
+}
+
+// whoList describes everyone in the chat: local users first, with the
+// details clientDetails gives, then users on linked servers grouped by
+// link. A name present on both sides of a healed netsplit is shown
+// qualified with its server so the two can be told apart.
+func (s *Server) whoList(full bool) string {
+	var local []string
+	details := make(map[string]string)
+	for _, c := range s.clients.All() {
+		local = append(local, c.name.get())
+		details[c.name.get()] = s.clientDetails(c, full)
+	}
+
+	s.mu.Lock()
+	var peers []string
+	remote := make(map[string][]string)
+	for peer, names := range s.remote {
+		for name := range names {
+			remote[peer] = append(remote[peer], name)
+		}
+		if len(remote[peer]) > 0 {
+			peers = append(peers, peer)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(peers)
+
+	conflicts := make(map[string]bool)
+	for _, names := range remote {
+		for _, name := range names {
+			for _, l := range local {
+				conflicts[name] = conflicts[name] || name == l
+			}
+		}
+	}
+
+	qualify := func(name, server string) string {
+		if conflicts[name] {
+			return name + "@" + server
+		}
+		return name
+	}
+
+	var parts []string
+	var here []string
+	for _, name := range local {
+		here = append(here, qualify(name, s.config.ServerName)+" ("+details[name]+")")
+	}
+	parts = append(parts, "here: "+strings.Join(here, ", "))
+	for _, peer := range peers {
+		var names []string
+		for _, name := range remote[peer] {
+			names = append(names, qualify(name, peer))
+		}
+		sort.Strings(names)
+		parts = append(parts, "via "+peer+": "+strings.Join(names, ", "))
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Telnet bytes used to negotiate the window size (NAWS, RFC 1073).
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+	telnetNAWS = 31
+)
+
+// askWindowSize is sent to new connections when Config.TelnetNAWS is set,
+// asking telnet clients to report their terminal width.
+var askWindowSize = []byte{telnetIAC, telnetDO, telnetNAWS}
+
+// minWidth and maxWidth bound the wrapping width a client can set.
+const (
+	minWidth = 20
+	maxWidth = 1000
+)
+
+// stripTelnet removes telnet commands from data, returning the text left
+// and the terminal width from a NAWS report, or 0 if there was none.
+func stripTelnet(data []byte) ([]byte, int) {
+	if bytes.IndexByte(data, telnetIAC) < 0 {
+		return data, 0
+	}
+
+	text := make([]byte, 0, len(data))
+	width := 0
+	for i := 0; i < len(data); i++ {
+		if data[i] != telnetIAC || i+1 >= len(data) {
+			text = append(text, data[i])
+			continue
+		}
+		i++
+		switch cmd := data[i]; {
+		case cmd == telnetIAC:
+			text = append(text, telnetIAC)
+		case cmd >= telnetWILL && cmd <= telnetDONT:
+			i++
+		case cmd == telnetSB:
+			var sub []byte
+			for i++; i < len(data); i++ {
+				if data[i] == telnetIAC && i+1 < len(data) {
+					i++
+					if data[i] == telnetSE {
+						break
+					}
+				}
+				sub = append(sub, data[i])
+			}
+			if len(sub) == 5 && sub[0] == telnetNAWS {
+				width = int(sub[1])<<8 | int(sub[2])
+			}
+		}
+	}
+	return text, width
+}
+
+// wrapText breaks lines of text longer than width at spaces, or mid-word
+// when a word alone is too long.
+func wrapText(text string, width int) string {
+	if width <= 0 {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		var b strings.Builder
+		for utf8.RuneCountInString(line) > width {
+			cut := len(line)
+			for n, j := 0, 0; j < len(line); n++ {
+				if n == width {
+					cut = j
+					break
+				}
+				_, size := utf8.DecodeRuneInString(line[j:])
+				j += size
+			}
+			next := cut
+			if space := strings.LastIndexByte(line[:cut+1], ' '); space > 0 {
+				cut, next = space, space+1
+			}
+			b.WriteString(line[:cut] + "\n")
+			line = line[next:]
+		}
+		b.WriteString(line)
+		lines[i] = b.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// setWidth records the terminal width reported for client.
+func (s *Server) setWidth(client *Client, width int) {
+	s.mu.Lock()
+	client.width = min(max(width, minWidth), maxWidth)
+	s.mu.Unlock()
+}
+
+// saveWidth keeps the width client chose with /width for its next visit.
+func (s *Server) saveWidth(client *Client) {
+	s.mu.Lock()
+	width := client.width
+	s.mu.Unlock()
+	if err := s.setPref(client.name.get(), func(p *userPrefs) { p.Width = &width }); err != nil {
+		fmt.Println("Error saving server state:", err)
+	}
+}
+
+func cmdWidth(s *Server, client *Client, args []string) {
+	if len(args) != 1 {
+		s.replyUsage(client, "/width")
+		return
+	}
+	if args[0] == "off" {
+		s.mu.Lock()
+		client.width = 0
+		s.mu.Unlock()
+		s.saveWidth(client)
+		s.reply(client, "wrapping off")
+		return
+	}
+
+	width, err := strconv.Atoi(args[0])
+	if err != nil || width < minWidth || width > maxWidth {
+		s.replyError(client, newClientError(ErrCodeInvalidArgument, "width must be a number of columns from "+strconv.Itoa(minWidth)+" to "+strconv.Itoa(maxWidth)))
+		return
+	}
+	s.setWidth(client, width)
+	s.saveWidth(client)
+	s.reply(client, "wrapping messages at "+args[0]+" columns")
+}
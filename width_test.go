@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  string
+	}{
+		{"short line", 20, "short line"},
+		{"the quick brown fox jumps", 10, "the quick\nbrown fox\njumps"},
+		{"abcdefghijkl", 5, "abcde\nfghij\nkl"},
+		{"\n[10:00][Al]:héllo wörld", 12, "\n[10:00][Al]:\nhéllo wörld"},
+		{"no wrapping", 0, "no wrapping"},
+	}
+	for _, tt := range tests {
+		if got := wrapText(tt.text, tt.width); got != tt.want {
+			t.Errorf("wrapText(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestStripTelnet(t *testing.T) {
+	data := []byte{'B', 'o', telnetIAC, telnetWILL, telnetNAWS, telnetIAC, telnetSB, telnetNAWS, 0, 100, 0, 24, telnetIAC, telnetSE, 'b', '\r', '\n'}
+	text, width := stripTelnet(data)
+	if string(text) != "Bob\r\n" || width != 100 {
+		t.Errorf("Expected \"Bob\\r\\n\" and width 100, got %q and %d", text, width)
+	}
+
+	if text, width := stripTelnet([]byte("plain")); string(text) != "plain" || width != 0 {
+		t.Errorf("Expected plain text untouched, got %q and %d", text, width)
+	}
+}
+
+// Test that messages are wrapped for clients that set a width
+func TestWidthCommand(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+
+	alice, _ := pipeClient(t, "Alice", "192.168.1.1")
+	bob, bobOutput := pipeClient(t, "Bob", "192.168.1.2")
+	a := server.addClient(alice)
+	b := server.addClient(bob)
+
+	server.handleCommand(b, "/width 5")
+	if !containsSubstring(bobOutput(), ErrCodeInvalidArgument) {
+		t.Errorf("Expected a too narrow width to be refused, got %q", bobOutput())
+	}
+
+	server.handleCommand(b, "/width 20")
+	server.messageClients(*a, "\n[10:00][Alice]:one two three four five", "")
+	if !containsSubstring(bobOutput(), "\n[10:00][Alice]:one\ntwo three four five") {
+		t.Errorf("Expected the message wrapped at 20 columns, got %q", bobOutput())
+	}
+}